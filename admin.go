@@ -0,0 +1,196 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/philipch07/EggsFM/internal/webrtc"
+)
+
+// admin API for operators, everything under /api/admin/ requires
+// "Authorization: Bearer $ADMIN_TOKEN". Without ADMIN_TOKEN it's disabled.
+//
+//	GET  /api/admin/status          playhead, queue + running order
+//	POST /api/admin/skip
+//	POST /api/admin/pause
+//	POST /api/admin/resume
+//	POST /api/admin/jump            {"index": 3} or {"path": "media/x.opus"}
+//	POST /api/admin/seek            {"offset": "1m30s"}
+//	POST /api/admin/queue           {"path": "artist/album/x.opus"}
+//	POST /api/admin/queue/move      {"id": "1", "position": 0}
+//	POST /api/admin/queue/remove    {"id": "1"}
+
+type adminJumpRequest struct {
+	Index *int   `json:"index"`
+	Path  string `json:"path"`
+}
+
+type adminSeekRequest struct {
+	Offset string `json:"offset"`
+}
+
+type adminQueueRequest struct {
+	ID       string `json:"id"`
+	Path     string `json:"path"`
+	Position int    `json:"position"`
+}
+
+func adminHandler() http.HandlerFunc {
+	token := strings.TrimSpace(os.Getenv("ADMIN_TOKEN"))
+	if token == "" {
+		log.Println("ADMIN_TOKEN not set; admin API disabled")
+	}
+
+	return func(res http.ResponseWriter, req *http.Request) {
+		if token == "" {
+			http.NotFound(res, req)
+			return
+		}
+		if !adminAuthorized(req, token) {
+			res.Header().Set("WWW-Authenticate", `Bearer realm="eggsfm admin"`)
+			http.Error(res, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		action := strings.Trim(strings.TrimPrefix(req.URL.Path, "/api/admin/"), "/")
+		if action == "status" {
+			if req.Method != http.MethodGet {
+				http.Error(res, "Method Not Allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			writeJSON(res, http.StatusOK, webrtc.GetAutoplayStatus())
+			return
+		}
+
+		if req.Method != http.MethodPost {
+			http.Error(res, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var err error
+		switch action {
+		case "skip":
+			err = webrtc.SkipTrack()
+		case "pause":
+			err = webrtc.PauseAutoplay()
+		case "resume":
+			webrtc.ResumeAutoplay()
+		case "jump":
+			var body adminJumpRequest
+			if err = decodeAdminBody(res, req, &body); err == nil {
+				switch {
+				case body.Path != "":
+					err = webrtc.JumpToTrack(-1, body.Path)
+				case body.Index != nil:
+					err = webrtc.JumpToTrack(*body.Index, "")
+				default:
+					err = errors.New("index or path is required")
+				}
+			}
+		case "seek":
+			var body adminSeekRequest
+			if err = decodeAdminBody(res, req, &body); err == nil {
+				var offset time.Duration
+				if offset, err = parseAdminOffset(body.Offset); err == nil {
+					err = webrtc.SeekTrack(offset)
+				}
+			}
+		case "queue":
+			var body adminQueueRequest
+			if err = decodeAdminBody(res, req, &body); err == nil {
+				var item webrtc.QueueItem
+				if item, err = webrtc.EnqueueTrack(body.Path); err == nil {
+					writeJSON(res, http.StatusCreated, item)
+					return
+				}
+			}
+		case "queue/move":
+			var body adminQueueRequest
+			if err = decodeAdminBody(res, req, &body); err == nil {
+				err = webrtc.MoveQueueItem(body.ID, body.Position)
+			}
+		case "queue/remove":
+			var body adminQueueRequest
+			if err = decodeAdminBody(res, req, &body); err == nil {
+				err = webrtc.RemoveQueueItem(body.ID)
+			}
+		default:
+			http.NotFound(res, req)
+			return
+		}
+
+		if err != nil {
+			logHTTPError(res, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		writeJSON(res, http.StatusOK, webrtc.GetAutoplayStatus())
+	}
+}
+
+func adminAuthorized(req *http.Request, token string) bool {
+	auth := req.Header.Get("Authorization")
+	got, ok := strings.CutPrefix(auth, "Bearer ")
+	if !ok {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(strings.TrimSpace(got)), []byte(token)) == 1
+}
+
+// icecastStatsHandler guards /admin/stats the way Icecast tools expect: basic
+// auth (any user) or a bearer token, with ADMIN_TOKEN as the password.
+func icecastStatsHandler(next http.Handler) http.HandlerFunc {
+	token := strings.TrimSpace(os.Getenv("ADMIN_TOKEN"))
+
+	return func(res http.ResponseWriter, req *http.Request) {
+		if token == "" {
+			http.NotFound(res, req)
+			return
+		}
+
+		_, pass, basic := req.BasicAuth()
+		if !adminAuthorized(req, token) && (!basic || subtle.ConstantTimeCompare([]byte(pass), []byte(token)) != 1) {
+			res.Header().Set("WWW-Authenticate", `Basic realm="Icecast2 Server"`)
+			http.Error(res, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(res, req)
+	}
+}
+
+func decodeAdminBody(res http.ResponseWriter, req *http.Request, v any) error {
+	dec := json.NewDecoder(http.MaxBytesReader(res, req.Body, 1<<16))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return errors.New("invalid request body: " + err.Error())
+	}
+	return nil
+}
+
+// parseAdminOffset accepts Go durations ("1m30s") or bare seconds ("90").
+func parseAdminOffset(raw string) (time.Duration, error) {
+	raw = strings.TrimSpace(raw)
+	if d, err := time.ParseDuration(raw); err == nil {
+		return d, nil
+	}
+	if secs, err := strconv.ParseFloat(raw, 64); err == nil {
+		return time.Duration(secs * float64(time.Second)), nil
+	}
+	return 0, errors.New("invalid offset " + strconv.Quote(raw))
+}
+
+func writeJSON(res http.ResponseWriter, code int, v any) {
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(code)
+	if err := json.NewEncoder(res).Encode(v); err != nil {
+		log.Println(err)
+	}
+}
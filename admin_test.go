@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/philipch07/EggsFM/internal/webrtc"
+)
+
+func adminRequest(t *testing.T, handler http.HandlerFunc, method, action, token, body string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req := httptest.NewRequest(method, "/api/admin/"+action, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	res := httptest.NewRecorder()
+	handler(res, req)
+	return res
+}
+
+func TestAdminAuth(t *testing.T) {
+	for _, tc := range []struct {
+		name, configured, sent string
+		expected               int
+	}{
+		{"disabled", "", "secret", http.StatusNotFound},
+		{"no token", "secret", "", http.StatusUnauthorized},
+		{"wrong token", "secret", "guess", http.StatusUnauthorized},
+		{"right token", "secret", "secret", http.StatusOK},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("ADMIN_TOKEN", tc.configured)
+
+			res := adminRequest(t, adminHandler(), http.MethodGet, "status", tc.sent, "")
+			if res.Code != tc.expected {
+				t.Fatalf("expected status %d but got %d", tc.expected, res.Code)
+			}
+			if tc.expected == http.StatusUnauthorized && !strings.HasPrefix(res.Header().Get("WWW-Authenticate"), "Bearer ") {
+				t.Fatalf("expected a bearer challenge but got %q", res.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
+
+// without a running loop the controls that act on what's on air are refused,
+// the queue still takes changes for when it starts.
+func TestAdminActions(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "secret")
+	handler := adminHandler()
+
+	for _, tc := range []struct {
+		name, method, action, body string
+		expected                   int
+	}{
+		{"skip", http.MethodPost, "skip", "", http.StatusBadRequest},
+		{"pause", http.MethodPost, "pause", "", http.StatusBadRequest},
+		{"resume", http.MethodPost, "resume", "", http.StatusOK},
+		{"jump by index", http.MethodPost, "jump", `{"index": 0}`, http.StatusBadRequest},
+		{"jump by path", http.MethodPost, "jump", `{"path": "media/a.opus"}`, http.StatusBadRequest},
+		{"jump without target", http.MethodPost, "jump", `{}`, http.StatusBadRequest},
+		{"seek", http.MethodPost, "seek", `{"offset": "1m30s"}`, http.StatusBadRequest},
+		{"seek bad offset", http.MethodPost, "seek", `{"offset": "soon"}`, http.StatusBadRequest},
+		{"unknown field", http.MethodPost, "seek", `{"offset": "90", "at": 1}`, http.StatusBadRequest},
+		{"move unknown item", http.MethodPost, "queue/move", `{"id": "nope", "position": 0}`, http.StatusBadRequest},
+		{"remove unknown item", http.MethodPost, "queue/remove", `{"id": "nope"}`, http.StatusBadRequest},
+		{"get on an action", http.MethodGet, "skip", "", http.StatusMethodNotAllowed},
+		{"post on status", http.MethodPost, "status", "", http.StatusMethodNotAllowed},
+		{"unknown action", http.MethodPost, "rewind", "", http.StatusNotFound},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			res := adminRequest(t, handler, tc.method, tc.action, "secret", tc.body)
+			if res.Code != tc.expected {
+				t.Fatalf("expected status %d but got %d: %s", tc.expected, res.Code, res.Body.String())
+			}
+		})
+	}
+}
+
+func TestAdminQueue(t *testing.T) {
+	dir := t.TempDir()
+	index := filepath.Join(dir, "index.json")
+	t.Setenv("ADMIN_TOKEN", "secret")
+	t.Setenv("LIBRARY_INDEX", index)
+	t.Setenv("TRANSCODE", "off")
+	handler := adminHandler()
+
+	track := filepath.Join(dir, "a.opus")
+	if err := os.WriteFile(track, []byte("not really opus"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	queued := func() []string {
+		t.Helper()
+
+		var st webrtc.AutoplayStatus
+		res := adminRequest(t, handler, http.MethodGet, "status", "secret", "")
+		if err := json.NewDecoder(res.Body).Decode(&st); err != nil {
+			t.Fatalf("expected a status body but got %v", err)
+		}
+		ids := []string{}
+		for _, item := range st.Queue {
+			ids = append(ids, item.ID)
+		}
+		return ids
+	}
+
+	ids := []string{}
+	for range 2 {
+		res := adminRequest(t, handler, http.MethodPost, "queue", "secret", `{"path": "`+track+`"}`)
+		if res.Code != http.StatusCreated {
+			t.Fatalf("expected status %d but got %d: %s", http.StatusCreated, res.Code, res.Body.String())
+		}
+		var item webrtc.QueueItem
+		if err := json.NewDecoder(res.Body).Decode(&item); err != nil {
+			t.Fatalf("expected a queue item but got %v", err)
+		}
+		ids = append(ids, item.ID)
+	}
+	t.Cleanup(func() {
+		for _, id := range ids {
+			_ = webrtc.RemoveQueueItem(id)
+		}
+	})
+
+	if res := adminRequest(t, handler, http.MethodPost, "queue", "secret", `{"path": "notes.txt"}`); res.Code != http.StatusBadRequest {
+		t.Fatalf("expected status %d but got %d", http.StatusBadRequest, res.Code)
+	}
+
+	if res := adminRequest(t, handler, http.MethodPost, "queue/move", "secret", `{"id": "`+ids[1]+`", "position": 0}`); res.Code != http.StatusOK {
+		t.Fatalf("expected status %d but got %d: %s", http.StatusOK, res.Code, res.Body.String())
+	}
+	if got := queued(); len(got) != 2 || got[0] != ids[1] || got[1] != ids[0] {
+		t.Fatalf("expected queue [%s %s] but got %v", ids[1], ids[0], got)
+	}
+
+	if res := adminRequest(t, handler, http.MethodPost, "queue/remove", "secret", `{"id": "`+ids[1]+`"}`); res.Code != http.StatusOK {
+		t.Fatalf("expected status %d but got %d: %s", http.StatusOK, res.Code, res.Body.String())
+	}
+	if got := queued(); len(got) != 1 || got[0] != ids[0] {
+		t.Fatalf("expected queue [%s] but got %v", ids[0], got)
+	}
+
+	// the index is saved in the background, don't let the temp dir go first
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if _, err := os.Stat(index); err == nil {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("expected the library index to be saved to %s", index)
+}
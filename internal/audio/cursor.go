@@ -41,6 +41,15 @@ func (c *Cursor) Advance(d time.Duration) time.Duration {
 	return pos
 }
 
+// Reset moves the cursor onto a new timeline, e.g. one anchored to a shared
+// epoch so that every server reports the same position.
+func (c *Cursor) Reset(startedAt time.Time, position time.Duration) {
+	c.mu.Lock()
+	c.started = startedAt
+	c.position = max(position, 0)
+	c.mu.Unlock()
+}
+
 // Position returns the current offset from the start of the stream.
 func (c *Cursor) Position() time.Duration {
 	c.mu.Lock()
@@ -0,0 +1,158 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// an ogg page is at most 27 + 255 + 255*255 bytes
+const maxOggPageSize = 27 + 255 + 255*255
+
+// OpusDuration returns the playable length of an Ogg Opus file, computed from
+// the granule position of the last page minus the OpusHead pre-skip.
+func OpusDuration(opusFile *os.File) (time.Duration, error) {
+	preSkip, err := readPreSkip(opusFile)
+	if err != nil {
+		return 0, err
+	}
+
+	lastGranule, err := readLastGranule(opusFile)
+	if err != nil {
+		return 0, err
+	}
+
+	if lastGranule <= preSkip {
+		return 0, nil
+	}
+
+	// granule positions are always in 48kHz samples for opus (RFC 7845).
+	// split into whole seconds first so very long files can't overflow.
+	samples := lastGranule - preSkip
+	return time.Duration(samples/48000)*time.Second + time.Duration(samples%48000)*time.Second/48000, nil
+}
+
+func readPreSkip(opusFile *os.File) (uint64, error) {
+	if _, err := opusFile.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	var header [27]byte
+	if _, err := io.ReadFull(opusFile, header[:]); err != nil {
+		return 0, err
+	}
+	if !bytes.Equal(header[0:4], []byte("OggS")) {
+		return 0, fmt.Errorf("invalid ogg capture pattern: %q", header[0:4])
+	}
+
+	var segArr [255]byte
+	segTable := segArr[:int(header[26])]
+	if _, err := io.ReadFull(opusFile, segTable); err != nil {
+		return 0, err
+	}
+
+	// OpusHead is always alone on the first page and is never larger than one segment
+	// for mapping family 0/1, so the first lacing value is enough.
+	if len(segTable) == 0 || segTable[0] < 12 {
+		return 0, errors.New("first ogg page does not contain an OpusHead")
+	}
+
+	head := make([]byte, int(segTable[0]))
+	if _, err := io.ReadFull(opusFile, head); err != nil {
+		return 0, err
+	}
+	if !bytes.Equal(head[:8], opusHeadSig[:]) {
+		return 0, errors.New("first ogg page does not contain an OpusHead")
+	}
+
+	// preSkip is LE u16 at offset 10 (8 sig + 1 ver + 1 ch)
+	return uint64(binary.LittleEndian.Uint16(head[10:12])), nil
+}
+
+func readLastGranule(opusFile *os.File) (uint64, error) {
+	size, err := opusFile.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+
+	start := max(size-maxOggPageSize, 0)
+	if _, err := opusFile.Seek(start, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	tail := make([]byte, size-start)
+	if _, err := io.ReadFull(opusFile, tail); err != nil {
+		return 0, err
+	}
+
+	// walk backwards over capture patterns until we hit a page with a real
+	// granule. "OggS" can turn up inside audio data too, so a match only
+	// counts if the whole page is there and its crc checks out.
+	end := len(tail)
+	for {
+		idx := bytes.LastIndex(tail[:end], []byte("OggS"))
+		if idx < 0 {
+			return 0, errors.New("no ogg page with a granule position found")
+		}
+		if page, ok := oggPageAt(tail[idx:]); ok {
+			granule := binary.LittleEndian.Uint64(page[6:14])
+			// -1 means no packet finishes on this page
+			if granule != ^uint64(0) {
+				return granule, nil
+			}
+		}
+		end = idx
+	}
+}
+
+// oggPageAt returns the page b starts with, if b holds all of a valid one.
+func oggPageAt(b []byte) ([]byte, bool) {
+	if len(b) < 27 || b[4] != 0 {
+		return nil, false
+	}
+	size := 27 + int(b[26])
+	if len(b) < size {
+		return nil, false
+	}
+	for _, lace := range b[27:size] {
+		size += int(lace)
+	}
+	if len(b) < size {
+		return nil, false
+	}
+
+	page := b[:size]
+	want := binary.LittleEndian.Uint32(page[22:26])
+	return page, oggCRC(page) == want
+}
+
+var oggCRCTable = func() (t [256]uint32) {
+	for i := range t {
+		r := uint32(i) << 24
+		for range 8 {
+			if r&0x80000000 != 0 {
+				r = r<<1 ^ 0x04c11db7
+			} else {
+				r <<= 1
+			}
+		}
+		t[i] = r
+	}
+	return t
+}()
+
+// oggCRC is the page checksum, computed as if the crc field were zero.
+func oggCRC(page []byte) uint32 {
+	var crc uint32
+	for i, v := range page {
+		if i >= 22 && i < 26 {
+			v = 0
+		}
+		crc = crc<<8 ^ oggCRCTable[byte(crc>>24)^v]
+	}
+	return crc
+}
@@ -0,0 +1,92 @@
+package audio
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testPage(granule uint64, seq uint32, packets ...[]byte) []byte {
+	var lacing, body []byte
+	for _, p := range packets {
+		n := len(p)
+		for ; n >= 255; n -= 255 {
+			lacing = append(lacing, 255)
+		}
+		lacing = append(lacing, byte(n))
+		body = append(body, p...)
+	}
+	page := append([]byte("OggS"), make([]byte, 22)...)
+	binary.LittleEndian.PutUint64(page[6:], granule)
+	binary.LittleEndian.PutUint32(page[18:], seq)
+	page = append(page, byte(len(lacing)))
+	page = append(page, lacing...)
+	page = append(page, body...)
+	binary.LittleEndian.PutUint32(page[22:], oggCRC(page))
+	return page
+}
+
+func TestOpusDuration(t *testing.T) {
+	// pre-skip 312
+	head := []byte{'O', 'p', 'u', 's', 'H', 'e', 'a', 'd', 1, 2, 0x38, 0x01, 0x80, 0xbb, 0, 0, 0, 0, 0}
+	header := append(testPage(0, 0, head), testPage(0, 1, []byte("OpusTags"))...)
+
+	// audio that happens to contain a capture pattern, with a bogus granule
+	// after it.
+	fake := append([]byte("xxOggS\x00\x00"), make([]byte, 200)...)
+	binary.LittleEndian.PutUint64(fake[8:], 1<<40)
+
+	tests := []struct {
+		name     string
+		pages    [][]byte
+		expected time.Duration
+	}{
+		{
+			name:     "Last Granule Minus Pre-skip",
+			pages:    [][]byte{testPage(48000, 2, make([]byte, 100)), testPage(96312, 3, make([]byte, 100))},
+			expected: 2 * time.Second,
+		},
+		{
+			name:     "OggS Inside Audio",
+			pages:    [][]byte{testPage(48312, 2, fake)},
+			expected: time.Second,
+		},
+		{
+			name:     "Truncated Last Page",
+			pages:    [][]byte{testPage(48312, 2, make([]byte, 100)), testPage(96312, 3, make([]byte, 100))[:40]},
+			expected: time.Second,
+		},
+		{
+			name:     "Only Headers",
+			expected: 0,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			raw := append([]byte(nil), header...)
+			for _, p := range tc.pages {
+				raw = append(raw, p...)
+			}
+			path := filepath.Join(t.TempDir(), "track.opus")
+			if err := os.WriteFile(path, raw, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			f, err := os.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() { _ = f.Close() }()
+
+			got, err := OpusDuration(f)
+			if err != nil {
+				t.Fatalf("expected no error but got %v", err)
+			}
+			if got != tc.expected {
+				t.Fatalf("expected %s but got %s", tc.expected, got)
+			}
+		})
+	}
+}
@@ -17,6 +17,9 @@ var (
 
 type OggOpusPacketReader struct {
 	bufioReader *bufio.Reader
+	// gets every page once it has been read in full, see Tee.
+	tee  io.Writer
+	page []byte
 
 	// In-progress audio packet that continues across pages.
 	carry []byte
@@ -59,6 +62,12 @@ func NewOggOpusPacketReader(ioReader io.Reader, sampleRate uint32) *OggOpusPacke
 	}
 }
 
+// Tee writes every page to w, whole, as it's parsed. w gets the stream one page
+// at a time at the pace packets are taken, never the bufio read-ahead.
+func (o *OggOpusPacketReader) Tee(w io.Writer) {
+	o.tee = w
+}
+
 func (o *OggOpusPacketReader) Next() ([]byte, time.Duration, uint64, error) {
 	for {
 		if o.qHead < len(o.queue) {
@@ -160,6 +169,13 @@ func (o *OggOpusPacketReader) appendNextAudioPagePacketsToQueue() (granule uint6
 		return 0, 0, 0, err
 	}
 
+	if o.tee != nil {
+		o.page = append(append(append(o.page[:0], o.header[:]...), segTable...), o.buf...)
+		if _, err = o.tee.Write(o.page); err != nil {
+			return 0, 0, 0, err
+		}
+	}
+
 	initLen = len(o.queue)
 
 	pkt := o.carry
@@ -0,0 +1,63 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// oggPage builds a single-packet page; the reader doesn't check the crc.
+func oggPage(granule uint64, packet []byte) []byte {
+	var segs []byte
+	n := len(packet)
+	for n >= 255 {
+		segs = append(segs, 255)
+		n -= 255
+	}
+	segs = append(segs, byte(n))
+
+	page := []byte{'O', 'g', 'g', 'S', 0, 0}
+	page = binary.LittleEndian.AppendUint64(page, granule)
+	page = append(page, make([]byte, 12)...) // serial, sequence, crc
+	page = append(page, byte(len(segs)))
+	page = append(page, segs...)
+	return append(page, packet...)
+}
+
+func TestOggOpusPacketReaderTee(t *testing.T) {
+	head := oggPage(0, append([]byte("OpusHead"), 1, 2, 0x38, 0x01, 0x80, 0xbb, 0, 0, 0, 0, 0))
+	tags := oggPage(0, append([]byte("OpusTags"), 0, 0, 0, 0, 0, 0, 0, 0))
+	pages := [][]byte{
+		oggPage(312+960, bytes.Repeat([]byte{1}, 300)),
+		oggPage(312+1920, bytes.Repeat([]byte{2}, 40)),
+		oggPage(312+2880, bytes.Repeat([]byte{3}, 40)),
+	}
+
+	var stream bytes.Buffer
+	stream.Write(head)
+	stream.Write(tags)
+	for _, p := range pages {
+		stream.Write(p)
+	}
+
+	var tee bytes.Buffer
+	r := NewOggOpusPacketReader(&stream, 48000)
+	r.Tee(&tee)
+
+	expected := append(append([]byte{}, head...), tags...)
+	for i, p := range pages {
+		pkt, _, _, err := r.Next()
+		if err != nil {
+			t.Fatalf("expected packet %d but got %v", i, err)
+		}
+		if pkt[0] != byte(i+1) {
+			t.Fatalf("expected packet %d but got one starting with %d", i, pkt[0])
+		}
+
+		// only the pages parsed so far, never what bufio read ahead.
+		expected = append(expected, p...)
+		if !bytes.Equal(tee.Bytes(), expected) {
+			t.Fatalf("expected %d teed bytes after packet %d but got %d", len(expected), i, tee.Len())
+		}
+	}
+}
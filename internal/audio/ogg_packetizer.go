@@ -0,0 +1,97 @@
+package audio
+
+import (
+	"bytes"
+)
+
+// maxPendingOggBytes is how much of a page that never completes we hold on to.
+const maxPendingOggBytes = 1 << 20
+
+// OggOpusPacketizer turns Ogg Opus bytes, in whatever chunks they arrive, into
+// Opus audio packets. OpusHead/OpusTags are skipped, so chained streams (one
+// per track) come out as a single run of packets.
+type OggOpusPacketizer struct {
+	// OnHead, if set, gets every OpusHead packet, i.e. one per chained stream.
+	OnHead func(head []byte)
+
+	pending    []byte
+	carry      []byte // packet continued on the next page
+	discarding bool   // in a header packet that spans pages
+}
+
+// Feed hands every complete audio packet in chunk to fn. Packets are copies
+// and safe to keep.
+func (o *OggOpusPacketizer) Feed(chunk []byte, fn func(pkt []byte)) {
+	o.pending = append(o.pending, chunk...)
+
+	for {
+		start := bytes.Index(o.pending, []byte("OggS"))
+		if start < 0 {
+			// keep a possibly cut off "OggS".
+			keep := min(len(o.pending), 3)
+			o.pending = append(o.pending[:0], o.pending[len(o.pending)-keep:]...)
+			return
+		}
+		page := o.pending[start:]
+
+		if len(page) < 27 || len(page) < 27+int(page[26]) {
+			o.compact(start)
+			return
+		}
+		segs := page[27 : 27+int(page[26])]
+		size := 27 + len(segs)
+		for _, lace := range segs {
+			size += int(lace)
+		}
+		if len(page) < size {
+			o.compact(start)
+			return
+		}
+
+		// a page that doesn't continue a packet drops whatever was carried.
+		if page[5]&0x01 == 0 {
+			o.carry = nil
+			o.discarding = false
+		}
+		o.packets(segs, page[27+len(segs):size], fn)
+
+		o.pending = append(o.pending[:0], page[size:]...)
+	}
+}
+
+func (o *OggOpusPacketizer) compact(start int) {
+	o.pending = append(o.pending[:0], o.pending[start:]...)
+	if len(o.pending) > maxPendingOggBytes {
+		o.pending = o.pending[:0]
+	}
+}
+
+func (o *OggOpusPacketizer) packets(segs, body []byte, fn func(pkt []byte)) {
+	off := 0
+	for _, lace := range segs {
+		if !o.discarding {
+			o.carry = append(o.carry, body[off:off+int(lace)]...)
+			// OpusTags can be large (cover art), so it isn't held on to.
+			if bytes.HasPrefix(o.carry, opusTagsSig[:]) {
+				o.carry = nil
+				o.discarding = true
+			}
+		}
+		off += int(lace)
+
+		if lace == 255 {
+			continue
+		}
+		switch {
+		case o.discarding:
+			o.discarding = false
+		case bytes.HasPrefix(o.carry, opusHeadSig[:]):
+			if o.OnHead != nil {
+				o.OnHead(o.carry)
+			}
+		case len(o.carry) > 0:
+			fn(o.carry)
+		}
+		o.carry = nil
+	}
+}
@@ -0,0 +1,37 @@
+package audio
+
+import "time"
+
+// OpusPacketDuration returns how much audio a single Opus packet holds, read
+// from its TOC byte (RFC 6716 section 3.1). It returns 0 for a malformed packet.
+func OpusPacketDuration(pkt []byte) time.Duration {
+	if len(pkt) == 0 {
+		return 0
+	}
+
+	toc := pkt[0]
+	config := toc >> 3
+
+	var frame time.Duration
+	switch {
+	case config < 12: // SILK
+		frame = [...]time.Duration{10, 20, 40, 60}[config%4] * time.Millisecond
+	case config < 16: // hybrid
+		frame = [...]time.Duration{10, 20}[config%2] * time.Millisecond
+	default: // CELT
+		frame = [...]time.Duration{2500, 5000, 10000, 20000}[config%4] * time.Microsecond
+	}
+
+	frames := 1
+	switch toc & 0x03 {
+	case 1, 2:
+		frames = 2
+	case 3:
+		if len(pkt) < 2 {
+			return 0
+		}
+		frames = int(pkt[1] & 0x3f)
+	}
+
+	return frame * time.Duration(frames)
+}
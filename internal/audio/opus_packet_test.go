@@ -0,0 +1,40 @@
+package audio
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOpusPacketDuration(t *testing.T) {
+	toc := func(config, code byte) byte { return config<<3 | code }
+
+	for _, tc := range []struct {
+		name     string
+		pkt      []byte
+		expected time.Duration
+	}{
+		{"empty", nil, 0},
+		{"silk 10ms", []byte{toc(0, 0)}, 10 * time.Millisecond},
+		{"silk 20ms", []byte{toc(1, 0)}, 20 * time.Millisecond},
+		{"silk 40ms", []byte{toc(2, 0)}, 40 * time.Millisecond},
+		{"silk 60ms", []byte{toc(11, 0)}, 60 * time.Millisecond},
+		{"hybrid 10ms", []byte{toc(12, 0)}, 10 * time.Millisecond},
+		{"hybrid 20ms", []byte{toc(15, 0)}, 20 * time.Millisecond},
+		{"celt 2.5ms", []byte{toc(16, 0)}, 2500 * time.Microsecond},
+		{"celt 5ms", []byte{toc(29, 0)}, 5 * time.Millisecond},
+		{"celt 10ms", []byte{toc(30, 0)}, 10 * time.Millisecond},
+		{"celt 20ms", []byte{toc(31, 0)}, 20 * time.Millisecond},
+		{"two equal frames", []byte{toc(31, 1)}, 40 * time.Millisecond},
+		{"two different frames", []byte{toc(1, 2), 5}, 40 * time.Millisecond},
+		{"code 3 frame count", []byte{toc(31, 3), 3}, 60 * time.Millisecond},
+		{"code 3 ignores vbr and padding bits", []byte{toc(16, 3), 0xc0 | 48}, 120 * time.Millisecond},
+		{"code 3 missing count", []byte{toc(31, 3)}, 0},
+		{"code 3 zero frames", []byte{toc(31, 3), 0}, 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := OpusPacketDuration(tc.pkt); got != tc.expected {
+				t.Fatalf("expected %v but got %v", tc.expected, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,165 @@
+// Package dvr keeps the last stretch of a live stream so listeners can start
+// some way back ("?offset=") and play on from there at their own pace.
+package dvr
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Chunk is one piece of the stream as it went out.
+type Chunk struct {
+	Seq    uint64
+	At     time.Time
+	Data   []byte
+	Header []byte // what a player needs before Data, nil if nothing
+}
+
+// Buffer holds every chunk of the last window.
+type Buffer struct {
+	window time.Duration
+
+	mu       sync.Mutex
+	chunks   []Chunk
+	nextSeq  uint64
+	appended chan struct{} // closed and replaced on every append
+	closed   bool
+}
+
+var ErrClosed = errors.New("dvr buffer closed")
+
+// New returns a buffer for window, or nil when window is 0 (DVR off). A nil
+// Buffer is safe to Append to.
+func New(window time.Duration) *Buffer {
+	if window <= 0 {
+		return nil
+	}
+	return &Buffer{window: window, appended: make(chan struct{})}
+}
+
+// Window is how far back the buffer goes.
+func (b *Buffer) Window() time.Duration {
+	if b == nil {
+		return 0
+	}
+	return b.window
+}
+
+// Append adds data (kept as is, so don't reuse it) and drops whatever fell
+// out of the window.
+func (b *Buffer) Append(data, header []byte) {
+	if b == nil || len(data) == 0 {
+		return
+	}
+	now := time.Now()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return
+	}
+
+	b.chunks = append(b.chunks, Chunk{Seq: b.nextSeq, At: now, Data: data, Header: header})
+	b.nextSeq++
+
+	drop := 0
+	for drop < len(b.chunks)-1 && now.Sub(b.chunks[drop].At) > b.window {
+		drop++
+	}
+	if drop > 0 {
+		// copy now and then so the dropped chunks can be collected.
+		if drop > len(b.chunks)/2 {
+			b.chunks = append([]Chunk(nil), b.chunks[drop:]...)
+		} else {
+			b.chunks = b.chunks[drop:]
+		}
+	}
+
+	close(b.appended)
+	b.appended = make(chan struct{})
+}
+
+// Seek returns the sequence number of the first chunk sent no more than ago
+// back, or the oldest one kept. ok is false while the buffer is empty.
+func (b *Buffer) Seek(ago time.Duration) (seq uint64, ok bool) {
+	if b == nil {
+		return 0, false
+	}
+	since := time.Now().Add(-ago)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.chunks) == 0 {
+		return 0, false
+	}
+	for _, c := range b.chunks {
+		if !c.At.Before(since) {
+			return c.Seq, true
+		}
+	}
+	return b.chunks[len(b.chunks)-1].Seq, true
+}
+
+// Next returns chunk seq, waiting for it if it hasn't gone out yet. A reader
+// that fell out of the window carries on from the oldest chunk.
+func (b *Buffer) Next(ctx context.Context, seq uint64) (Chunk, error) {
+	for {
+		b.mu.Lock()
+		if b.closed {
+			b.mu.Unlock()
+			return Chunk{}, ErrClosed
+		}
+		if len(b.chunks) > 0 && seq < b.nextSeq {
+			first := b.chunks[0].Seq
+			c := b.chunks[0]
+			if seq > first {
+				c = b.chunks[seq-first]
+			}
+			b.mu.Unlock()
+			return c, nil
+		}
+		appended := b.appended
+		b.mu.Unlock()
+
+		select {
+		case <-appended:
+		case <-ctx.Done():
+			return Chunk{}, ctx.Err()
+		}
+	}
+}
+
+// Close wakes up and ends every reader.
+func (b *Buffer) Close() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.closed {
+		b.closed = true
+		b.chunks = nil
+		close(b.appended)
+	}
+}
+
+// Offset reads ?offset= off a listener's request: seconds ("90") or a Go
+// duration ("5m"). It's 0 when missing or unusable.
+func Offset(r *http.Request) time.Duration {
+	raw := strings.TrimSpace(r.URL.Query().Get("offset"))
+	if raw == "" {
+		return 0
+	}
+	if secs, err := strconv.ParseFloat(raw, 64); err == nil {
+		return max(0, time.Duration(secs*float64(time.Second)))
+	}
+	if d, err := time.ParseDuration(raw); err == nil {
+		return max(0, d)
+	}
+	return 0
+}
@@ -0,0 +1,84 @@
+package dvr
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestBuffer(t *testing.T) {
+	b := New(time.Hour)
+	b.Append([]byte("a"), nil)
+	time.Sleep(30 * time.Millisecond)
+	b.Append([]byte("b"), []byte("head"))
+
+	if seq, _ := b.Seek(10 * time.Millisecond); seq != 1 {
+		t.Fatalf("expected seq 1 but got %d", seq)
+	}
+	if seq, _ := b.Seek(time.Minute); seq != 0 {
+		t.Fatalf("expected seq 0 but got %d", seq)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	c, err := b.Next(ctx, 1)
+	if err != nil || string(c.Data) != "b" || string(c.Header) != "head" {
+		t.Fatalf("expected chunk b but got %+v (%v)", c, err)
+	}
+
+	// a reader at the head waits for the next chunk.
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		b.Append([]byte("c"), nil)
+	}()
+	if c, err := b.Next(ctx, 2); err != nil || string(c.Data) != "c" {
+		t.Fatalf("expected chunk c but got %+v (%v)", c, err)
+	}
+
+	b.Close()
+	if _, err := b.Next(ctx, 3); err != ErrClosed {
+		t.Fatalf("expected %v but got %v", ErrClosed, err)
+	}
+}
+
+func TestBufferWindow(t *testing.T) {
+	b := New(20 * time.Millisecond)
+	b.Append([]byte("a"), nil)
+	time.Sleep(30 * time.Millisecond)
+	b.Append([]byte("b"), nil)
+
+	// a fell out, readers that wanted it carry on from b.
+	c, err := b.Next(context.Background(), 0)
+	if err != nil || string(c.Data) != "b" || c.Seq != 1 {
+		t.Fatalf("expected chunk b but got %+v (%v)", c, err)
+	}
+
+	if New(0) != nil {
+		t.Fatalf("expected no buffer without a window")
+	}
+}
+
+func TestOffset(t *testing.T) {
+	tests := []struct {
+		query    string
+		expected time.Duration
+	}{
+		{"", 0},
+		{"?offset=90", 90 * time.Second},
+		{"?offset=1.5", 1500 * time.Millisecond},
+		{"?offset=5m", 5 * time.Minute},
+		{"?offset=-10", 0},
+		{"?offset=soon", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/api/stream.opus"+tt.query, nil)
+			if got := Offset(r); got != tt.expected {
+				t.Fatalf("expected %s but got %s", tt.expected, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,252 @@
+package hls
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// live.mpd is an MPEG-DASH manifest over the same fMP4 segments as the HLS
+// playlists (seg_<name>_<msn>.m4s), for clients that only do DASH. Each
+// segment is listed with its tfdt so players line the media up exactly; the
+// period starts where the renditions' media time 0 falls on the wall clock,
+// counted from the cursor's start.
+
+const (
+	dashFilename = "live.mpd"
+	// dates in the playlists are rounded to the millisecond, an anchor only
+	// moves (and the period with it) when the media timeline really jumped.
+	dashAnchorSlack = 250 * time.Millisecond
+)
+
+type dashSegment struct {
+	number   int
+	pdt      time.Time
+	start    uint64 // media time, in the track's timescale
+	duration uint64
+}
+
+type dashRepresentation struct {
+	rendition Rendition
+	initURI   string
+	timescale uint32
+	anchor    time.Time
+	segments  []dashSegment
+}
+
+// partData is a part's bytes, wherever they are kept.
+func (l *partList) partData(p llPart) ([]byte, bool) {
+	if p.data != nil {
+		return p.data, true
+	}
+	if l.store == nil {
+		return nil, false
+	}
+	f, ok := l.store.get(p.uri)
+	return f.data, ok
+}
+
+// dashTimeline lists the complete segments on the media timeline.
+func (l *partList) dashTimeline() (initURI string, timescale uint32, segs []dashSegment) {
+	l.mu.Lock()
+	initURI, initData := l.initURI, l.initData
+	per := l.partsPerSegment
+	skip := (per - l.firstSeq%per) % per
+	// each ffmpeg run starts its media time over, only the current one fits
+	// on the timeline.
+	for i := skip; i < len(l.parts); i += per {
+		if l.parts[i].run == l.runs {
+			break
+		}
+		skip += per
+	}
+	var parts []llPart
+	if skip < len(l.parts) {
+		parts = append(parts, l.parts[skip:]...)
+	}
+	firstMSN := (l.firstSeq + skip) / per
+	l.mu.Unlock()
+
+	if initData == nil && l.store != nil {
+		f, _ := l.store.get(initURI)
+		initData = f.data
+	}
+	timescale, ok := mediaTimescale(initData)
+	if !ok || timescale == 0 {
+		return "", 0, nil
+	}
+
+	for i := 0; i+per <= len(parts); i += per {
+		data, ok := l.partData(parts[i])
+		var start uint64
+		if ok {
+			start, ok = baseMediaDecodeTime(data)
+		}
+		if !ok {
+			// $Number$ can't skip one, so a gap ends the timeline. one the
+			// store already let go of at the front just moves its start.
+			if len(segs) == 0 {
+				continue
+			}
+			break
+		}
+		segs = append(segs, dashSegment{
+			number:   firstMSN + i/per,
+			pdt:      parts[i].pdt,
+			start:    start,
+			duration: uint64(math.Round(sumDurations(parts[i:i+per]) * float64(timescale))),
+		})
+	}
+
+	// the playlist durations are rounded, the next segment's start isn't.
+	for i := 0; i+1 < len(segs); i++ {
+		next := segs[i+1]
+		if next.number == segs[i].number+1 && next.start > segs[i].start {
+			segs[i].duration = next.start - segs[i].start
+		}
+	}
+	return initURI, timescale, segs
+}
+
+// anchor is the wall clock at media time 0, going by seg.
+func (l *partList) anchor(seg dashSegment, timescale uint32) time.Time {
+	at := seg.pdt.Add(-time.Duration(float64(seg.start) / float64(timescale) * float64(time.Second)))
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.dashAnchor.IsZero() || at.Sub(l.dashAnchor).Abs() > dashAnchorSlack {
+		l.dashAnchor = at
+	}
+	return l.dashAnchor
+}
+
+// dashManifest renders live.mpd, nil until there's a segment to list.
+func (s *Streamer) dashManifest(now time.Time) []byte {
+	var reps []dashRepresentation
+	for _, r := range s.ladder {
+		l := s.findPartList(r.Name)
+		if l == nil {
+			continue
+		}
+		initURI, timescale, segs := l.dashTimeline()
+		if drop := len(segs) - playlistSegments; s.dvrWindow <= 0 && drop > 0 {
+			segs = segs[drop:]
+		}
+		if len(segs) == 0 || segs[0].pdt.IsZero() {
+			continue
+		}
+		reps = append(reps, dashRepresentation{
+			rendition: r,
+			initURI:   initURI,
+			timescale: timescale,
+			anchor:    l.anchor(segs[0], timescale),
+			segments:  segs,
+		})
+	}
+	if len(reps) == 0 {
+		return nil
+	}
+
+	availabilityStart := s.cursor.StartedAt().UTC().Truncate(time.Millisecond)
+	latest := reps[0].anchor
+	for _, rep := range reps {
+		if rep.anchor.After(latest) {
+			latest = rep.anchor
+		}
+	}
+	// every rendition has media for the whole period.
+	periodStart := max(0, latest.Sub(availabilityStart).Truncate(time.Millisecond))
+
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+	fmt.Fprintf(&b, `<MPD xmlns="urn:mpeg:dash:schema:mpd:2011" profiles="urn:mpeg:dash:profile:isoff-live:2011" type="dynamic"`+
+		` availabilityStartTime="%s" publishTime="%s" minimumUpdatePeriod="%s" minBufferTime="%s"`+
+		` timeShiftBufferDepth="%s" suggestedPresentationDelay="%s">`+"\n",
+		dashDate(availabilityStart),
+		dashDate(now),
+		dashDuration(segmentDuration),
+		dashDuration(segmentDuration),
+		dashDuration(max(playlistSegments*segmentDuration, s.dvrWindow)),
+		dashDuration(3*segmentDuration),
+	)
+	fmt.Fprintf(&b, `  <Period id="p%d" start="%s">`+"\n", periodStart.Milliseconds(), dashDuration(periodStart))
+
+	// players only switch between renditions of the same codec.
+	for set, opus := range []bool{true, false} {
+		open := false
+		for _, rep := range reps {
+			if (rep.rendition.Profile == profileOpus) != opus {
+				continue
+			}
+			if !open {
+				fmt.Fprintf(&b, `    <AdaptationSet id="%d" contentType="audio" mimeType="audio/mp4" lang="und" segmentAlignment="true" startWithSAP="1">`+"\n", set)
+				open = true
+			}
+			writeDashRepresentation(&b, rep, availabilityStart.Add(periodStart))
+		}
+		if open {
+			b.WriteString("    </AdaptationSet>\n")
+		}
+	}
+
+	b.WriteString("  </Period>\n")
+	fmt.Fprintf(&b, `  <UTCTiming schemeIdUri="urn:mpeg:dash:utc:direct:2014" value="%s"/>`+"\n", dashDate(now))
+	b.WriteString("</MPD>\n")
+	return []byte(b.String())
+}
+
+func writeDashRepresentation(b *strings.Builder, rep dashRepresentation, periodStart time.Time) {
+	r := rep.rendition
+	// media time at the start of the period.
+	offset := max(0, math.Round(periodStart.Sub(rep.anchor).Seconds()*float64(rep.timescale)))
+
+	fmt.Fprintf(b, `      <Representation id="%s" codecs="%s" bandwidth="%d" audioSamplingRate="48000">`+"\n",
+		r.Name, r.Codecs(), int(float64(r.Bitrate)*containerOverhead))
+	b.WriteString(`        <AudioChannelConfiguration schemeIdUri="urn:mpeg:dash:23003:3:audio_channel_configuration:2011" value="2"/>` + "\n")
+	fmt.Fprintf(b, `        <SegmentTemplate timescale="%d" presentationTimeOffset="%d" initialization="%s" media="seg_%s_$Number$.m4s" startNumber="%d">`+"\n",
+		rep.timescale, uint64(offset), rep.initURI, r.Name, rep.segments[0].number)
+	b.WriteString("          <SegmentTimeline>\n")
+
+	for i := 0; i < len(rep.segments); {
+		seg := rep.segments[i]
+		repeat := 0
+		for j := i + 1; j < len(rep.segments); j++ {
+			prev, next := rep.segments[j-1], rep.segments[j]
+			if next.duration != seg.duration || next.start != prev.start+prev.duration || next.number != prev.number+1 {
+				break
+			}
+			repeat++
+		}
+		if repeat > 0 {
+			fmt.Fprintf(b, `            <S t="%d" d="%d" r="%d"/>`+"\n", seg.start, seg.duration, repeat)
+		} else {
+			fmt.Fprintf(b, `            <S t="%d" d="%d"/>`+"\n", seg.start, seg.duration)
+		}
+		i += repeat + 1
+	}
+
+	b.WriteString("          </SegmentTimeline>\n")
+	b.WriteString("        </SegmentTemplate>\n")
+	b.WriteString("      </Representation>\n")
+}
+
+func (s *Streamer) serveDash(w http.ResponseWriter, r *http.Request) {
+	body := s.dashManifest(time.Now())
+	if body == nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/dash+xml")
+	w.Header().Set("Cache-Control", playlistCacheControl)
+	serveBytes(w, r, dashFilename, time.Time{}, body)
+}
+
+func dashDate(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05.000Z")
+}
+
+func dashDuration(d time.Duration) string {
+	return fmt.Sprintf("PT%.3fS", d.Seconds())
+}
@@ -0,0 +1,77 @@
+package hls
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/philipch07/EggsFM/internal/audio"
+)
+
+func TestDashManifest(t *testing.T) {
+	r := Rendition{Name: "opus", Bitrate: 128000, Profile: profileOpus}
+	l := newMemoryPartList(r, 100*time.Millisecond, false)
+	p := newOpusPackager(l, 100*time.Millisecond)
+
+	packet := append([]byte{0xfc}, make([]byte, 99)...)
+	for i := 0; i < 4; i++ {
+		_, _ = p.Write(oggPage(packet, packet, packet, packet, packet))
+	}
+
+	cursor := audio.NewCursor()
+	s := &Streamer{cursor: cursor, ladder: []Rendition{r}, lists: []*partList{l}}
+
+	mpd := string(s.dashManifest(time.Now()))
+	for _, want := range []string{
+		`type="dynamic" availabilityStartTime="` + dashDate(cursor.StartedAt()) + `"`,
+		`<Representation id="opus" codecs="opus" bandwidth="140800"`,
+		`initialization="opus/init.mp4" media="seg_opus_$Number$.m4s" startNumber="0"`,
+		// four 100ms segments back to back at 48kHz.
+		`<S t="0" d="4800" r="3"/>`,
+	} {
+		if !strings.Contains(mpd, want) {
+			t.Fatalf("expected %q in\n%s", want, mpd)
+		}
+	}
+}
+
+func TestDashTimelineGaps(t *testing.T) {
+	tests := []struct {
+		name    string
+		missing int
+		number  int
+		want    string
+	}{
+		{name: "Oldest Gone", missing: 0, number: 1, want: `<S t="4800" d="4800" r="2"/>`},
+		{name: "Gap In The Middle", missing: 2, number: 0, want: `<S t="0" d="4800" r="1"/>` + "\n          </SegmentTimeline>"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := Rendition{Name: "opus", Bitrate: 128000, Profile: profileOpus}
+			l := newMemoryPartList(r, 100*time.Millisecond, false)
+			p := newOpusPackager(l, 100*time.Millisecond)
+
+			packet := append([]byte{0xfc}, make([]byte, 99)...)
+			for i := 0; i < 4; i++ {
+				_, _ = p.Write(oggPage(packet, packet, packet, packet, packet))
+			}
+			l.parts[tc.missing].data = nil
+
+			_, _, segs := l.dashTimeline()
+			if len(segs) == 0 || segs[0].number != tc.number {
+				t.Fatalf("expected the timeline to start at %d but got %v", tc.number, segs)
+			}
+			for i, seg := range segs {
+				if seg.number != tc.number+i {
+					t.Fatalf("expected segment %d to be number %d but got %d", i, tc.number+i, seg.number)
+				}
+			}
+
+			s := &Streamer{cursor: audio.NewCursor(), ladder: []Rendition{r}, lists: []*partList{l}}
+			if mpd := string(s.dashManifest(time.Now())); !strings.Contains(mpd, tc.want) {
+				t.Fatalf("expected %q in\n%s", tc.want, mpd)
+			}
+		})
+	}
+}
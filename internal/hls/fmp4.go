@@ -0,0 +1,230 @@
+package hls
+
+import (
+	"encoding/binary"
+)
+
+// just enough ISO BMFF to carry Opus in fragmented MP4 (ISO/IEC 14496-12 +
+// the "Encapsulation of Opus in ISO Base Media File Format" mapping). One
+// audio track, 48kHz timescale, every sample a sync sample.
+
+const opusTimescale = 48000
+
+// opusHead is what the init segment needs from a track's OpusHead (RFC 7845
+// 5.1). The input sample rate is left out, it's informational only.
+type opusHead struct {
+	channels byte
+	preSkip  uint16
+	gain     int16
+	family   byte
+	mapping  string // stream count, coupled count + channel mapping, family > 0
+}
+
+// defaultOpusHead is libopus' stereo default, used until the first track's
+// OpusHead comes through.
+var defaultOpusHead = opusHead{channels: 2, preSkip: 312}
+
+// parseOpusHead reads an OpusHead packet.
+func parseOpusHead(pkt []byte) (opusHead, bool) {
+	if len(pkt) < 19 || string(pkt[:8]) != "OpusHead" || pkt[9] == 0 {
+		return opusHead{}, false
+	}
+	h := opusHead{
+		channels: pkt[9],
+		preSkip:  binary.LittleEndian.Uint16(pkt[10:12]),
+		gain:     int16(binary.LittleEndian.Uint16(pkt[16:18])),
+		family:   pkt[18],
+	}
+	if h.family != 0 {
+		n := 2 + int(h.channels)
+		if len(pkt) < 19+n {
+			return opusHead{}, false
+		}
+		h.mapping = string(pkt[19 : 19+n])
+	}
+	return h, true
+}
+
+type fmp4Sample struct {
+	data     []byte
+	duration uint32 // in opusTimescale units
+}
+
+func mp4Box(typ string, payload ...[]byte) []byte {
+	size := 8
+	for _, p := range payload {
+		size += len(p)
+	}
+	b := make([]byte, 0, size)
+	b = binary.BigEndian.AppendUint32(b, uint32(size))
+	b = append(b, typ...)
+	for _, p := range payload {
+		b = append(b, p...)
+	}
+	return b
+}
+
+func mp4FullBox(typ string, version byte, flags uint32, payload ...[]byte) []byte {
+	head := []byte{version, byte(flags >> 16), byte(flags >> 8), byte(flags)}
+	return mp4Box(typ, append([][]byte{head}, payload...)...)
+}
+
+func u16(v uint16) []byte { return binary.BigEndian.AppendUint16(nil, v) }
+func u32(v uint32) []byte { return binary.BigEndian.AppendUint32(nil, v) }
+func u64(v uint64) []byte { return binary.BigEndian.AppendUint64(nil, v) }
+
+func zeros(n int) []byte { return make([]byte, n) }
+
+var unityMatrix = func() []byte {
+	var b []byte
+	for _, v := range []uint32{0x00010000, 0, 0, 0, 0x00010000, 0, 0, 0, 0x40000000} {
+		b = binary.BigEndian.AppendUint32(b, v)
+	}
+	return b
+}()
+
+// opusInitSegment is the ftyp+moov the segments of a track with head refer to.
+func opusInitSegment(head opusHead) []byte {
+	ftyp := mp4Box("ftyp", []byte("iso6"), u32(0), []byte("iso6"), []byte("mp41"), []byte("isom"))
+
+	mvhd := mp4FullBox("mvhd", 0, 0,
+		u32(0), u32(0), // creation, modification
+		u32(1000), u32(0), // timescale, duration
+		u32(0x00010000), u16(0x0100), zeros(10), // rate, volume
+		unityMatrix,
+		zeros(24),
+		u32(2), // next track id
+	)
+
+	tkhd := mp4FullBox("tkhd", 0, 0x3, // enabled, in movie
+		u32(0), u32(0),
+		u32(1), u32(0), // track id
+		u32(0), zeros(8), // duration
+		u16(0), u16(0), u16(0x0100), u16(0), // layer, group, volume
+		unityMatrix,
+		u32(0), u32(0), // width, height
+	)
+
+	mdhd := mp4FullBox("mdhd", 0, 0,
+		u32(0), u32(0),
+		u32(opusTimescale), u32(0),
+		u16(0x55c4), u16(0), // "und"
+	)
+	hdlr := mp4FullBox("hdlr", 0, 0, u32(0), []byte("soun"), zeros(12), []byte("SoundHandler\x00"))
+
+	dOps := mp4Box("dOps",
+		[]byte{0, head.channels},
+		u16(head.preSkip),
+		u32(opusTimescale),
+		u16(uint16(head.gain)),
+		[]byte{head.family},
+		[]byte(head.mapping),
+	)
+	opus := mp4Box("Opus",
+		zeros(6), u16(1), // data reference index
+		zeros(8),
+		u16(uint16(head.channels)), u16(16), u16(0), u16(0),
+		u32(opusTimescale<<16),
+		dOps,
+	)
+
+	stbl := mp4Box("stbl",
+		mp4FullBox("stsd", 0, 0, u32(1), opus),
+		mp4FullBox("stts", 0, 0, u32(0)),
+		mp4FullBox("stsc", 0, 0, u32(0)),
+		mp4FullBox("stsz", 0, 0, u32(0), u32(0)),
+		mp4FullBox("stco", 0, 0, u32(0)),
+	)
+	dinf := mp4Box("dinf", mp4FullBox("dref", 0, 0, u32(1), mp4FullBox("url ", 0, 1)))
+	minf := mp4Box("minf", mp4FullBox("smhd", 0, 0, u16(0), u16(0)), dinf, stbl)
+	trak := mp4Box("trak", tkhd, mp4Box("mdia", mdhd, hdlr, minf))
+
+	trex := mp4FullBox("trex", 0, 0, u32(1), u32(1), u32(0), u32(0), u32(0))
+	moov := mp4Box("moov", mvhd, trak, mp4Box("mvex", trex))
+
+	return append(ftyp, moov...)
+}
+
+// opusFragment is one moof+mdat holding samples, starting at baseTime.
+func opusFragment(seq uint32, baseTime uint64, samples []fmp4Sample) []byte {
+	entries := make([]byte, 0, 8*len(samples))
+	mdatSize := 8
+	for _, s := range samples {
+		entries = binary.BigEndian.AppendUint32(entries, s.duration)
+		entries = binary.BigEndian.AppendUint32(entries, uint32(len(s.data)))
+		mdatSize += len(s.data)
+	}
+
+	// sizes are fixed, so the data offset can be worked out up front.
+	trunSize := 12 + 8 + len(entries)
+	moofSize := 8 + 16 + 8 + 16 + 20 + trunSize
+
+	trun := mp4FullBox("trun", 0, 0x000301, // data offset, sample duration, sample size
+		u32(uint32(len(samples))),
+		u32(uint32(moofSize+8)),
+		entries,
+	)
+	traf := mp4Box("traf",
+		mp4FullBox("tfhd", 0, 0x020000, u32(1)), // default base is moof
+		mp4FullBox("tfdt", 1, 0, u64(baseTime)),
+		trun,
+	)
+	moof := mp4Box("moof", mp4FullBox("mfhd", 0, 0, u32(seq)), traf)
+
+	out := make([]byte, 0, len(moof)+mdatSize)
+	out = append(out, moof...)
+	out = binary.BigEndian.AppendUint32(out, uint32(mdatSize))
+	out = append(out, "mdat"...)
+	for _, s := range samples {
+		out = append(out, s.data...)
+	}
+	return out
+}
+
+// findBox digs through nested boxes, e.g. findBox(b, "moof", "traf", "tfdt"),
+// and returns the payload of the last one.
+func findBox(b []byte, path ...string) []byte {
+	for _, typ := range path {
+		var found []byte
+		for len(b) >= 8 {
+			size := int(binary.BigEndian.Uint32(b))
+			if size < 8 || size > len(b) {
+				return nil
+			}
+			if string(b[4:8]) == typ {
+				found = b[8:size]
+				break
+			}
+			b = b[size:]
+		}
+		if found == nil {
+			return nil
+		}
+		b = found
+	}
+	return b
+}
+
+// mediaTimescale reads the track timescale out of an init segment.
+func mediaTimescale(init []byte) (uint32, bool) {
+	mdhd := findBox(init, "moov", "trak", "mdia", "mdhd")
+	switch {
+	case len(mdhd) >= 16 && mdhd[0] == 0:
+		return binary.BigEndian.Uint32(mdhd[12:]), true
+	case len(mdhd) >= 24 && mdhd[0] == 1:
+		return binary.BigEndian.Uint32(mdhd[20:]), true
+	}
+	return 0, false
+}
+
+// baseMediaDecodeTime reads the start time of a fragment.
+func baseMediaDecodeTime(fragment []byte) (uint64, bool) {
+	tfdt := findBox(fragment, "moof", "traf", "tfdt")
+	switch {
+	case len(tfdt) >= 8 && tfdt[0] == 0:
+		return uint64(binary.BigEndian.Uint32(tfdt[4:])), true
+	case len(tfdt) >= 12 && tfdt[0] == 1:
+		return binary.BigEndian.Uint64(tfdt[4:]), true
+	}
+	return 0, false
+}
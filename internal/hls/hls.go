@@ -6,10 +6,11 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"net/http"
 	"os"
 	"os/exec"
-	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -21,32 +22,55 @@ import (
 )
 
 type Config struct {
-	OutputDir           string
 	FfmpegPath          string
 	SegmentCacheControl string
 	Cursor              *audio.Cursor
+	Ladder              []Rendition // DefaultLadder when empty
+	LowLatency          bool
+	PartDuration        time.Duration // LL-HLS part length, defaultPartDuration when 0
+	DVRWindow           time.Duration // how much the DVR playlists go back, 0 for off
 }
 
 type Streamer struct {
-	dir       string
 	ffmpegBin string
+	store     *segmentStore // ffmpeg's output
 	cmd       *exec.Cmd
 	stdin     *io.PipeWriter
 	sink      *pipeSink
 	cursor    *audio.Cursor
+	ladder    []Rendition
+	aac       []Rendition // the renditions ffmpeg encodes
 
-	handler http.Handler
+	handler             http.Handler
+	segmentCacheControl string
+
+	lowLatency   bool
+	partDuration time.Duration // of ffmpeg's LL-HLS parts, 0 when off
+	dvrWindow    time.Duration
+
+	// every rendition's segments, see parts.go.
+	lists     []*partList
+	packagers []*opusPackager
+
+	metaMu sync.Mutex
+	marks  []nowPlayingMark
 
 	mu        sync.RWMutex
 	startedAt time.Time
+	startPos  time.Duration // cursor position at startedAt
 	closed    chan struct{}
 	closeOnce sync.Once
 	restarts  uint64
 }
 
 const (
-	playlistCacheControl   = "no-store, max-age=0"
-	playlistFilename       = "live.m3u8"
+	playlistCacheControl = "no-store, max-age=0"
+	playlistFilename     = "live.m3u8"
+	dvrFilename          = "dvr.m3u8"
+	playlistSegments     = 32
+	// segments kept around after they leave the playlist, for players that
+	// are a little behind.
+	segmentSlack           = 8
 	hlsPipeBufferSlots     = 256
 	ffmpegRestartDelay     = 2 * time.Second
 	ffmpegRestartMaxDelay  = 30 * time.Second
@@ -56,62 +80,98 @@ const (
 	ffmpegMaxUptime = 8 * time.Hour
 )
 
-// Start spawns an ffmpeg process that consumes a live Ogg Opus stream from stdin
-// and emits HLS (fMP4) fragments + manifests in OutputDir.
+// Start packages the native Opus rendition in-process and, for the AAC
+// renditions, spawns an ffmpeg process that consumes the live Ogg Opus stream
+// from stdin and uploads HLS (fMP4) fragments + manifests to an in-memory
+// store.
 func Start(cfg Config) (*Streamer, error) {
 	if cfg.Cursor == nil {
 		return nil, errors.New("cursor is required to start HLS")
 	}
 
-	dir := cfg.OutputDir
-	if strings.TrimSpace(dir) == "" {
-		dir = "hls"
+	ladder := cfg.Ladder
+	if len(ladder) == 0 {
+		ladder = DefaultLadder
 	}
 
-	ffmpegPath := cfg.FfmpegPath
-	if strings.TrimSpace(ffmpegPath) == "" {
-		ffmpegPath = "ffmpeg"
+	segmentCacheControl := strings.TrimSpace(cfg.SegmentCacheControl)
+	if segmentCacheControl == "" {
+		segmentCacheControl = playlistCacheControl
 	}
 
-	ffmpegBin, err := exec.LookPath(ffmpegPath)
-	if err != nil {
-		return nil, fmt.Errorf("ffmpeg not found (required for HLS/AAC): %w", err)
+	streamer := &Streamer{
+		cursor:              cfg.Cursor,
+		ladder:              ladder,
+		lowLatency:          cfg.LowLatency,
+		dvrWindow:           max(0, cfg.DVRWindow),
+		segmentCacheControl: segmentCacheControl,
+		closed:              make(chan struct{}),
 	}
 
-	if err := os.MkdirAll(dir, 0o755); err != nil {
-		return nil, fmt.Errorf("create hls output dir: %w", err)
+	partDuration := cfg.PartDuration
+	if partDuration <= 0 {
+		partDuration = defaultPartDuration
 	}
-	if err := wipeDir(dir); err != nil {
-		return nil, err
+	if cfg.LowLatency {
+		streamer.partDuration = partDuration
 	}
 
-	segmentCacheControl := strings.TrimSpace(cfg.SegmentCacheControl)
-	if segmentCacheControl == "" {
-		segmentCacheControl = playlistCacheControl
+	// segments each rendition holds on to.
+	keep := playlistSegments
+	if streamer.dvrWindow > 0 {
+		keep = max(keep, int(math.Ceil(float64(streamer.dvrWindow)/float64(segmentDuration))))
 	}
 
-	streamer := &Streamer{
-		dir:       dir,
-		ffmpegBin: ffmpegBin,
-		cursor:    cfg.Cursor,
-		closed:    make(chan struct{}),
-		handler:   newFileHandler(dir, playlistCacheControl, segmentCacheControl),
+	aacCount := 0
+	for _, r := range ladder {
+		if r.Profile != profileOpus {
+			aacCount++
+		}
+	}
+	if aacCount > 0 {
+		perSegment := 1
+		if cfg.LowLatency {
+			perSegment = partsPerSegment(partDuration)
+		}
+		streamer.store = newSegmentStore(aacCount * (keep + segmentSlack) * perSegment)
 	}
-	streamer.sink = newPipeSink(streamer)
 
-	cmd, pw, err := streamer.startTranscoder()
-	if err != nil {
-		return nil, err
+	for _, r := range ladder {
+		switch {
+		case r.Profile == profileOpus:
+			part := segmentDuration
+			if cfg.LowLatency {
+				part = partDuration
+			}
+			list := newMemoryPartList(r, part, cfg.LowLatency)
+			list.keepSegments(keep)
+			streamer.lists = append(streamer.lists, list)
+			streamer.packagers = append(streamer.packagers, newOpusPackager(list, part))
+		default:
+			list := newPartList(streamer.store, r, partDuration, cfg.LowLatency)
+			list.keepSegments(keep)
+			streamer.aac = append(streamer.aac, r)
+			streamer.lists = append(streamer.lists, list)
+		}
 	}
-	streamer.setTranscoder(cmd, pw, false)
+	streamer.handler = newFileHandler(streamer, playlistCacheControl)
 
-	go streamer.supervise(cmd, pw)
-	go streamer.monitorPlaylist()
+	// ffmpeg is only needed for AAC.
+	if len(streamer.aac) > 0 {
+		if err := streamer.startFFmpeg(cfg.FfmpegPath); err != nil {
+			return nil, err
+		}
+	} else {
+		streamer.startedAt = time.Now()
+		streamer.startPos = cfg.Cursor.Position()
+	}
 
 	snap := cfg.Cursor.Snapshot()
 	log.Printf(
-		"HLS ready at /api/hls/ (output: %s, cursor start=%s, offset=%s)",
-		dir,
+		"HLS ready at /api/hls/ (renditions: %s, low latency: %t, dvr: %s, cursor start=%s, offset=%s)",
+		ladderNames(ladder),
+		cfg.LowLatency,
+		streamer.dvrWindow,
 		snap.StartedAt.Format(time.RFC3339),
 		snap.Position,
 	)
@@ -119,9 +179,51 @@ func Start(cfg Config) (*Streamer, error) {
 	return streamer, nil
 }
 
+func (s *Streamer) startFFmpeg(ffmpegPath string) error {
+	if strings.TrimSpace(ffmpegPath) == "" {
+		ffmpegPath = "ffmpeg"
+	}
+
+	ffmpegBin, err := exec.LookPath(ffmpegPath)
+	if err != nil {
+		return fmt.Errorf("ffmpeg not found (required for HLS/AAC): %w", err)
+	}
+	s.ffmpegBin = ffmpegBin
+
+	if err := s.store.listen(); err != nil {
+		return err
+	}
+
+	s.sink = newPipeSink(s)
+
+	cmd, pw, err := s.startTranscoder()
+	if err != nil {
+		return err
+	}
+	s.setTranscoder(cmd, pw, false)
+
+	go s.supervise(cmd, pw)
+	go s.monitorPlaylist()
+	go s.watchParts()
+
+	log.Printf("HLS ffmpeg output: %s", s.store.baseURL())
+	return nil
+}
+
 // AudioWriter returns a best-effort writer for the live Opus/Ogg stream.
 func (s *Streamer) AudioWriter() io.Writer {
-	return s.sink
+	writers := make([]io.Writer, 0, len(s.packagers)+1)
+	if s.sink != nil {
+		writers = append(writers, s.sink)
+	}
+	for _, p := range s.packagers {
+		writers = append(writers, p)
+	}
+	if len(writers) == 1 {
+		return writers[0]
+	}
+	// none of them ever fail.
+	return io.MultiWriter(writers...)
 }
 
 // DropCount returns the total number of dropped HLS audio writes.
@@ -178,6 +280,9 @@ func (s *Streamer) Close() {
 		if s.sink != nil {
 			s.sink.close()
 		}
+		if s.store != nil {
+			s.store.close()
+		}
 	})
 }
 
@@ -371,112 +476,197 @@ func (l *lineLogger) Write(p []byte) (int, error) {
 	return len(p), nil
 }
 
-func newFileHandler(dir, playlistCacheControl, segmentCacheControl string) http.Handler {
-	fileServer := http.FileServer(http.Dir(dir))
+func newFileHandler(s *Streamer, playlistCacheControl string) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		viewers.TrackRequest(viewers.ProtocolHLS, r)
 
+		name := strings.TrimPrefix(r.URL.Path, "/")
+		// live.m3u8 from before the ladder keeps working as the first AAC rendition.
+		if name == playlistFilename {
+			name = s.defaultPlaylist()
+			r.URL.Path = "/" + name
+		}
+
+		if name == masterFilename || (name == dvrFilename && s.dvrWindow > 0) {
+			w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+			w.Header().Set("Cache-Control", playlistCacheControl)
+			serveBytes(w, r, name, time.Time{}, []byte(masterPlaylist(s.ladder, name == dvrFilename)))
+			return
+		}
+
+		if name == dashFilename {
+			s.serveDash(w, r)
+			return
+		}
+
+		if s.serveParts(w, r, name) {
+			return
+		}
+
 		cacheControl := playlistCacheControl
 		switch {
 		case strings.HasSuffix(r.URL.Path, ".m3u8"):
 			w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+			if strings.HasPrefix(name, "live_") {
+				if cacheControl != "" {
+					w.Header().Set("Cache-Control", cacheControl)
+				}
+				s.serveMediaPlaylist(w, r, name)
+				return
+			}
 
 		case strings.HasSuffix(r.URL.Path, ".m4s"):
 			w.Header().Set("Content-Type", "video/iso.segment")
-			cacheControl = segmentCacheControl
+			cacheControl = s.segmentCacheControl
 
 		case strings.HasSuffix(r.URL.Path, ".mp4"):
 			w.Header().Set("Content-Type", "video/mp4")
-			cacheControl = segmentCacheControl
+			cacheControl = s.segmentCacheControl
 		}
 
 		if cacheControl != "" {
 			w.Header().Set("Cache-Control", cacheControl)
 		}
 
-		fileServer.ServeHTTP(w, r)
+		if s.store == nil || !s.store.serve(w, r, name) {
+			http.NotFound(w, r)
+		}
 	})
 }
 
-func wipeDir(dir string) error {
-	entries, err := os.ReadDir(dir)
-	if err != nil && !errors.Is(err, os.ErrNotExist) {
-		return fmt.Errorf("read hls dir: %w", err)
+// serveMediaPlaylist serves one of ffmpeg's playlists with the now playing
+// tags added.
+func (s *Streamer) serveMediaPlaylist(w http.ResponseWriter, r *http.Request, name string) {
+	var f storedFile
+	ok := false
+	if s.store != nil {
+		f, ok = s.store.get(name)
 	}
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	serveBytes(w, r, name, f.modTime, s.withDateRanges(f.data))
+}
 
-	for _, e := range entries {
-		path := filepath.Join(dir, e.Name())
-		if err := os.RemoveAll(path); err != nil {
-			return fmt.Errorf("remove %q: %w", path, err)
-		}
+// ffmpegPlaylist is the playlist ffmpeg writes for r.
+func (s *Streamer) ffmpegPlaylist(r Rendition) string {
+	if s.lowLatency {
+		return partsPlaylist(r)
 	}
+	return r.Playlist()
+}
 
-	return nil
+func (s *Streamer) defaultPlaylist() string {
+	if len(s.aac) > 0 {
+		return s.aac[0].Playlist()
+	}
+	return s.ladder[0].Playlist()
 }
 
-func buildArgs(segmentPrefix string) []string {
+// buildArgs encodes the AAC renditions in one ffmpeg: the input is split
+// once and each rendition gets its own encoder and media playlist.
+// master.m3u8 is ours so it carries proper BANDWIDTH/CODECS. Everything is
+// PUT to baseURL.
+func buildArgs(baseURL, segmentPrefix string, ladder []Rendition, partDuration time.Duration) []string {
 	logLevel := strings.TrimSpace(os.Getenv("FFMPEG_LOGLEVEL_HLS"))
 	if logLevel == "" {
 		logLevel = "warning"
 	}
 
-	common := []string{
+	var split strings.Builder
+	fmt.Fprintf(&split, "[0:a:0]asetpts=N/SR/TB,asplit=%d", len(ladder))
+	streamMap := make([]string, 0, len(ladder))
+	for i, r := range ladder {
+		fmt.Fprintf(&split, "[a%d]", i)
+		streamMap = append(streamMap, fmt.Sprintf("a:%d,name:%s", i, r.Name))
+	}
+
+	args := []string{
 		"-hide_banner",
 		"-loglevel", logLevel,
 		"-fflags", "+igndts+genpts",
 		"-use_wallclock_as_timestamps", "1",
 		"-f", "ogg",
 		"-i", "pipe:0",
-		"-map", "0:a:0",
-		"-c:a", "aac",
+		"-filter_complex", split.String(),
+	}
+	for i, r := range ladder {
+		args = append(args, r.encoderArgs(i)...)
+	}
+	args = append(args,
 		"-ac", "2",
 		"-ar", "48000",
-		"-b:a", "192k",
-		"-profile:a", "aac_low",
-		"-af", "asetpts=N/SR/TB",
-	}
+	)
 
 	segmentPrefix = strings.TrimSuffix(strings.TrimSpace(segmentPrefix), "/")
-	segmentPattern := "segment_%05d.m4s"
-	initFilename := "init.mp4"
+	segmentPattern := "segment_%v_%05d.m4s"
+	initFilename := "init_%v.mp4"
+	if len(ladder) == 1 {
+		// ffmpeg only expands %v in the init name with more than one variant.
+		initFilename = "init_" + ladder[0].Name + ".mp4"
+	}
 	if segmentPrefix != "" {
-		segmentPattern = segmentPrefix + "/segment_%05d.m4s"
-		initFilename = segmentPrefix + "/init.mp4"
+		segmentPattern = segmentPrefix + "/" + segmentPattern
+		initFilename = segmentPrefix + "/" + initFilename
 	}
+	// the init name is taken relative to the playlist, segment names aren't.
+	baseURL = strings.TrimSuffix(baseURL, "/")
+	segmentPattern = baseURL + "/" + segmentPattern
 	segmentDuration := "3"
+	listSize := playlistSegments
+	playlistPattern := "live_%v.m3u8"
+	if partDuration > 0 {
+		// LL-HLS: ffmpeg cuts parts, we put the segments together.
+		segmentDuration = strconv.FormatFloat(partDuration.Seconds(), 'f', 3, 64)
+		listSize *= partsPerSegment(partDuration)
+		playlistPattern = "parts_%v.m3u8"
+	}
+	// no delete_segments, the store drops the oldest ones itself (after the
+	// DVR window, if there is one).
 	hlsFlags := strings.Join([]string{
-		"delete_segments",
 		"independent_segments",
 		"omit_endlist",
 		"program_date_time",
-		"temp_file",
 	}, "+")
 
-	args := append(common,
+	args = append(args,
 		"-f", "hls",
 		"-hls_time", segmentDuration,
 		"-hls_init_time", segmentDuration,
-		"-hls_list_size", "32",
-		"-hls_delete_threshold", "200",
+		"-hls_list_size", strconv.Itoa(listSize),
 		"-hls_flags", hlsFlags,
+		// keeps the whole segment path in the playlists, not just the base name.
 		"-strftime_mkdir", "1",
+		"-method", "PUT",
+		"-http_persistent", "1",
+		"-ignore_io_errors", "1",
 		"-hls_segment_type", "fmp4",
 		"-hls_fmp4_init_filename", initFilename,
 		"-hls_segment_filename", segmentPattern,
-		"-master_pl_name", "master.m3u8",
+		"-var_stream_map", strings.Join(streamMap, " "),
 		"-hls_allow_cache", "0",
-		playlistFilename,
+		baseURL+"/"+playlistPattern,
 	)
 
 	return args
 }
 
+func ladderNames(ladder []Rendition) string {
+	names := make([]string, 0, len(ladder))
+	for _, r := range ladder {
+		names = append(names, r.Name+" "+r.Profile)
+	}
+	return strings.Join(names, ", ")
+}
+
 func (s *Streamer) setTranscoder(cmd *exec.Cmd, stdin *io.PipeWriter, allowHeaderPrime bool) {
 	s.mu.Lock()
 	old := s.stdin
 	s.stdin = stdin
 	s.cmd = cmd
 	s.startedAt = time.Now()
+	s.startPos = s.cursor.Position()
 	s.mu.Unlock()
 
 	if s.sink != nil {
@@ -515,17 +705,13 @@ func (s *Streamer) dropStdin(stdin *io.PipeWriter) {
 }
 
 func (s *Streamer) startTranscoder() (*exec.Cmd, *io.PipeWriter, error) {
-	segmentPrefix := filepath.Join("segments", uuid.New().String())
-	segmentDir := filepath.Join(s.dir, segmentPrefix)
-	if err := os.MkdirAll(segmentDir, 0o755); err != nil {
-		return nil, nil, fmt.Errorf("create hls segment dir: %w", err)
-	}
+	// a fresh prefix per run so restarts never reuse a segment name.
+	segmentPrefix := "segments/" + uuid.New().String()
 
 	pr, pw := io.Pipe()
-	args := buildArgs(filepath.ToSlash(segmentPrefix))
+	args := buildArgs(s.store.baseURL(), segmentPrefix, s.aac, s.partDuration)
 
 	cmd := exec.Command(s.ffmpegBin, args...)
-	cmd.Dir = s.dir
 	cmd.Stdin = pr
 	cmd.Stdout = io.Discard
 	cmd.Stderr = &lineLogger{prefix: "ffmpeg (hls): "}
@@ -593,7 +779,7 @@ func (s *Streamer) monitorPlaylist() {
 	ticker := time.NewTicker(ffmpegStaleCheckEvery)
 	defer ticker.Stop()
 
-	playlistPath := filepath.Join(s.dir, playlistFilename)
+	playlist := s.ffmpegPlaylist(s.aac[0])
 
 	for {
 		select {
@@ -617,8 +803,8 @@ func (s *Streamer) monitorPlaylist() {
 			continue
 		}
 
-		info, err := os.Stat(playlistPath)
-		if err != nil {
+		f, ok := s.store.get(playlist)
+		if !ok {
 			if time.Since(startedAt) > ffmpegStalePlaylistAge {
 				log.Printf("hls playlist missing; restarting ffmpeg")
 				_ = cmd.Process.Kill()
@@ -626,7 +812,7 @@ func (s *Streamer) monitorPlaylist() {
 			continue
 		}
 
-		if time.Since(info.ModTime()) > ffmpegStalePlaylistAge && time.Since(startedAt) > ffmpegStalePlaylistAge {
+		if time.Since(f.modTime) > ffmpegStalePlaylistAge && time.Since(startedAt) > ffmpegStalePlaylistAge {
 			log.Printf("hls playlist stale; restarting ffmpeg")
 			_ = cmd.Process.Kill()
 		}
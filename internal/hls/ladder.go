@@ -0,0 +1,158 @@
+package hls
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Rendition is one rung of the HLS ladder.
+type Rendition struct {
+	Name    string // media playlist is live_<name>.m3u8
+	Bitrate int    // bits per second
+	Profile string // aac (LC), he-aac, he-aacv2 or opus
+}
+
+const (
+	profileLC      = "aac"
+	profileHE      = "he-aac"
+	profileHEv2    = "he-aacv2"
+	profileOpus    = "opus"
+	masterFilename = "master.m3u8"
+
+	// fmp4 boxes on top of the audio bitrate, for BANDWIDTH.
+	containerOverhead = 1.1
+)
+
+// DefaultLadder is what HLS_LADDER falls back to: the single 192k AAC-LC
+// rendition every player can start on. Opus is opt-in, e.g. "192k|128k:opus".
+var DefaultLadder = []Rendition{
+	{Name: "192k", Bitrate: 192000, Profile: profileLC},
+}
+
+// ParseLadder parses a "|" separated list of bitrates with an optional
+// profile, e.g. "128k:opus|48k:he-aacv2|96k|192k". The first rendition is the
+// one players start on. opus is the stream itself packaged in-process (its
+// bitrate is only what gets advertised), the rest are encoded by ffmpeg.
+// HE-AAC needs an ffmpeg built with libfdk_aac.
+func ParseLadder(raw string) ([]Rendition, error) {
+	var ladder []Rendition
+	seen := map[string]bool{}
+
+	for _, entry := range strings.Split(raw, "|") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		rate, profile, _ := strings.Cut(entry, ":")
+		rate = strings.ToLower(strings.TrimSpace(rate))
+		profile = strings.ToLower(strings.TrimSpace(profile))
+		if profile == "" {
+			profile = profileLC
+		}
+
+		bitrate, err := parseBitrate(rate)
+		if err != nil {
+			return nil, fmt.Errorf("hls ladder %q: %w", entry, err)
+		}
+
+		switch profile {
+		case profileLC, profileHE, profileHEv2, profileOpus:
+		default:
+			return nil, fmt.Errorf("hls ladder %q: unknown profile %q (aac, he-aac, he-aacv2 or opus)", entry, profile)
+		}
+
+		name := strconv.Itoa(bitrate/1000) + "k"
+		if profile == profileOpus {
+			name = profileOpus
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("hls ladder %q: %s is listed twice", entry, name)
+		}
+		seen[name] = true
+
+		ladder = append(ladder, Rendition{Name: name, Bitrate: bitrate, Profile: profile})
+	}
+
+	if len(ladder) == 0 {
+		return DefaultLadder, nil
+	}
+	return ladder, nil
+}
+
+func parseBitrate(v string) (int, error) {
+	mult := 1
+	if strings.HasSuffix(v, "k") {
+		mult = 1000
+		v = strings.TrimSuffix(v, "k")
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		return 0, errors.New("bitrate should look like 96k")
+	}
+	n *= mult
+	if n < 8000 || n > 512000 {
+		return 0, errors.New("bitrate should be between 8k and 512k")
+	}
+	return n, nil
+}
+
+// Playlist is the rendition's media playlist.
+func (r Rendition) Playlist() string {
+	return "live_" + r.Name + ".m3u8"
+}
+
+// Codecs is the RFC 6381 codec string for the master playlist.
+func (r Rendition) Codecs() string {
+	switch r.Profile {
+	case profileHE:
+		return "mp4a.40.5"
+	case profileHEv2:
+		return "mp4a.40.29"
+	case profileOpus:
+		return "opus"
+	default:
+		return "mp4a.40.2"
+	}
+}
+
+// encoderArgs picks the encoder for output stream i.
+func (r Rendition) encoderArgs(i int) []string {
+	idx := strconv.Itoa(i)
+	encoder, profile := "aac", "aac_low"
+	switch r.Profile {
+	case profileHE:
+		encoder, profile = "libfdk_aac", "aac_he"
+	case profileHEv2:
+		encoder, profile = "libfdk_aac", "aac_he_v2"
+	}
+
+	return []string{
+		"-map", "[a" + idx + "]",
+		"-c:a:" + idx, encoder,
+		"-profile:a:" + idx, profile,
+		"-b:a:" + idx, strconv.Itoa(r.Bitrate),
+	}
+}
+
+// masterPlaylist lists every rendition with the attributes players use to
+// switch between them.
+// With dvr it points at the DVR playlists instead.
+func masterPlaylist(ladder []Rendition, dvr bool) string {
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n")
+	b.WriteString("#EXT-X-VERSION:7\n")
+	b.WriteString("#EXT-X-INDEPENDENT-SEGMENTS\n")
+	for _, r := range ladder {
+		fmt.Fprintf(&b, "#EXT-X-STREAM-INF:BANDWIDTH=%d,AVERAGE-BANDWIDTH=%d,CODECS=\"%s\"\n",
+			int(float64(r.Bitrate)*containerOverhead), r.Bitrate, r.Codecs())
+		if dvr {
+			b.WriteString(dvrPlaylist(r) + "\n")
+		} else {
+			b.WriteString(r.Playlist() + "\n")
+		}
+	}
+	return b.String()
+}
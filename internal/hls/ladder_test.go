@@ -0,0 +1,79 @@
+package hls
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseLadder(t *testing.T) {
+	tests := []struct {
+		raw      string
+		expected []Rendition
+		fails    bool
+	}{
+		{"", []Rendition{{Name: "192k", Bitrate: 192000, Profile: "aac"}}, false},
+		{
+			"48k:he-aacv2|96k|192000",
+			[]Rendition{
+				{Name: "48k", Bitrate: 48000, Profile: "he-aacv2"},
+				{Name: "96k", Bitrate: 96000, Profile: "aac"},
+				{Name: "192k", Bitrate: 192000, Profile: "aac"},
+			},
+			false,
+		},
+		{"96k|96k:he-aac", nil, true},
+		{
+			"128k:opus|96k",
+			[]Rendition{
+				{Name: "opus", Bitrate: 128000, Profile: "opus"},
+				{Name: "96k", Bitrate: 96000, Profile: "aac"},
+			},
+			false,
+		},
+		{"128k:opus|96k:opus", nil, true},
+		{"96k:vorbis", nil, true},
+		{"fast", nil, true},
+		{"4k", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			ladder, err := ParseLadder(tt.raw)
+			if tt.fails {
+				if err == nil {
+					t.Fatalf("expected an error but got %v", ladder)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error but got %v", err)
+			}
+			if len(ladder) != len(tt.expected) {
+				t.Fatalf("expected %v but got %v", tt.expected, ladder)
+			}
+			for i := range ladder {
+				if ladder[i] != tt.expected[i] {
+					t.Fatalf("expected %v but got %v", tt.expected[i], ladder[i])
+				}
+			}
+		})
+	}
+}
+
+func TestMasterPlaylist(t *testing.T) {
+	ladder, err := ParseLadder("128k:opus|48k:he-aacv2|192k")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	master := masterPlaylist(ladder, false)
+	for _, line := range []string{
+		`#EXT-X-STREAM-INF:BANDWIDTH=140800,AVERAGE-BANDWIDTH=128000,CODECS="opus"` + "\nlive_opus.m3u8",
+		`#EXT-X-STREAM-INF:BANDWIDTH=52800,AVERAGE-BANDWIDTH=48000,CODECS="mp4a.40.29"` + "\nlive_48k.m3u8",
+		`#EXT-X-STREAM-INF:BANDWIDTH=211200,AVERAGE-BANDWIDTH=192000,CODECS="mp4a.40.2"` + "\nlive_192k.m3u8",
+	} {
+		if !strings.Contains(master, line) {
+			t.Fatalf("expected %q in\n%s", line, master)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package hls
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// now playing changes go into the media playlists as EXT-X-DATERANGE tags,
+// dated on the same wall clock as EXT-X-PROGRAM-DATE-TIME so players can
+// switch the title when the new track is actually heard.
+
+const (
+	nowPlayingClass = "com.eggsfm.nowplaying"
+	// a bit longer than the longest live playlist window.
+	nowPlayingKeep = 10 * time.Minute
+)
+
+type nowPlayingMark struct {
+	at      time.Time
+	title   string
+	artists []string
+}
+
+// SetNowPlaying marks a track change at the current cursor position.
+func (s *Streamer) SetNowPlaying(title string, artists []string) {
+	if s == nil {
+		return
+	}
+
+	at := s.timelineAt(s.cursor.Position())
+	keepFor := max(nowPlayingKeep, s.dvrWindow+time.Minute)
+
+	s.metaMu.Lock()
+	defer s.metaMu.Unlock()
+
+	keep := s.marks[:0]
+	for i, m := range s.marks {
+		// always keep the newest one, it's what's on air until this change.
+		if at.Sub(m.at) < keepFor || i == len(s.marks)-1 {
+			keep = append(keep, m)
+		}
+	}
+	s.marks = append(keep, nowPlayingMark{
+		at:      at,
+		title:   title,
+		artists: append([]string(nil), artists...),
+	})
+}
+
+// timelineAt maps a cursor position to the wall clock ffmpeg uses for
+// EXT-X-PROGRAM-DATE-TIME: the transcoder was started at startedAt with the
+// cursor at startPos, and the cursor only moves as audio is written.
+func (s *Streamer) timelineAt(pos time.Duration) time.Time {
+	s.mu.RLock()
+	startedAt, startPos := s.startedAt, s.startPos
+	s.mu.RUnlock()
+
+	if startedAt.IsZero() {
+		return time.Now()
+	}
+	return startedAt.Add(pos - startPos)
+}
+
+// dateRangeTags returns the tags for a playlist that starts at from: every
+// change since then plus the one that was on air at the time.
+func (s *Streamer) dateRangeTags(from time.Time) []string {
+	s.metaMu.Lock()
+	defer s.metaMu.Unlock()
+
+	first := 0
+	for i, m := range s.marks {
+		if m.at.After(from) {
+			break
+		}
+		first = i
+	}
+
+	var tags []string
+	for _, m := range s.marks[first:] {
+		tags = append(tags, m.tag())
+	}
+	return tags
+}
+
+func (m nowPlayingMark) tag() string {
+	attr := []string{
+		fmt.Sprintf(`ID="np-%d"`, m.at.UnixMilli()),
+		fmt.Sprintf(`CLASS="%s"`, nowPlayingClass),
+		fmt.Sprintf(`START-DATE="%s"`, m.at.UTC().Format("2006-01-02T15:04:05.000Z")),
+		fmt.Sprintf(`X-TITLE="%s"`, quotedString(m.title)),
+	}
+	if len(m.artists) > 0 {
+		attr = append(attr, fmt.Sprintf(`X-ARTIST="%s"`, quotedString(strings.Join(m.artists, ", "))))
+	}
+	return "#EXT-X-DATERANGE:" + strings.Join(attr, ",")
+}
+
+// quotedString makes v fit an HLS quoted-string (no quotes or line breaks).
+func quotedString(v string) string {
+	return strings.NewReplacer(`"`, "'", "\r", " ", "\n", " ").Replace(v)
+}
+
+// withDateRanges adds the now playing tags to one of ffmpeg's media
+// playlists, right before its first segment.
+func (s *Streamer) withDateRanges(playlist []byte) []byte {
+	var (
+		out      bytes.Buffer
+		inserted bool
+	)
+
+	sc := bufio.NewScanner(bytes.NewReader(playlist))
+	for sc.Scan() {
+		line := sc.Text()
+		if !inserted && strings.HasPrefix(line, "#EXT-X-PROGRAM-DATE-TIME:") {
+			inserted = true
+			if from := parsePDT(strings.TrimPrefix(line, "#EXT-X-PROGRAM-DATE-TIME:")); !from.IsZero() {
+				for _, tag := range s.dateRangeTags(from) {
+					out.WriteString(tag + "\n")
+				}
+			}
+		}
+		out.WriteString(line + "\n")
+	}
+	return out.Bytes()
+}
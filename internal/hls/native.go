@@ -0,0 +1,110 @@
+package hls
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/philipch07/EggsFM/internal/audio"
+)
+
+// opusPackager muxes the tee'd Opus packets straight into fMP4 parts held in
+// memory: no ffmpeg, no disk, and no timestamps to wrap.
+type opusPackager struct {
+	list         *partList
+	partDuration time.Duration
+
+	mu         sync.Mutex
+	packetizer audio.OggOpusPacketizer
+	samples    []fmp4Sample
+	pending    time.Duration // length of samples
+	decodeTime uint64        // of samples[0], in opusTimescale units
+	anchor     time.Time     // wall clock at decode time 0
+	partStart  time.Time
+	seq        int
+	head       opusHead // of the track being packaged
+	inits      int      // init segments made so far
+}
+
+// the tee runs at most a page or so ahead of playback; past this the stream
+// stalled or skipped and the part dates get re-anchored on the wall clock.
+const pdtSlack = 2 * time.Second
+
+func newOpusPackager(list *partList, partDuration time.Duration) *opusPackager {
+	list.setInit(list.rendition.Name+"/init.mp4", opusInitSegment(defaultOpusHead))
+	p := &opusPackager{list: list, partDuration: partDuration, head: defaultOpusHead}
+	p.packetizer.OnHead = p.setHead
+	return p
+}
+
+// setHead picks up the OpusHead of the next track. Tracks with a different
+// channel count, pre-skip or gain get an init segment of their own, which
+// starts a new run in the list the way an ffmpeg restart does.
+func (p *opusPackager) setHead(pkt []byte) {
+	head, ok := parseOpusHead(pkt)
+	if !ok || head == p.head {
+		return
+	}
+	if len(p.samples) > 0 {
+		p.flush()
+	}
+
+	p.head = head
+	p.inits++
+	p.list.setInit(fmt.Sprintf("%s/init_%d.mp4", p.list.rendition.Name, p.inits), opusInitSegment(head))
+}
+
+// Write takes raw Ogg bytes from the tee. It never fails.
+func (p *opusPackager) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.packetizer.Feed(b, p.addPacket)
+	return len(b), nil
+}
+
+func (p *opusPackager) addPacket(pkt []byte) {
+	dur := audio.OpusPacketDuration(pkt)
+	if dur <= 0 {
+		dur = 20 * time.Millisecond
+	}
+
+	if len(p.samples) == 0 {
+		now := time.Now()
+		elapsed := time.Duration(float64(p.decodeTime) / opusTimescale * float64(time.Second))
+		p.partStart = p.anchor.Add(elapsed)
+		if p.anchor.IsZero() || p.partStart.Sub(now).Abs() > pdtSlack {
+			p.anchor = now.Add(-elapsed)
+			p.partStart = now
+		}
+	}
+	p.samples = append(p.samples, fmp4Sample{
+		data:     pkt,
+		duration: uint32(dur * opusTimescale / time.Second),
+	})
+	p.pending += dur
+
+	if p.pending >= p.partDuration {
+		p.flush()
+	}
+}
+
+// flush turns the buffered samples into the next part.
+func (p *opusPackager) flush() {
+	var total uint64
+	for _, s := range p.samples {
+		total += uint64(s.duration)
+	}
+
+	p.list.add(llPart{
+		uri:      fmt.Sprintf("%s/part_%05d.m4s", p.list.rendition.Name, p.seq),
+		duration: float64(total) / opusTimescale,
+		pdt:      p.partStart,
+		data:     opusFragment(uint32(p.seq+1), p.decodeTime, p.samples),
+	})
+
+	p.seq++
+	p.decodeTime += total
+	p.samples = nil
+	p.pending = 0
+}
@@ -0,0 +1,660 @@
+package hls
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// playlists we serve ourselves, made of parts: short fMP4 fragments, every
+// partsPerSegment of which make a segment (fMP4 fragments concatenate fine).
+// Parts come from ffmpeg (it uploads them plus an ordinary playlist of them,
+// parts_<name>.m3u8, for the LL-HLS AAC renditions) or straight from the
+// native Opus packager in memory. With low latency on, live_<name>.m3u8 gets
+// EXT-X-PART/EXT-X-PRELOAD-HINT and blocking reload. Without it the AAC
+// lists just follow ffmpeg's own live_<name>.m3u8 (which is served as is) to
+// give DASH its numbered segments.
+
+const (
+	defaultPartDuration = 500 * time.Millisecond
+	// matches the segment length of ffmpeg's regular playlists.
+	segmentDuration = 3 * time.Second
+	// only the last few segments list their parts.
+	llPartSegments = 3
+	// ask for an msn further ahead than this and you get a 400.
+	llMaxSegmentsAhead = 2
+)
+
+var (
+	partNumberPattern = regexp.MustCompile(`(\d+)(\.m4s)$`)
+	segmentPattern    = regexp.MustCompile(`^seg_(.+)_(\d+)\.m4s$`)
+
+	errLLGone  = errors.New("segment is no longer available")
+	errLLAhead = errors.New("requested segment is too far ahead")
+)
+
+type llPart struct {
+	uri      string // relative to /api/hls/
+	duration float64
+	pdt      time.Time
+	data     []byte // nil when the part is in the store
+	initURI  string // of the run it came from
+	run      int    // which ffmpeg run or native init segment, every new one is a discontinuity
+}
+
+// partList is the running list of parts for one rendition.
+type partList struct {
+	rendition       Rendition
+	store           *segmentStore
+	source          string // ffmpeg's playlist in the store, empty for native
+	partsPerSegment int
+	lowLatency      bool
+	maxParts        int // how many parts to keep
+
+	mu         sync.Mutex
+	etag       string    // of the source last read
+	dashAnchor time.Time // wall clock at media time 0
+	initURI    string
+	initData   []byte
+	inits      map[string][]byte // in-memory init segments parts still refer to
+	firstSeq   int               // media sequence of parts[0]
+	offset     int               // added to ffmpeg's numbers, which start over when it restarts
+	runs       int               // ffmpeg restarts or native init segments seen
+	parts      []llPart
+	partTarget float64
+	updated    chan struct{} // closed and replaced on every change
+}
+
+func partsPerSegment(part time.Duration) int {
+	return max(1, int(math.Round(float64(segmentDuration)/float64(part))))
+}
+
+func partsPlaylist(r Rendition) string {
+	return "parts_" + r.Name + ".m3u8"
+}
+
+func dvrPlaylist(r Rendition) string {
+	return "dvr_" + r.Name + ".m3u8"
+}
+
+// newPartList follows ffmpeg's output for r, its parts with low latency on
+// and its segments otherwise.
+func newPartList(store *segmentStore, r Rendition, part time.Duration, lowLatency bool) *partList {
+	if !lowLatency {
+		return &partList{
+			rendition:       r,
+			store:           store,
+			source:          r.Playlist(),
+			partsPerSegment: 1,
+			maxParts:        playlistSegments,
+			partTarget:      segmentDuration.Seconds(),
+			updated:         make(chan struct{}),
+		}
+	}
+	return &partList{
+		rendition:       r,
+		store:           store,
+		source:          partsPlaylist(r),
+		partsPerSegment: partsPerSegment(part),
+		lowLatency:      true,
+		maxParts:        playlistSegments * partsPerSegment(part),
+		partTarget:      part.Seconds(),
+		updated:         make(chan struct{}),
+	}
+}
+
+// keepSegments holds on to n segments rather than just the live playlist's,
+// for the DVR window.
+func (l *partList) keepSegments(n int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.maxParts = max(l.maxParts, n*l.partsPerSegment)
+}
+
+// newMemoryPartList holds parts handed over with add. Without low latency a
+// part is a whole segment.
+func newMemoryPartList(r Rendition, part time.Duration, lowLatency bool) *partList {
+	per := 1
+	if lowLatency {
+		per = partsPerSegment(part)
+	}
+	return &partList{
+		rendition:       r,
+		partsPerSegment: per,
+		lowLatency:      lowLatency,
+		maxParts:        playlistSegments * per,
+		partTarget:      part.Seconds(),
+		updated:         make(chan struct{}),
+	}
+}
+
+// setInit sets the init segment of an in-memory rendition. Once there are
+// parts, a new one starts a new run after the last whole segment, the same as
+// refresh does for an ffmpeg restart.
+func (l *partList) setInit(uri string, data []byte) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.initURI != "" && uri != l.initURI && len(l.parts) > 0 {
+		next := (l.lastSeq() + 1) / l.partsPerSegment * l.partsPerSegment
+		l.parts = l.parts[:max(0, next-l.firstSeq)]
+		l.runs++
+	}
+	l.initURI = uri
+	l.initData = data
+	if l.inits == nil {
+		l.inits = map[string][]byte{}
+	}
+	l.inits[uri] = data
+	l.pruneInits()
+}
+
+// add appends the next in-memory part, dropping the oldest past maxParts.
+func (l *partList) add(p llPart) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	p.initURI, p.run = l.initURI, l.runs
+	l.parts = append(l.parts, p)
+	if drop := len(l.parts) - l.maxParts; drop > 0 {
+		l.parts = append(l.parts[:0:0], l.parts[drop:]...)
+		l.firstSeq += drop
+		l.pruneInits()
+	}
+	l.partTarget = max(l.partTarget, p.duration)
+
+	close(l.updated)
+	l.updated = make(chan struct{})
+}
+
+// file looks up an in-memory init segment or part.
+func (l *partList) file(uri string) ([]byte, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if data, ok := l.inits[uri]; ok {
+		return data, true
+	}
+	for _, p := range l.parts {
+		if p.uri == uri && p.data != nil {
+			return p.data, true
+		}
+	}
+	return nil, false
+}
+
+// pruneInits lets go of init segments no part refers to any more.
+func (l *partList) pruneInits() {
+	for uri := range l.inits {
+		if uri == l.initURI || slices.ContainsFunc(l.parts, func(p llPart) bool { return p.initURI == uri }) {
+			continue
+		}
+		delete(l.inits, uri)
+	}
+}
+
+// refresh rereads ffmpeg's playlist if it changed.
+func (l *partList) refresh() {
+	f, ok := l.store.get(l.source)
+	if !ok {
+		return
+	}
+
+	l.mu.Lock()
+	unchanged := f.etag == l.etag
+	l.mu.Unlock()
+	if unchanged {
+		return
+	}
+
+	initURI, firstSeq, parts := parsePartsPlaylist(f.data)
+	if len(parts) == 0 {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// every ffmpeg run has its own init segment and counts from 0 again. carry
+	// on after the old run's last whole segment instead, so a
+	// seg_<name>_<msn>.m4s never means two different things and the media
+	// sequence never goes back. the old run's unfinished segment was never
+	// listed whole, and its parts can't be joined up with the new run's.
+	if l.initURI != "" && initURI != l.initURI && len(l.parts) > 0 {
+		next := (l.lastSeq() + 1) / l.partsPerSegment * l.partsPerSegment
+		l.parts = l.parts[:max(0, next-l.firstSeq)]
+		l.offset = next - firstSeq
+		l.runs++
+	}
+	firstSeq += l.offset
+	for i := range parts {
+		parts[i].run = l.runs
+	}
+
+	// hold on to the parts that scrolled out of ffmpeg's playlist, the old
+	// runs' too.
+	if len(l.parts) > 0 && firstSeq > l.firstSeq && firstSeq <= l.lastSeq()+1 {
+		parts = append(append([]llPart(nil), l.parts[:firstSeq-l.firstSeq]...), parts...)
+		firstSeq = l.firstSeq
+	}
+	if drop := len(parts) - l.maxParts; drop > 0 {
+		parts = parts[drop:]
+		firstSeq += drop
+	}
+
+	l.etag = f.etag
+	l.initURI = initURI
+	l.firstSeq = firstSeq
+	l.parts = parts
+	for _, p := range parts {
+		l.partTarget = max(l.partTarget, p.duration)
+	}
+
+	close(l.updated)
+	l.updated = make(chan struct{})
+}
+
+// parsePartsPlaylist reads the bits of an ffmpeg media playlist we need.
+func parsePartsPlaylist(raw []byte) (initURI string, firstSeq int, parts []llPart) {
+	var (
+		duration float64
+		pdt      time.Time
+	)
+
+	sc := bufio.NewScanner(bytes.NewReader(raw))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"):
+			firstSeq, _ = strconv.Atoi(strings.TrimPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"))
+		case strings.HasPrefix(line, "#EXT-X-MAP:"):
+			if _, rest, ok := strings.Cut(line, `URI="`); ok {
+				initURI, _, _ = strings.Cut(rest, `"`)
+			}
+		case strings.HasPrefix(line, "#EXT-X-PROGRAM-DATE-TIME:"):
+			pdt = parsePDT(strings.TrimPrefix(line, "#EXT-X-PROGRAM-DATE-TIME:"))
+		case strings.HasPrefix(line, "#EXTINF:"):
+			v, _, _ := strings.Cut(strings.TrimPrefix(line, "#EXTINF:"), ",")
+			duration, _ = strconv.ParseFloat(v, 64)
+		case strings.HasPrefix(line, "#"):
+		default:
+			parts = append(parts, llPart{uri: line, duration: duration, pdt: pdt, initURI: initURI})
+			if !pdt.IsZero() {
+				pdt = pdt.Add(time.Duration(duration * float64(time.Second)))
+			}
+			duration = 0
+		}
+	}
+	return initURI, firstSeq, parts
+}
+
+func parsePDT(v string) time.Time {
+	v = strings.TrimSpace(v)
+	for _, layout := range []string{"2006-01-02T15:04:05.999-0700", time.RFC3339Nano} {
+		if t, err := time.Parse(layout, v); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// lastSeq is the sequence number of the newest part, -1 if there is none.
+func (l *partList) lastSeq() int {
+	return l.firstSeq + len(l.parts) - 1
+}
+
+func (l *partList) has(msn, part int) bool {
+	if len(l.parts) == 0 {
+		return false
+	}
+	if part < 0 {
+		part = l.partsPerSegment - 1
+	}
+	return l.lastSeq() >= msn*l.partsPerSegment+part
+}
+
+// wait blocks until part of msn is out (the whole segment when part < 0).
+func (l *partList) wait(ctx context.Context, msn, part int) error {
+	for {
+		l.mu.Lock()
+		ready := l.has(msn, part)
+		ahead := len(l.parts) > 0 && msn > l.lastSeq()/l.partsPerSegment+llMaxSegmentsAhead
+		updated := l.updated
+		l.mu.Unlock()
+
+		if ready {
+			return nil
+		}
+		if ahead {
+			return errLLAhead
+		}
+
+		select {
+		case <-updated:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (l *partList) targetDuration() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return time.Duration(l.partTarget*float64(l.partsPerSegment)*float64(time.Second)) + time.Second
+}
+
+// playlist renders live_<name>.m3u8, or with dvr dvr_<name>.m3u8: every
+// segment we have and no parts. marks are the now playing changes to tag
+// with EXT-X-DATERANGE.
+func (l *partList) playlist(marks func(from time.Time) []string, dvr bool) []byte {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	per := l.partsPerSegment
+	// start on a segment boundary.
+	skip := (per - l.firstSeq%per) % per
+	if skip >= len(l.parts) {
+		return nil
+	}
+	parts := l.parts[skip:]
+	firstMSN := (l.firstSeq + skip) / per
+
+	var segments [][]llPart
+	for i := 0; i < len(parts); i += per {
+		segments = append(segments, parts[i:min(i+per, len(parts))])
+	}
+	if drop := len(segments) - playlistSegments; !dvr && drop > 0 {
+		segments = segments[drop:]
+		firstMSN += drop
+	}
+	lowLatency := l.lowLatency && !dvr
+
+	target := 1
+	for _, seg := range segments {
+		target = max(target, int(math.Round(sumDurations(seg))))
+	}
+
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n")
+	if lowLatency {
+		b.WriteString("#EXT-X-VERSION:9\n")
+	} else {
+		b.WriteString("#EXT-X-VERSION:7\n")
+	}
+	fmt.Fprintf(&b, "#EXT-X-TARGETDURATION:%d\n", target)
+	if lowLatency {
+		fmt.Fprintf(&b, "#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,PART-HOLD-BACK=%.3f\n", 3*l.partTarget)
+		fmt.Fprintf(&b, "#EXT-X-PART-INF:PART-TARGET=%.3f\n", l.partTarget)
+	}
+	fmt.Fprintf(&b, "#EXT-X-MEDIA-SEQUENCE:%d\n", firstMSN)
+	if run := segments[0][0].run; run > 0 {
+		fmt.Fprintf(&b, "#EXT-X-DISCONTINUITY-SEQUENCE:%d\n", run)
+	}
+	initURI := l.partInit(segments[0][0])
+	if initURI != "" {
+		fmt.Fprintf(&b, "#EXT-X-MAP:URI=\"%s\"\n", initURI)
+	}
+	if from := segments[0][0].pdt; marks != nil && !from.IsZero() {
+		for _, tag := range marks(from) {
+			b.WriteString(tag + "\n")
+		}
+	}
+
+	for i, seg := range segments {
+		msn := firstMSN + i
+		if !lowLatency && len(seg) < per {
+			continue
+		}
+		if i > 0 && seg[0].run != segments[i-1][0].run {
+			b.WriteString("#EXT-X-DISCONTINUITY\n")
+			if next := l.partInit(seg[0]); next != initURI {
+				initURI = next
+				fmt.Fprintf(&b, "#EXT-X-MAP:URI=\"%s\"\n", initURI)
+			}
+		}
+		if !seg[0].pdt.IsZero() {
+			fmt.Fprintf(&b, "#EXT-X-PROGRAM-DATE-TIME:%s\n", seg[0].pdt.UTC().Format("2006-01-02T15:04:05.000Z"))
+		}
+		if lowLatency && i >= len(segments)-llPartSegments {
+			for _, p := range seg {
+				fmt.Fprintf(&b, "#EXT-X-PART:DURATION=%.3f,URI=\"%s\",INDEPENDENT=YES\n", p.duration, p.uri)
+			}
+		}
+		if len(seg) == per {
+			fmt.Fprintf(&b, "#EXTINF:%.3f,\n", sumDurations(seg))
+			fmt.Fprintf(&b, "seg_%s_%d.m4s\n", l.rendition.Name, msn)
+		}
+	}
+
+	if next := nextPartURI(parts[len(parts)-1].uri); lowLatency && next != "" {
+		fmt.Fprintf(&b, "#EXT-X-PRELOAD-HINT:TYPE=PART,URI=\"%s\"\n", next)
+	}
+
+	return []byte(b.String())
+}
+
+// partInit is the init segment that goes with p.
+func (l *partList) partInit(p llPart) string {
+	if p.initURI != "" {
+		return p.initURI
+	}
+	return l.initURI
+}
+
+func sumDurations(parts []llPart) float64 {
+	total := 0.0
+	for _, p := range parts {
+		total += p.duration
+	}
+	return total
+}
+
+// nextPartURI guesses the name ffmpeg will give the next part.
+func nextPartURI(uri string) string {
+	m := partNumberPattern.FindStringSubmatchIndex(uri)
+	if m == nil {
+		return ""
+	}
+	digits := uri[m[2]:m[3]]
+	n, err := strconv.Atoi(digits)
+	if err != nil {
+		return ""
+	}
+	return uri[:m[2]] + fmt.Sprintf("%0*d", len(digits), n+1) + uri[m[3]:]
+}
+
+// segment is every part of msn, back to back.
+func (l *partList) segment(msn int) ([]byte, time.Time, error) {
+	l.mu.Lock()
+	start := msn*l.partsPerSegment - l.firstSeq
+	if start < 0 || start+l.partsPerSegment > len(l.parts) {
+		l.mu.Unlock()
+		return nil, time.Time{}, errLLGone
+	}
+	parts := append([]llPart(nil), l.parts[start:start+l.partsPerSegment]...)
+	l.mu.Unlock()
+
+	var buf bytes.Buffer
+	for _, p := range parts {
+		data, ok := l.partData(p)
+		if !ok {
+			return nil, time.Time{}, errLLGone
+		}
+		buf.Write(data)
+	}
+	return buf.Bytes(), parts[0].pdt, nil
+}
+
+// isPreloadHint reports whether uri is the part we told players to expect next.
+func (l *partList) isPreloadHint(uri string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.parts) == 0 {
+		return false
+	}
+	return nextPartURI(l.parts[len(l.parts)-1].uri) == uri
+}
+
+func (l *partList) waitForUpdate(ctx context.Context) error {
+	l.mu.Lock()
+	updated := l.updated
+	l.mu.Unlock()
+
+	select {
+	case <-updated:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// watchParts keeps every rendition in sync with ffmpeg's uploads.
+func (s *Streamer) watchParts() {
+	for {
+		select {
+		case <-s.store.changed():
+		case <-s.closed:
+			return
+		}
+		for _, l := range s.lists {
+			if l.source != "" {
+				l.refresh()
+			}
+		}
+	}
+}
+
+func (s *Streamer) findPartList(name string) *partList {
+	for _, l := range s.lists {
+		if l.rendition.Playlist() == name || dvrPlaylist(l.rendition) == name || l.rendition.Name == name {
+			return l
+		}
+	}
+	return nil
+}
+
+// serveParts handles the playlists, segments and in-memory parts we make
+// ourselves, reporting whether it did. Everything else falls through to
+// ffmpeg's files in the store.
+func (s *Streamer) serveParts(w http.ResponseWriter, r *http.Request, name string) bool {
+	if len(s.lists) == 0 {
+		return false
+	}
+
+	// a list following the very playlist asked for leaves it to the store.
+	if l := s.findPartList(name); l != nil && l.source != name && strings.HasSuffix(name, ".m3u8") {
+		dvr := name == dvrPlaylist(l.rendition)
+		if dvr && s.dvrWindow <= 0 {
+			http.NotFound(w, r)
+			return true
+		}
+		s.servePartPlaylist(w, r, l, dvr)
+		return true
+	}
+
+	if m := segmentPattern.FindStringSubmatch(name); m != nil {
+		l := s.findPartList(m[1])
+		msn, _ := strconv.Atoi(m[2])
+		if l == nil {
+			return false
+		}
+		b, pdt, err := l.segment(msn)
+		if err != nil {
+			http.NotFound(w, r)
+			return true
+		}
+		w.Header().Set("Content-Type", "video/iso.segment")
+		w.Header().Set("Cache-Control", s.segmentCacheControl)
+		serveBytes(w, r, name, pdt, b)
+		return true
+	}
+
+	if !strings.HasSuffix(name, ".m4s") && !strings.HasSuffix(name, ".mp4") {
+		return false
+	}
+
+	// hold a preload hint request until the part is out.
+	for _, l := range s.lists {
+		if !l.isPreloadHint(name) {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), l.targetDuration())
+		defer cancel()
+		for l.isPreloadHint(name) {
+			if err := l.waitForUpdate(ctx); err != nil {
+				break
+			}
+		}
+		break
+	}
+
+	for _, l := range s.lists {
+		if data, ok := l.file(name); ok {
+			if strings.HasSuffix(name, ".mp4") {
+				w.Header().Set("Content-Type", "video/mp4")
+			} else {
+				w.Header().Set("Content-Type", "video/iso.segment")
+			}
+			w.Header().Set("Cache-Control", s.segmentCacheControl)
+			serveBytes(w, r, name, time.Time{}, data)
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Streamer) servePartPlaylist(w http.ResponseWriter, r *http.Request, l *partList, dvr bool) {
+	q := r.URL.Query()
+	blocking := l.lowLatency && !dvr
+	if rawMSN := q.Get("_HLS_msn"); rawMSN != "" && blocking {
+		msn, err := strconv.Atoi(rawMSN)
+		if err != nil || msn < 0 {
+			http.Error(w, "invalid _HLS_msn", http.StatusBadRequest)
+			return
+		}
+		part := -1
+		if rawPart := q.Get("_HLS_part"); rawPart != "" {
+			if part, err = strconv.Atoi(rawPart); err != nil || part < 0 {
+				http.Error(w, "invalid _HLS_part", http.StatusBadRequest)
+				return
+			}
+		}
+
+		// the spec gives us three target durations before we have to give up.
+		ctx, cancel := context.WithTimeout(r.Context(), 3*l.targetDuration())
+		defer cancel()
+		if err := l.wait(ctx, msn, part); err != nil {
+			if errors.Is(err, errLLAhead) {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+	} else if q.Get("_HLS_part") != "" && blocking {
+		http.Error(w, "_HLS_part needs _HLS_msn", http.StatusBadRequest)
+		return
+	}
+
+	body := l.playlist(s.dateRangeTags, dvr)
+	if body == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+	w.Header().Set("Cache-Control", playlistCacheControl)
+	serveBytes(w, r, path.Base(r.URL.Path), time.Time{}, body)
+}
@@ -0,0 +1,308 @@
+package hls
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/philipch07/EggsFM/internal/audio"
+)
+
+// writeParts fakes ffmpeg's parts playlist with parts first..last, run being
+// the segment prefix ffmpeg was started with.
+func writeParts(st *segmentStore, run string, first, last int) {
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n#EXT-X-VERSION:7\n#EXT-X-TARGETDURATION:1\n")
+	fmt.Fprintf(&b, "#EXT-X-MEDIA-SEQUENCE:%d\n", first)
+	fmt.Fprintf(&b, "#EXT-X-MAP:URI=\"segments/%s/init_96k.mp4\"\n", run)
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := first; i <= last; i++ {
+		name := fmt.Sprintf("segments/%s/segment_96k_%05d.m4s", run, i)
+		pdt := start.Add(time.Duration(i) * 500 * time.Millisecond)
+		fmt.Fprintf(&b, "#EXT-X-PROGRAM-DATE-TIME:%s\n#EXTINF:0.500000,\n%s\n", pdt.Format("2006-01-02T15:04:05.000-0700"), name)
+		st.put(name, []byte(fmt.Sprintf("[%s%d]", run, i)))
+	}
+	st.put("parts_96k.m3u8", []byte(b.String()))
+}
+
+func TestPartListPlaylist(t *testing.T) {
+	st := newSegmentStore(64)
+	l := newPartList(st, Rendition{Name: "96k", Bitrate: 96000, Profile: profileLC}, 500*time.Millisecond, true)
+	// parts 4..15: part 4 and 5 belong to a segment we only have half of.
+	writeParts(st, "x", 4, 15)
+	l.refresh()
+
+	playlist := string(l.playlist(nil, false))
+	for _, want := range []string{
+		"#EXT-X-PART-INF:PART-TARGET=0.500",
+		"#EXT-X-MEDIA-SEQUENCE:1\n",
+		"#EXTINF:3.000,\nseg_96k_1.m4s",
+		"#EXT-X-PART:DURATION=0.500,URI=\"segments/x/segment_96k_00015.m4s\",INDEPENDENT=YES",
+		"#EXT-X-PRELOAD-HINT:TYPE=PART,URI=\"segments/x/segment_96k_00016.m4s\"",
+	} {
+		if !strings.Contains(playlist, want) {
+			t.Fatalf("expected %q in\n%s", want, playlist)
+		}
+	}
+	if strings.Contains(playlist, "seg_96k_2.m4s") {
+		t.Fatalf("expected segment 2 to be incomplete but got\n%s", playlist)
+	}
+
+	seg, _, err := l.segment(1)
+	if err != nil || string(seg) != "[x6][x7][x8][x9][x10][x11]" {
+		t.Fatalf("expected parts 6-11 but got %q (%v)", seg, err)
+	}
+
+	// blocking reload: msn 2 part 0 is out already, part 1 comes later.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := l.wait(ctx, 2, 0); err != nil {
+		t.Fatalf("expected msn 2 part 0 to be ready but got %v", err)
+	}
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		writeParts(st, "x", 4, 17)
+		l.refresh()
+	}()
+	if err := l.wait(ctx, 2, 5); err != nil {
+		t.Fatalf("expected to wait for msn 2 part 5 but got %v", err)
+	}
+
+	if err := l.wait(ctx, 9, 0); err != errLLAhead {
+		t.Fatalf("expected %v but got %v", errLLAhead, err)
+	}
+}
+
+func TestPartListRestart(t *testing.T) {
+	st := newSegmentStore(512)
+	l := newPartList(st, Rendition{Name: "96k", Bitrate: 96000, Profile: profileLC}, 500*time.Millisecond, true)
+	writeParts(st, "a", 0, 27)
+	l.refresh()
+
+	// ffmpeg restarted mid-segment 4 and counts from 0 again.
+	writeParts(st, "b", 0, 13)
+	l.refresh()
+	playlist := string(l.playlist(nil, false))
+
+	for _, want := range []string{
+		"#EXT-X-MEDIA-SEQUENCE:0\n",
+		"#EXT-X-MAP:URI=\"segments/a/init_96k.mp4\"",
+		"#EXTINF:3.000,\nseg_96k_3.m4s\n#EXT-X-DISCONTINUITY\n#EXT-X-MAP:URI=\"segments/b/init_96k.mp4\"\n",
+		"#EXTINF:3.000,\nseg_96k_5.m4s",
+	} {
+		if !strings.Contains(playlist, want) {
+			t.Fatalf("expected %q after the restart in\n%s", want, playlist)
+		}
+	}
+	if strings.Contains(playlist, "segments/a/segment_96k_00024.m4s") {
+		t.Fatalf("expected the old run's unfinished segment to be dropped but got\n%s", playlist)
+	}
+
+	for msn, want := range map[int]string{
+		3: "[a18][a19][a20][a21][a22][a23]",
+		4: "[b0][b1][b2][b3][b4][b5]",
+		5: "[b6][b7][b8][b9][b10][b11]",
+	} {
+		if seg, _, err := l.segment(msn); err != nil || string(seg) != want {
+			t.Fatalf("expected segment %d to be %s but got %q (%v)", msn, want, seg, err)
+		}
+	}
+
+	// once the old run scrolls out the count of discontinuities before the
+	// first segment carries on.
+	writeParts(st, "b", 0, 6*playlistSegments+5)
+	l.refresh()
+	playlist = string(l.playlist(nil, false))
+	for _, want := range []string{"#EXT-X-DISCONTINUITY-SEQUENCE:1\n", "#EXT-X-MAP:URI=\"segments/b/init_96k.mp4\""} {
+		if !strings.Contains(playlist, want) {
+			t.Fatalf("expected %q in\n%s", want, playlist)
+		}
+	}
+	if strings.Contains(playlist, "#EXT-X-DISCONTINUITY\n") {
+		t.Fatalf("expected no discontinuity left in\n%s", playlist)
+	}
+}
+
+func TestDateRanges(t *testing.T) {
+	cursor := audio.NewCursor()
+	s := &Streamer{cursor: cursor}
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	s.startedAt = start
+
+	s.SetNowPlaying("Old", []string{"A"})
+	cursor.Advance(10 * time.Second)
+	s.SetNowPlaying(`New "Song"`, []string{"B", "C"})
+
+	playlist := "#EXTM3U\n#EXT-X-PROGRAM-DATE-TIME:2026-01-01T00:00:05.000+0000\n#EXTINF:3.000,\nsegment.m4s\n"
+	out := string(s.withDateRanges([]byte(playlist)))
+
+	for _, want := range []string{
+		`#EXT-X-DATERANGE:ID="np-1767225600000",CLASS="com.eggsfm.nowplaying",START-DATE="2026-01-01T00:00:00.000Z",X-TITLE="Old",X-ARTIST="A"`,
+		`#EXT-X-DATERANGE:ID="np-1767225610000",CLASS="com.eggsfm.nowplaying",START-DATE="2026-01-01T00:00:10.000Z",X-TITLE="New 'Song'",X-ARTIST="B, C"` + "\n#EXT-X-PROGRAM-DATE-TIME",
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("expected %q in\n%s", want, out)
+		}
+	}
+}
+
+// oggPage is just enough of a page for the packetizer, it doesn't check CRCs.
+func oggPage(packets ...[]byte) []byte {
+	var lacing, body []byte
+	for _, p := range packets {
+		n := len(p)
+		for ; n >= 255; n -= 255 {
+			lacing = append(lacing, 255)
+		}
+		lacing = append(lacing, byte(n))
+		body = append(body, p...)
+	}
+	page := append([]byte("OggS"), make([]byte, 22)...)
+	page = append(page, byte(len(lacing)))
+	page = append(page, lacing...)
+	return append(page, body...)
+}
+
+func TestOpusPackager(t *testing.T) {
+	l := newMemoryPartList(Rendition{Name: "opus", Bitrate: 128000, Profile: profileOpus}, 100*time.Millisecond, true)
+	p := newOpusPackager(l, 100*time.Millisecond)
+
+	// 20ms CELT packets, 5 to a part.
+	packet := append([]byte{0xfc}, make([]byte, 299)...)
+	stream := oggPage([]byte("OpusHead\x01\x02"))
+	stream = append(stream, oggPage([]byte("OpusTags"))...)
+	for i := 0; i < 4; i++ {
+		stream = append(stream, oggPage(packet, packet, packet)...)
+	}
+	// a few bytes at a time, like the tee would.
+	for i := 0; i < len(stream); i += 7 {
+		_, _ = p.Write(stream[i:min(i+7, len(stream))])
+	}
+
+	if len(l.parts) != 2 {
+		t.Fatalf("expected 2 parts but got %d", len(l.parts))
+	}
+
+	playlist := string(l.playlist(nil, false))
+	for _, want := range []string{
+		"#EXT-X-MAP:URI=\"opus/init.mp4\"",
+		"#EXT-X-PART:DURATION=0.100,URI=\"opus/part_00001.m4s\",INDEPENDENT=YES",
+		"#EXT-X-PRELOAD-HINT:TYPE=PART,URI=\"opus/part_00002.m4s\"",
+	} {
+		if !strings.Contains(playlist, want) {
+			t.Fatalf("expected %q in\n%s", want, playlist)
+		}
+	}
+
+	frag, ok := l.file("opus/part_00001.m4s")
+	if !ok {
+		t.Fatalf("expected part 1 to be served from memory")
+	}
+	// trun's data offset has to land on the first sample in mdat.
+	moofSize := int(frag[0])<<24 | int(frag[1])<<16 | int(frag[2])<<8 | int(frag[3])
+	trun := strings.Index(string(frag), "trun")
+	offset := int(frag[trun+12])<<24 | int(frag[trun+13])<<16 | int(frag[trun+14])<<8 | int(frag[trun+15])
+	if string(frag[moofSize+4:moofSize+8]) != "mdat" || offset != moofSize+8 {
+		t.Fatalf("expected data offset %d but got %d", moofSize+8, offset)
+	}
+	if len(frag)-offset != 5*len(packet) {
+		t.Fatalf("expected %d bytes of samples but got %d", 5*len(packet), len(frag)-offset)
+	}
+}
+
+// opusHeadPacket is an OpusHead for a channel mapping family 0 stream.
+func opusHeadPacket(channels byte, preSkip uint16) []byte {
+	return []byte{'O', 'p', 'u', 's', 'H', 'e', 'a', 'd', 1, channels, byte(preSkip), byte(preSkip >> 8), 0x44, 0xac, 0, 0, 0, 0, 0}
+}
+
+func TestOpusPackagerHeads(t *testing.T) {
+	l := newMemoryPartList(Rendition{Name: "opus", Bitrate: 128000, Profile: profileOpus}, time.Second, true)
+	p := newOpusPackager(l, time.Second)
+
+	// 20ms packets, 50 to a part and 3 parts to a segment.
+	packet := append([]byte{0xfc}, make([]byte, 99)...)
+	track := func(head []byte, parts int) {
+		_, _ = p.Write(oggPage(head))
+		_, _ = p.Write(oggPage([]byte("OpusTags")))
+		for i := 0; i < parts*5; i++ {
+			_, _ = p.Write(oggPage(packet, packet, packet, packet, packet, packet, packet, packet, packet, packet))
+		}
+	}
+
+	// the usual stereo head keeps the init segment there already.
+	track(opusHeadPacket(2, 312), 4)
+	if l.initURI != "opus/init.mp4" || len(l.parts) != 4 {
+		t.Fatalf("expected 4 parts on opus/init.mp4 but got %d on %s", len(l.parts), l.initURI)
+	}
+
+	// a mono track with a longer pre-skip gets its own, after the last whole segment.
+	track(opusHeadPacket(1, 3840), 2)
+	if len(l.parts) != 5 || l.parts[2].run != 0 || l.parts[3].run != 1 {
+		t.Fatalf("expected 3 parts of run 0 and 2 of run 1 but got %d parts", len(l.parts))
+	}
+
+	playlist := string(l.playlist(nil, false))
+	for _, want := range []string{
+		"#EXT-X-MAP:URI=\"opus/init.mp4\"",
+		"#EXT-X-DISCONTINUITY\n#EXT-X-MAP:URI=\"opus/init_1.mp4\"",
+	} {
+		if !strings.Contains(playlist, want) {
+			t.Fatalf("expected %q in\n%s", want, playlist)
+		}
+	}
+
+	for _, tc := range []struct {
+		uri      string
+		channels byte
+		preSkip  uint16
+	}{
+		{"opus/init.mp4", 2, 312},
+		{"opus/init_1.mp4", 1, 3840},
+	} {
+		init, ok := l.file(tc.uri)
+		if !ok {
+			t.Fatalf("expected %s to be served from memory", tc.uri)
+		}
+		dOps := strings.Index(string(init), "dOps") + 4
+		if channels, preSkip := init[dOps+1], uint16(init[dOps+2])<<8|uint16(init[dOps+3]); channels != tc.channels || preSkip != tc.preSkip {
+			t.Fatalf("expected %s to have %d channels and pre-skip %d but got %d and %d", tc.uri, tc.channels, tc.preSkip, channels, preSkip)
+		}
+	}
+}
+
+func TestDVRPlaylist(t *testing.T) {
+	l := newMemoryPartList(Rendition{Name: "opus", Bitrate: 128000, Profile: profileOpus}, segmentDuration, false)
+	l.keepSegments(playlistSegments + 10)
+
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < playlistSegments+20; i++ {
+		l.add(llPart{
+			uri:      fmt.Sprintf("opus/part_%05d.m4s", i),
+			duration: 3,
+			pdt:      start.Add(time.Duration(i) * segmentDuration),
+			data:     []byte{byte(i)},
+		})
+	}
+
+	live := string(l.playlist(nil, false))
+	dvr := string(l.playlist(nil, true))
+	for playlist, want := range map[string]string{
+		live: "#EXT-X-MEDIA-SEQUENCE:20\n",
+		dvr:  "#EXT-X-MEDIA-SEQUENCE:10\n",
+	} {
+		if !strings.Contains(playlist, want) {
+			t.Fatalf("expected %q in\n%s", want, playlist)
+		}
+	}
+	if n := strings.Count(dvr, "#EXTINF"); n != playlistSegments+10 {
+		t.Fatalf("expected %d segments but got %d", playlistSegments+10, n)
+	}
+
+	// segments from the DVR window are still there.
+	if seg, _, err := l.segment(10); err != nil || seg[0] != 10 {
+		t.Fatalf("expected segment 10 but got %v (%v)", seg, err)
+	}
+}
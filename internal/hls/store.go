@@ -0,0 +1,176 @@
+package hls
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// segmentStore holds ffmpeg's output in memory. ffmpeg PUTs its playlists,
+// init segments and segments to a loopback listener, so nothing touches the
+// disk and a reader never sees half a file. Past maxSegments the oldest
+// segments are dropped.
+type segmentStore struct {
+	maxSegments int
+
+	mu       sync.RWMutex
+	files    map[string]storedFile
+	segments []string // oldest first, may still list deleted ones
+	updated  chan struct{}
+
+	listener net.Listener
+	server   *http.Server
+}
+
+type storedFile struct {
+	data    []byte
+	etag    string
+	modTime time.Time
+}
+
+// a file bigger than this isn't anything ffmpeg should be sending us.
+const maxStoredFileBytes = 16 << 20
+
+func newSegmentStore(maxSegments int) *segmentStore {
+	return &segmentStore{
+		maxSegments: maxSegments,
+		files:       map[string]storedFile{},
+		updated:     make(chan struct{}),
+	}
+}
+
+// listen starts taking uploads on a random loopback port.
+func (st *segmentStore) listen() error {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return fmt.Errorf("listen for hls uploads: %w", err)
+	}
+	st.listener = ln
+	st.server = &http.Server{
+		Handler:           http.HandlerFunc(st.ingest),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		if err := st.server.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("hls upload listener stopped: %v", err)
+		}
+	}()
+	return nil
+}
+
+// baseURL is where ffmpeg should send its output.
+func (st *segmentStore) baseURL() string {
+	return "http://" + st.listener.Addr().String()
+}
+
+func (st *segmentStore) close() {
+	if st.server != nil {
+		_ = st.server.Close()
+	}
+}
+
+func (st *segmentStore) ingest(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/")
+	if name == "" || strings.Contains(name, "..") {
+		http.Error(w, "bad name", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut, http.MethodPost:
+		data, err := io.ReadAll(io.LimitReader(r.Body, maxStoredFileBytes+1))
+		if err != nil {
+			// ffmpeg went away mid upload, keep whatever we had.
+			return
+		}
+		if len(data) > maxStoredFileBytes {
+			http.Error(w, "too big", http.StatusRequestEntityTooLarge)
+			return
+		}
+		if st.listener != nil && strings.HasSuffix(name, ".m3u8") {
+			// segments are listed by their upload URL.
+			data = bytes.ReplaceAll(data, []byte(st.baseURL()+"/"), nil)
+		}
+		st.put(name, data)
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodDelete:
+		st.remove(name)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (st *segmentStore) put(name string, data []byte) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	_, existed := st.files[name]
+	st.files[name] = storedFile{data: data, etag: etagFor(data), modTime: time.Now()}
+
+	// playlists and init segments are overwritten in place, only segments
+	// pile up.
+	if strings.HasSuffix(name, ".m4s") && !existed {
+		st.segments = append(st.segments, name)
+		for len(st.segments) > st.maxSegments {
+			delete(st.files, st.segments[0])
+			st.segments = st.segments[1:]
+		}
+	}
+
+	close(st.updated)
+	st.updated = make(chan struct{})
+}
+
+func (st *segmentStore) remove(name string) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	delete(st.files, name)
+}
+
+func (st *segmentStore) get(name string) (storedFile, bool) {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	f, ok := st.files[name]
+	return f, ok
+}
+
+// changed is closed on the next upload.
+func (st *segmentStore) changed() <-chan struct{} {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	return st.updated
+}
+
+// serve writes name out, reporting whether it was there.
+func (st *segmentStore) serve(w http.ResponseWriter, r *http.Request, name string) bool {
+	f, ok := st.get(name)
+	if !ok {
+		return false
+	}
+	w.Header().Set("ETag", f.etag)
+	http.ServeContent(w, r, name, f.modTime, bytes.NewReader(f.data))
+	return true
+}
+
+// serveBytes is http.ServeContent with a strong ETag, so players and CDNs
+// can revalidate instead of refetching.
+func serveBytes(w http.ResponseWriter, r *http.Request, name string, modTime time.Time, data []byte) {
+	w.Header().Set("ETag", etagFor(data))
+	http.ServeContent(w, r, name, modTime, bytes.NewReader(data))
+}
+
+func etagFor(data []byte) string {
+	h := fnv.New64a()
+	_, _ = h.Write(data)
+	return fmt.Sprintf("\"%x-%x\"", len(data), h.Sum64())
+}
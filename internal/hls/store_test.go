@@ -0,0 +1,75 @@
+package hls
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSegmentStore(t *testing.T) {
+	st := newSegmentStore(2)
+	upload := httptest.NewServer(http.HandlerFunc(st.ingest))
+	defer upload.Close()
+
+	put := func(name, body string) {
+		req, _ := http.NewRequest(http.MethodPut, upload.URL+"/"+name, strings.NewReader(body))
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusCreated {
+			t.Fatalf("expected %d but got %d", http.StatusCreated, res.StatusCode)
+		}
+	}
+	get := func(name, etag string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/"+name, nil)
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		rec := httptest.NewRecorder()
+		if !st.serve(rec, req, name) {
+			rec.Code = http.StatusNotFound
+		}
+		return rec
+	}
+
+	put("segments/x/init.mp4", "init")
+	put("segments/x/seg_1.m4s", "one")
+	put("segments/x/seg_2.m4s", "two")
+
+	rec := get("segments/x/seg_1.m4s", "")
+	if rec.Code != http.StatusOK || rec.Body.String() != "one" || rec.Header().Get("Content-Length") != "3" {
+		t.Fatalf("expected segment 1 but got %d %q", rec.Code, rec.Body.String())
+	}
+	etag := rec.Header().Get("ETag")
+	if etag == "" {
+		t.Fatalf("expected an ETag")
+	}
+	if rec := get("segments/x/seg_1.m4s", etag); rec.Code != http.StatusNotModified {
+		t.Fatalf("expected %d but got %d", http.StatusNotModified, rec.Code)
+	}
+
+	// a third segment pushes out the first, the init segment stays.
+	put("segments/x/seg_3.m4s", "three")
+	for name, code := range map[string]int{
+		"segments/x/seg_1.m4s": http.StatusNotFound,
+		"segments/x/seg_3.m4s": http.StatusOK,
+		"segments/x/init.mp4":  http.StatusOK,
+	} {
+		if rec := get(name, ""); rec.Code != code {
+			t.Fatalf("expected %d for %s but got %d", code, name, rec.Code)
+		}
+	}
+
+	req, _ := http.NewRequest(http.MethodDelete, upload.URL+"/segments/x/seg_2.m4s", nil)
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if rec := get("segments/x/seg_2.m4s", ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected segment 2 to be deleted but got %d", rec.Code)
+	}
+}
@@ -9,12 +9,14 @@ import (
 	"net/http"
 	"os"
 	"os/exec"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/philipch07/EggsFM/internal/audio"
+	"github.com/philipch07/EggsFM/internal/dvr"
 	"github.com/philipch07/EggsFM/internal/viewers"
 )
 
@@ -23,17 +25,23 @@ type Config struct {
 	Cursor      *audio.Cursor
 	StationName string
 	StreamPath  string
+	DVRWindow   time.Duration // how far back ?offset= can go, 0 for off
 }
 
 type Streamer struct {
 	ffmpegBin   string
 	stationName string
 	streamPath  string
+	cursor      *audio.Cursor
+	serverStart time.Time
 
 	cmd    *exec.Cmd
 	stdin  *io.PipeWriter
 	sink   *pipeSink
 	output *broadcaster
+	dvr    *dvr.Buffer
+
+	title atomic.Pointer[string] // ICY StreamTitle
 
 	mu        sync.RWMutex
 	startedAt time.Time
@@ -87,8 +95,11 @@ func Start(cfg Config) (*Streamer, error) {
 		ffmpegBin:   ffmpegBin,
 		stationName: stationName,
 		streamPath:  streamPath,
+		cursor:      cfg.Cursor,
+		serverStart: time.Now(),
 		closed:      make(chan struct{}),
 		output:      newBroadcaster(),
+		dvr:         dvr.New(cfg.DVRWindow),
 	}
 	streamer.sink = newPipeSink(streamer)
 
@@ -149,6 +160,12 @@ func (s *Streamer) Handler() http.Handler {
 		w.Header().Set("icy-pub", "1")
 		w.Header().Set("ice-audio-info", fmt.Sprintf("bitrate=%s;channels=%s;samplerate=%s", mp3BitrateKbps, mp3Channels, mp3SampleRate))
 		w.Header().Set("X-Accel-Buffering", "no")
+
+		var out io.Writer = w
+		if strings.TrimSpace(r.Header.Get("Icy-MetaData")) == "1" {
+			w.Header().Set("icy-metaint", strconv.Itoa(icyMetaInt))
+			out = newICYWriter(w, s.streamTitle)
+		}
 		w.WriteHeader(http.StatusOK)
 
 		if r.Method == http.MethodHead {
@@ -160,9 +177,14 @@ func (s *Streamer) Handler() http.Handler {
 
 		flusher, _ := w.(http.Flusher)
 
+		if offset := dvr.Offset(r); offset > 0 && s.dvr != nil {
+			s.serveRewind(r, out, flusher, offset)
+			return
+		}
+
 		seed := s.output.Snapshot()
 		for _, chunk := range seed {
-			if _, err := w.Write(chunk); err != nil {
+			if _, err := out.Write(chunk); err != nil {
 				return
 			}
 			if flusher != nil {
@@ -183,7 +205,7 @@ func (s *Streamer) Handler() http.Handler {
 				if !ok {
 					return
 				}
-				if _, err := w.Write(chunk); err != nil {
+				if _, err := out.Write(chunk); err != nil {
 					return
 				}
 				if flusher != nil {
@@ -194,6 +216,28 @@ func (s *Streamer) Handler() http.Handler {
 	})
 }
 
+// serveRewind plays from offset back, at the listener's pace, instead of
+// joining the live broadcast.
+func (s *Streamer) serveRewind(r *http.Request, out io.Writer, flusher http.Flusher, offset time.Duration) {
+	seq, ok := s.dvr.Seek(offset)
+	if !ok {
+		return
+	}
+	for {
+		chunk, err := s.dvr.Next(r.Context(), seq)
+		if err != nil {
+			return
+		}
+		if _, err := out.Write(chunk.Data); err != nil {
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		seq = chunk.Seq + 1
+	}
+}
+
 // PlaylistHandler serves a simple M3U8 playlist pointing at the stream.
 func (s *Streamer) PlaylistHandler() http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -266,6 +310,7 @@ func (s *Streamer) Close() {
 		if s.output != nil {
 			s.output.Close()
 		}
+		s.dvr.Close()
 	})
 }
 
@@ -695,11 +740,12 @@ func (s *Streamer) pipeOutput(cmd *exec.Cmd, stdout io.ReadCloser) {
 	for {
 		n, err := stdout.Read(buf)
 		if n > 0 {
+			chunk := make([]byte, n)
+			copy(chunk, buf[:n])
 			if s.output != nil {
-				chunk := make([]byte, n)
-				copy(chunk, buf[:n])
 				s.output.Broadcast(chunk)
 			}
+			s.dvr.Append(chunk, nil)
 		}
 		if err != nil {
 			if !errors.Is(err, io.EOF) {
@@ -0,0 +1,109 @@
+package icecast
+
+import (
+	"io"
+	"strings"
+)
+
+// ICY in-stream metadata: clients that send "Icy-MetaData: 1" get a metadata
+// block after every icyMetaInt bytes of audio. The block is a length byte (in
+// 16 byte units) followed by StreamTitle='...'; padded with zeros, or just a
+// zero byte when the title hasn't changed since the last block.
+
+const (
+	icyMetaInt      = 16000
+	icyMaxBlockSize = 255 * 16
+)
+
+// SetStreamTitle changes the title sent to ICY clients.
+func (s *Streamer) SetStreamTitle(title string) {
+	if s == nil {
+		return
+	}
+	s.title.Store(&title)
+}
+
+func (s *Streamer) streamTitle() string {
+	if t := s.title.Load(); t != nil {
+		return *t
+	}
+	return ""
+}
+
+// StreamTitle formats now playing info the way ICY clients expect it.
+func StreamTitle(title string, artists []string) string {
+	title = strings.TrimSpace(title)
+	if title == "-" {
+		title = ""
+	}
+	artist := strings.Join(artists, ", ")
+	switch {
+	case artist == "":
+		return title
+	case title == "":
+		return artist
+	default:
+		return artist + " - " + title
+	}
+}
+
+type icyWriter struct {
+	w         io.Writer
+	title     func() string
+	remaining int
+	sent      string
+	started   bool
+}
+
+func newICYWriter(w io.Writer, title func() string) *icyWriter {
+	return &icyWriter{w: w, title: title, remaining: icyMetaInt}
+}
+
+func (i *icyWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := min(i.remaining, len(p))
+		if _, err := i.w.Write(p[:n]); err != nil {
+			return written, err
+		}
+		written += n
+		p = p[n:]
+		i.remaining -= n
+
+		if i.remaining == 0 {
+			if _, err := i.w.Write(i.metadataBlock()); err != nil {
+				return written, err
+			}
+			i.remaining = icyMetaInt
+		}
+	}
+	return written, nil
+}
+
+func (i *icyWriter) metadataBlock() []byte {
+	title := i.title()
+	if i.started && title == i.sent {
+		return []byte{0}
+	}
+	i.started = true
+	i.sent = title
+
+	return icyBlock(title)
+}
+
+func icyBlock(title string) []byte {
+	// the field ends at "';", so only an apostrophe right before a ';' can cut
+	// it short. a typographic one looks the same on screen.
+	title = strings.ReplaceAll(title, "';", "\u2019;")
+	meta := "StreamTitle='" + title + "';"
+	if len(meta) > icyMaxBlockSize {
+		title = strings.ToValidUTF8(title[:icyMaxBlockSize-len("StreamTitle='';")], "")
+		meta = "StreamTitle='" + title + "';"
+	}
+
+	blocks := (len(meta) + 15) / 16
+	out := make([]byte, 1+blocks*16)
+	out[0] = byte(blocks)
+	copy(out[1:], meta)
+	return out
+}
@@ -0,0 +1,76 @@
+package icecast
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestICYWriter(t *testing.T) {
+	title := "Artist - Song"
+	var buf bytes.Buffer
+	w := newICYWriter(&buf, func() string { return title })
+
+	audio := bytes.Repeat([]byte{0xff}, icyMetaInt*2+10)
+	if n, err := w.Write(audio); err != nil || n != len(audio) {
+		t.Fatalf("expected to write %d bytes but got %d (%v)", len(audio), n, err)
+	}
+
+	out := buf.Bytes()
+	block := icyBlock(title)
+	if !bytes.Equal(out[icyMetaInt:icyMetaInt+len(block)], block) {
+		t.Fatalf("expected a title block after %d bytes but got %q", icyMetaInt, out[icyMetaInt:icyMetaInt+len(block)])
+	}
+
+	// same title again is sent as an empty block.
+	second := icyMetaInt + len(block) + icyMetaInt
+	if out[second] != 0 {
+		t.Fatalf("expected an empty block but got length byte %d", out[second])
+	}
+	if len(out) != len(audio)+len(block)+1 {
+		t.Fatalf("expected %d bytes out but got %d", len(audio)+len(block)+1, len(out))
+	}
+}
+
+func TestStreamTitle(t *testing.T) {
+	tests := []struct {
+		title    string
+		artists  []string
+		expected string
+	}{
+		{"Song", []string{"A", "B"}, "A, B - Song"},
+		{"Song", nil, "Song"},
+		{"-", []string{"A"}, "A"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expected, func(t *testing.T) {
+			if got := StreamTitle(tt.title, tt.artists); got != tt.expected {
+				t.Fatalf("expected %q but got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestIcyBlock(t *testing.T) {
+	tests := []struct {
+		title    string
+		expected string
+	}{
+		{"Artist - Song", "StreamTitle='Artist - Song';"},
+		{"Artist; Other - Song", "StreamTitle='Artist; Other - Song';"},
+		{"Rock 'n' Roll", "StreamTitle='Rock 'n' Roll';"},
+		{"Singin';Dancin'", "StreamTitle='Singin’;Dancin'';"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.title, func(t *testing.T) {
+			block := icyBlock(tt.title)
+			if int(block[0])*16 != len(block)-1 {
+				t.Fatalf("expected %d bytes after the length byte but got %d", int(block[0])*16, len(block)-1)
+			}
+			if got := string(bytes.TrimRight(block[1:], "\x00")); got != tt.expected {
+				t.Fatalf("expected %q but got %q", tt.expected, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package icecast
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/philipch07/EggsFM/internal/viewers"
+)
+
+type testFeed struct {
+	ch chan []byte
+}
+
+func (f *testFeed) Subscribe() (<-chan []byte, []byte, func()) {
+	return f.ch, []byte("head"), func() {}
+}
+
+// standInServer plays the remote Icecast: it takes a PUT source and the
+// admin/metadata call.
+type standInServer struct {
+	mu       sync.Mutex
+	auth     string
+	mount    string
+	received bytes.Buffer
+	song     string
+}
+
+func (s *standInServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == http.MethodPut:
+		user, pass, _ := r.BasicAuth()
+		s.mu.Lock()
+		s.auth = user + ":" + pass
+		s.mount = r.URL.Path
+		s.mu.Unlock()
+
+		conn, rw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close() //nolint
+		_, _ = io.WriteString(conn, "HTTP/1.1 100 Continue\r\n\r\n")
+
+		buf := make([]byte, 1024)
+		for {
+			n, err := rw.Read(buf)
+			s.mu.Lock()
+			s.received.Write(buf[:n])
+			s.mu.Unlock()
+			if err != nil {
+				return
+			}
+		}
+	case r.URL.Path == "/admin/metadata":
+		s.mu.Lock()
+		s.song = r.URL.Query().Get("song")
+		s.mu.Unlock()
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (s *standInServer) snapshot() (string, string, string, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.auth, s.mount, s.received.String(), s.song
+}
+
+func TestRelayPushesToIcecast(t *testing.T) {
+	remote := &standInServer{}
+	server := httptest.NewServer(remote)
+	defer server.Close()
+
+	targets, err := ParseRelayTargets("icecast://source:hackme@" + strings.TrimPrefix(server.URL, "http://") + "/eggsfm.mp3")
+	if err != nil || len(targets) != 1 {
+		t.Fatalf("expected one target but got %d (%v)", len(targets), err)
+	}
+
+	feed := &testFeed{ch: make(chan []byte, 4)}
+	relay := StartRelay(targets[0], feed, "EggsFM")
+	defer relay.Close()
+
+	feed.ch <- []byte("audio")
+	relay.SetStreamTitle("Artist - Song")
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		auth, mount, received, song := remote.snapshot()
+		if received == "headaudio" && song == "Artist - Song" {
+			if auth != "source:hackme" {
+				t.Fatalf("expected source:hackme but got %q", auth)
+			}
+			if mount != "/eggsfm.mp3" {
+				t.Fatalf("expected /eggsfm.mp3 but got %q", mount)
+			}
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	_, _, received, song := remote.snapshot()
+	t.Fatalf("expected headaudio and a title but got %q and %q", received, song)
+}
+
+func TestParseRelayTargets(t *testing.T) {
+	tests := []struct {
+		raw      string
+		expected string
+		opus     bool
+		fails    bool
+	}{
+		{"icecast://source:pw@example.com:8000/live.mp3", "icecast://example.com:8000/live.mp3", false, false},
+		{"icecasts://:pw@example.com/live.opus?format=opus", "icecast://example.com:8000/live.opus", true, false},
+		{"shoutcast://:pw@example.com:8000/?sid=1", "shoutcast://example.com:8000", false, false},
+		{"shoutcast://:pw@example.com:8000/?format=opus", "", false, true},
+		{"icecast://source@example.com:8000/live.mp3", "", false, true},
+		{"icecast://source:pw@example.com:8000/", "", false, true},
+		{"rtmp://example.com/live", "", false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			targets, err := ParseRelayTargets(tt.raw)
+			if tt.fails {
+				if err == nil {
+					t.Fatalf("expected an error but got %v", targets)
+				}
+				return
+			}
+			if err != nil || len(targets) != 1 {
+				t.Fatalf("expected one target but got %d (%v)", len(targets), err)
+			}
+			if got := targets[0].String(); got != tt.expected {
+				t.Fatalf("expected %q but got %q", tt.expected, got)
+			}
+			if targets[0].Opus != tt.opus {
+				t.Fatalf("expected opus=%v but got %v", tt.opus, targets[0].Opus)
+			}
+		})
+	}
+}
+
+func TestRelayIsNotAListener(t *testing.T) {
+	s := &Streamer{output: newBroadcaster()}
+	before, peakBefore := viewers.Counts().Icecast, viewers.Peaks().Icecast
+
+	ch, header, cancel := s.Subscribe()
+	if header != nil {
+		t.Fatalf("expected no header for mp3 but got %q", header)
+	}
+	s.output.Broadcast([]byte("mp3"))
+	if got := <-ch; string(got) != "mp3" {
+		t.Fatalf("expected the relay to get the stream but got %q", got)
+	}
+
+	if now, peak := viewers.Counts().Icecast, viewers.Peaks().Icecast; now != before || peak != peakBefore {
+		t.Fatalf("expected %d listeners (peak %d) but got %d (peak %d)", before, peakBefore, now, peak)
+	}
+	cancel()
+}
@@ -0,0 +1,252 @@
+package icecast
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/philipch07/EggsFM/internal/viewers"
+)
+
+// Icecast compatible status endpoints (/status-json.xsl and /admin/stats), so
+// directory sites and stream monitors that speak Icecast work unchanged.
+
+const (
+	serverID = "Icecast 2.4.4 (EggsFM)"
+
+	// strftime "%a, %d %b %Y %H:%M:%S %z" and "%Y-%m-%dT%H:%M:%S%z", as Icecast prints them.
+	icecastTimeFormat = "Mon, 02 Jan 2006 15:04:05 -0700"
+	iso8601Format     = "2006-01-02T15:04:05-0700"
+)
+
+// Source is one mount as reported by the status endpoints.
+type Source struct {
+	Mount        string
+	ServerType   string
+	Title        string
+	Artist       string
+	Bitrate      int // kbps, 0 when it varies
+	Channels     int
+	SampleRate   int
+	Listeners    int
+	ListenerPeak int
+	StreamStart  time.Time
+}
+
+// Status is everything the status endpoints report.
+type Status struct {
+	StationName string
+	ServerStart time.Time
+	Sources     []Source
+}
+
+// Source describes the MP3 mount.
+func (s *Streamer) Source() Source {
+	src := Source{
+		Mount:      s.streamPath,
+		ServerType: "audio/mpeg",
+		Title:      s.streamTitle(),
+		Channels:   atoi(mp3Channels),
+		SampleRate: atoi(mp3SampleRate),
+		Bitrate:    atoi(strings.TrimSuffix(mp3Bitrate, "k")),
+	}
+	src.Listeners = viewers.Counts().Icecast
+	src.ListenerPeak = viewers.Peaks().Icecast
+	if s.cursor != nil {
+		src.StreamStart = s.cursor.StartedAt()
+	}
+	return src
+}
+
+// ServerStart is when this streamer came up.
+func (s *Streamer) ServerStart() time.Time {
+	return s.serverStart
+}
+
+func atoi(v string) int {
+	n, _ := strconv.Atoi(v)
+	return n
+}
+
+type jsonStats struct {
+	Icestats jsonIcestats `json:"icestats"`
+}
+
+type jsonIcestats struct {
+	Host               string       `json:"host"`
+	ServerID           string       `json:"server_id"`
+	ServerStart        string       `json:"server_start"`
+	ServerStartISO8601 string       `json:"server_start_iso8601"`
+	Source             []jsonSource `json:"source"`
+}
+
+type jsonSource struct {
+	AudioInfo          string `json:"audio_info"`
+	Bitrate            int    `json:"bitrate,omitempty"`
+	Channels           int    `json:"channels"`
+	SampleRate         int    `json:"samplerate"`
+	Genre              string `json:"genre"`
+	ListenerPeak       int    `json:"listener_peak"`
+	Listeners          int    `json:"listeners"`
+	ListenURL          string `json:"listenurl"`
+	ServerDescription  string `json:"server_description"`
+	ServerName         string `json:"server_name"`
+	ServerType         string `json:"server_type"`
+	ServerURL          string `json:"server_url"`
+	StreamStart        string `json:"stream_start"`
+	StreamStartISO8601 string `json:"stream_start_iso8601"`
+	Title              string `json:"title,omitempty"`
+	Artist             string `json:"artist,omitempty"`
+	Dummy              any    `json:"dummy"`
+}
+
+type xmlStats struct {
+	XMLName            xml.Name    `xml:"icestats"`
+	Host               string      `xml:"host"`
+	Listeners          int         `xml:"listeners"`
+	ServerID           string      `xml:"server_id"`
+	ServerStart        string      `xml:"server_start"`
+	ServerStartISO8601 string      `xml:"server_start_iso8601"`
+	Sources            int         `xml:"sources"`
+	Source             []xmlSource `xml:"source"`
+}
+
+type xmlSource struct {
+	Mount              string `xml:"mount,attr"`
+	AudioInfo          string `xml:"audio_info"`
+	Bitrate            int    `xml:"bitrate,omitempty"`
+	Channels           int    `xml:"channels"`
+	Genre              string `xml:"genre"`
+	ListenerPeak       int    `xml:"listener_peak"`
+	Listeners          int    `xml:"listeners"`
+	ListenURL          string `xml:"listenurl"`
+	MaxListeners       string `xml:"max_listeners"`
+	Public             int    `xml:"public"`
+	SampleRate         int    `xml:"samplerate"`
+	ServerDescription  string `xml:"server_description"`
+	ServerName         string `xml:"server_name"`
+	ServerType         string `xml:"server_type"`
+	ServerURL          string `xml:"server_url"`
+	SlowListeners      int    `xml:"slow_listeners"`
+	StreamStart        string `xml:"stream_start"`
+	StreamStartISO8601 string `xml:"stream_start_iso8601"`
+	Title              string `xml:"title,omitempty"`
+	Artist             string `xml:"artist,omitempty"`
+}
+
+func audioInfo(src Source) string {
+	parts := make([]string, 0, 3)
+	if src.Bitrate > 0 {
+		parts = append(parts, fmt.Sprintf("bitrate=%d", src.Bitrate))
+	}
+	parts = append(parts,
+		fmt.Sprintf("channels=%d", src.Channels),
+		fmt.Sprintf("samplerate=%d", src.SampleRate),
+	)
+	return strings.Join(parts, ";")
+}
+
+func formatTimes(t time.Time) (string, string) {
+	if t.IsZero() {
+		return "", ""
+	}
+	return t.Format(icecastTimeFormat), t.Format(iso8601Format)
+}
+
+// StatusJSONHandler serves Icecast's /status-json.xsl.
+func StatusJSONHandler(status func() Status) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		st := status()
+		serverURL := resolveStreamURL(r, "/")
+
+		out := jsonStats{Icestats: jsonIcestats{
+			Host:     r.Host,
+			ServerID: serverID,
+			Source:   make([]jsonSource, 0, len(st.Sources)),
+		}}
+		out.Icestats.ServerStart, out.Icestats.ServerStartISO8601 = formatTimes(st.ServerStart)
+
+		for _, src := range st.Sources {
+			js := jsonSource{
+				AudioInfo:         audioInfo(src),
+				Bitrate:           src.Bitrate,
+				Channels:          src.Channels,
+				SampleRate:        src.SampleRate,
+				Genre:             "various",
+				ListenerPeak:      src.ListenerPeak,
+				Listeners:         src.Listeners,
+				ListenURL:         resolveStreamURL(r, src.Mount),
+				ServerDescription: st.StationName,
+				ServerName:        st.StationName,
+				ServerType:        src.ServerType,
+				ServerURL:         serverURL,
+				Title:             src.Title,
+				Artist:            src.Artist,
+			}
+			js.StreamStart, js.StreamStartISO8601 = formatTimes(src.StreamStart)
+			out.Icestats.Source = append(out.Icestats.Source, js)
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Header().Set("Cache-Control", playlistCacheControl)
+		if err := json.NewEncoder(w).Encode(out); err != nil {
+			log.Printf("icecast status write error: %v", err)
+		}
+	})
+}
+
+// StatsXMLHandler serves Icecast's /admin/stats. Auth is up to the caller.
+func StatsXMLHandler(status func() Status) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		st := status()
+		serverURL := resolveStreamURL(r, "/")
+
+		out := xmlStats{
+			Host:     r.Host,
+			ServerID: serverID,
+			Sources:  len(st.Sources),
+		}
+		out.ServerStart, out.ServerStartISO8601 = formatTimes(st.ServerStart)
+
+		for _, src := range st.Sources {
+			xs := xmlSource{
+				Mount:             src.Mount,
+				AudioInfo:         audioInfo(src),
+				Bitrate:           src.Bitrate,
+				Channels:          src.Channels,
+				Genre:             "various",
+				ListenerPeak:      src.ListenerPeak,
+				Listeners:         src.Listeners,
+				ListenURL:         resolveStreamURL(r, src.Mount),
+				MaxListeners:      "unlimited",
+				Public:            1,
+				SampleRate:        src.SampleRate,
+				ServerDescription: st.StationName,
+				ServerName:        st.StationName,
+				ServerType:        src.ServerType,
+				ServerURL:         serverURL,
+				Title:             src.Title,
+				Artist:            src.Artist,
+			}
+			xs.StreamStart, xs.StreamStartISO8601 = formatTimes(src.StreamStart)
+			out.Listeners += src.Listeners
+			out.Source = append(out.Source, xs)
+		}
+
+		w.Header().Set("Content-Type", "text/xml; charset=utf-8")
+		w.Header().Set("Cache-Control", playlistCacheControl)
+		if _, err := w.Write([]byte(xml.Header)); err != nil {
+			return
+		}
+		enc := xml.NewEncoder(w)
+		enc.Indent("", "  ")
+		if err := enc.Encode(out); err != nil {
+			log.Printf("icecast stats write error: %v", err)
+		}
+	})
+}
@@ -0,0 +1,135 @@
+package icecast
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestStatusHandlers(t *testing.T) {
+	started := time.Date(2026, 3, 1, 12, 30, 0, 0, time.UTC)
+	mp3 := Source{
+		Mount:        "/api/icecast.mp3",
+		ServerType:   "audio/mpeg",
+		Title:        "Song",
+		Artist:       "Artist",
+		Bitrate:      128,
+		Channels:     2,
+		SampleRate:   44100,
+		Listeners:    3,
+		ListenerPeak: 5,
+		StreamStart:  started,
+	}
+	opus := Source{
+		Mount:       "/api/stream.opus",
+		ServerType:  "application/ogg",
+		Channels:    2,
+		SampleRate:  48000,
+		Listeners:   1,
+		StreamStart: started,
+	}
+
+	tests := []struct {
+		name      string
+		sources   []Source
+		listeners int
+		audioInfo []string
+	}{
+		{
+			name:      "No Sources",
+			sources:   nil,
+			listeners: 0,
+		},
+		{
+			name:      "MP3",
+			sources:   []Source{mp3},
+			listeners: 3,
+			audioInfo: []string{"bitrate=128;channels=2;samplerate=44100"},
+		},
+		{
+			name:      "MP3 And Opus",
+			sources:   []Source{mp3, opus},
+			listeners: 4,
+			audioInfo: []string{"bitrate=128;channels=2;samplerate=44100", "channels=2;samplerate=48000"},
+		},
+	}
+
+	for _, tc := range tests {
+		status := func() Status {
+			return Status{StationName: "EggsFM", ServerStart: started, Sources: tc.sources}
+		}
+
+		t.Run(tc.name+" JSON", func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			StatusJSONHandler(status).ServeHTTP(rec, httptest.NewRequest("GET", "http://radio.test/status-json.xsl", nil))
+
+			if ct := rec.Header().Get("Content-Type"); ct != "application/json; charset=utf-8" {
+				t.Fatalf("expected a json content type but got %q", ct)
+			}
+			var out jsonStats
+			if err := json.Unmarshal(rec.Body.Bytes(), &out); err != nil {
+				t.Fatalf("expected valid json but got %v", err)
+			}
+			if out.Icestats.ServerID != serverID || out.Icestats.Host != "radio.test" {
+				t.Fatalf("expected server id %q on radio.test but got %q on %q", serverID, out.Icestats.ServerID, out.Icestats.Host)
+			}
+			if out.Icestats.ServerStartISO8601 != "2026-03-01T12:30:00+0000" {
+				t.Fatalf("expected the server start in iso8601 but got %q", out.Icestats.ServerStartISO8601)
+			}
+			if out.Icestats.Source == nil || len(out.Icestats.Source) != len(tc.sources) {
+				t.Fatalf("expected %d sources but got %v", len(tc.sources), out.Icestats.Source)
+			}
+			for i, src := range out.Icestats.Source {
+				want := tc.sources[i]
+				if src.Listeners != want.Listeners || src.ListenerPeak != want.ListenerPeak {
+					t.Fatalf("expected %d listeners (peak %d) but got %d (peak %d)", want.Listeners, want.ListenerPeak, src.Listeners, src.ListenerPeak)
+				}
+				if src.AudioInfo != tc.audioInfo[i] {
+					t.Fatalf("expected audio info %q but got %q", tc.audioInfo[i], src.AudioInfo)
+				}
+				if src.ListenURL != "http://radio.test"+want.Mount {
+					t.Fatalf("expected listen url for %s but got %q", want.Mount, src.ListenURL)
+				}
+				if src.Title != want.Title || src.Artist != want.Artist || src.ServerName != "EggsFM" {
+					t.Fatalf("expected %q by %q on EggsFM but got %q by %q on %q", want.Title, want.Artist, src.Title, src.Artist, src.ServerName)
+				}
+			}
+		})
+
+		t.Run(tc.name+" XML", func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			StatsXMLHandler(status).ServeHTTP(rec, httptest.NewRequest("GET", "http://radio.test/admin/stats", nil))
+
+			if ct := rec.Header().Get("Content-Type"); ct != "text/xml; charset=utf-8" {
+				t.Fatalf("expected an xml content type but got %q", ct)
+			}
+			var out xmlStats
+			if err := xml.Unmarshal(rec.Body.Bytes(), &out); err != nil {
+				t.Fatalf("expected valid xml but got %v", err)
+			}
+			if out.Sources != len(tc.sources) || len(out.Source) != len(tc.sources) {
+				t.Fatalf("expected %d sources but got %d (%d listed)", len(tc.sources), out.Sources, len(out.Source))
+			}
+			if out.Listeners != tc.listeners {
+				t.Fatalf("expected %d listeners in total but got %d", tc.listeners, out.Listeners)
+			}
+			for i, src := range out.Source {
+				want := tc.sources[i]
+				if src.Mount != want.Mount {
+					t.Fatalf("expected mount %q but got %q", want.Mount, src.Mount)
+				}
+				if src.Listeners != want.Listeners || src.ListenerPeak != want.ListenerPeak {
+					t.Fatalf("expected %d listeners (peak %d) but got %d (peak %d)", want.Listeners, want.ListenerPeak, src.Listeners, src.ListenerPeak)
+				}
+				if src.AudioInfo != tc.audioInfo[i] || src.Bitrate != want.Bitrate {
+					t.Fatalf("expected audio info %q but got %q", tc.audioInfo[i], src.AudioInfo)
+				}
+				if src.StreamStart != "Sun, 01 Mar 2026 12:30:00 +0000" {
+					t.Fatalf("expected the stream start in icecast's format but got %q", src.StreamStart)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,444 @@
+package library
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/philipch07/EggsFM/internal/audio"
+)
+
+const indexVersion = 1
+
+// Track is one indexed media file. Size/ModTime are used to decide whether the
+// cached tags + duration are still valid for the file on disk.
+// Non-Opus sources also carry their content Hash and the transcoded File to play.
+type Track struct {
+	Path     string        `json:"path"`
+	Size     int64         `json:"size"`
+	ModTime  time.Time     `json:"modTime"`
+	Title    string        `json:"title"`
+	Artists  []string      `json:"artists"`
+	Duration time.Duration `json:"duration"`
+	Hash     string        `json:"hash,omitempty"`
+	File     string        `json:"file,omitempty"`
+}
+
+// PlayPath is the Ogg Opus file to actually stream for the track.
+func (t Track) PlayPath() string {
+	if t.File != "" {
+		return t.File
+	}
+	return t.Path
+}
+
+type indexFile struct {
+	Version int     `json:"version"`
+	Tracks  []Track `json:"tracks"`
+}
+
+// ErrTranscoding is returned for media that's being converted to Opus in the
+// background. It's in the index once that's done (see OnTranscoded).
+var ErrTranscoding = errors.New("waiting to be transcoded")
+
+// Index is a persistent cache of track metadata keyed by path.
+// Files are only re-read when their size or mtime changes.
+type Index struct {
+	path       string
+	saveMu     sync.Mutex // serializes writers of the tmp file
+	transcoder *Transcoder
+
+	mu     sync.Mutex
+	tracks map[string]Track
+	dirty  bool
+
+	// background transcodes, one at a time.
+	pending      map[string]struct{}
+	queue        []string
+	queued       *sync.Cond
+	transcoded   chan struct{} // closed and replaced whenever one finishes
+	onTranscoded func(Track)
+	workerOnce   sync.Once
+}
+
+// OpenIndex loads the index stored at path. A missing or unreadable index is
+// not an error; it just means every file gets read on the first scan.
+// An empty path keeps the index in memory only.
+func OpenIndex(path string) *Index {
+	idx := &Index{
+		path:       path,
+		tracks:     map[string]Track{},
+		pending:    map[string]struct{}{},
+		transcoded: make(chan struct{}),
+	}
+	idx.queued = sync.NewCond(&idx.mu)
+	if path == "" {
+		return idx
+	}
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Printf("library: unable to read index %q: %v", path, err)
+		}
+		return idx
+	}
+
+	var stored indexFile
+	if err := json.Unmarshal(raw, &stored); err != nil {
+		log.Printf("library: ignoring corrupt index %q: %v", path, err)
+		return idx
+	}
+	if stored.Version != indexVersion {
+		log.Printf("library: ignoring index %q with version %d", path, stored.Version)
+		return idx
+	}
+
+	for _, t := range stored.Tracks {
+		idx.tracks[t.Path] = t
+	}
+
+	return idx
+}
+
+// SetTranscoder lets the index pick up non-Opus media (see Transcoder).
+// Without one only .opus files are indexed.
+func (x *Index) SetTranscoder(t *Transcoder) {
+	x.mu.Lock()
+	x.transcoder = t
+	x.mu.Unlock()
+}
+
+// OnTranscoded registers fn to be called (from the transcode goroutine) with
+// every track that finished transcoding and is now in the index.
+func (x *Index) OnTranscoded(fn func(Track)) {
+	x.mu.Lock()
+	x.onTranscoded = fn
+	x.mu.Unlock()
+}
+
+// Pending returns how many files are waiting to be transcoded.
+func (x *Index) Pending() int {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	return len(x.pending)
+}
+
+// Transcoded is closed the next time a transcode finishes, done or failed.
+func (x *Index) Transcoded() <-chan struct{} {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	return x.transcoded
+}
+
+// Accepts reports whether path is media the index can turn into a playable track.
+func (x *Index) Accepts(path string) bool {
+	if IsOpus(path) {
+		return true
+	}
+
+	x.mu.Lock()
+	tc := x.transcoder
+	x.mu.Unlock()
+
+	return tc != nil && IsTranscodable(path)
+}
+
+// Scan walks root recursively and returns every playable file sorted by path.
+// Entries under root that no longer exist are dropped from the index.
+func (x *Index) Scan(root string) ([]Track, error) {
+	var paths []string
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == root {
+				return err
+			}
+			log.Printf("library: skipping %q: %v", path, err)
+			if d != nil && d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		// skip dotfiles/dotdirs (this is also where the index tends to live)
+		if path != root && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if d.IsDir() {
+			return nil
+		}
+		if x.Accepts(d.Name()) {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(paths)
+
+	out := make([]Track, 0, len(paths))
+	seen := make(map[string]struct{}, len(paths))
+	waiting := 0
+	for _, p := range paths {
+		t, err := x.Lookup(p)
+		if errors.Is(err, ErrTranscoding) {
+			waiting++
+			continue
+		}
+		if err != nil {
+			log.Printf("library: skipping %q: %v", p, err)
+			continue
+		}
+		seen[t.Path] = struct{}{}
+		out = append(out, t)
+	}
+
+	x.pruneUnder(root, seen)
+	if waiting > 0 {
+		log.Printf("library: %d file(s) under %q are waiting to be transcoded", waiting, root)
+	}
+
+	return out, nil
+}
+
+// Lookup returns the metadata for a single file, reading it only if the
+// cached entry is missing or stale.
+func (x *Index) Lookup(path string) (Track, error) {
+	path = filepath.Clean(path)
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return Track{}, err
+	}
+	if info.IsDir() {
+		return Track{}, fmt.Errorf("%q is a directory", path)
+	}
+
+	x.mu.Lock()
+	cached, ok := x.tracks[path]
+	tc := x.transcoder
+	x.mu.Unlock()
+
+	if ok && cached.Size == info.Size() && cached.ModTime.Equal(info.ModTime()) && cachedFileExists(cached) {
+		return cached, nil
+	}
+
+	// hashing and transcoding can take a long time on a big library, so it
+	// happens in the background instead of holding up the caller.
+	if !IsOpus(path) && tc != nil && IsTranscodable(path) {
+		x.enqueue(path)
+		return Track{}, ErrTranscoding
+	}
+
+	t, err := readTrack(path, info, tc)
+	if err != nil {
+		return Track{}, err
+	}
+
+	x.mu.Lock()
+	x.tracks[path] = t
+	x.dirty = true
+	x.mu.Unlock()
+
+	return t, nil
+}
+
+// Tracks returns every indexed track sorted by path.
+func (x *Index) Tracks() []Track {
+	x.mu.Lock()
+	out := make([]Track, 0, len(x.tracks))
+	for _, t := range x.tracks {
+		out = append(out, t)
+	}
+	x.mu.Unlock()
+
+	sort.Slice(out, func(i, j int) bool { return out[i].Path < out[j].Path })
+	return out
+}
+
+func (x *Index) enqueue(path string) {
+	x.workerOnce.Do(func() { go x.transcodeLoop() })
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if _, ok := x.pending[path]; ok {
+		return
+	}
+	x.pending[path] = struct{}{}
+	x.queue = append(x.queue, path)
+	x.queued.Signal()
+}
+
+func (x *Index) transcodeLoop() {
+	for {
+		x.mu.Lock()
+		for len(x.queue) == 0 {
+			x.queued.Wait()
+		}
+		path := x.queue[0]
+		x.queue = x.queue[1:]
+		tc := x.transcoder
+		x.mu.Unlock()
+
+		var t Track
+		info, err := os.Stat(path)
+		if err == nil {
+			t, err = readTrack(path, info, tc)
+		}
+
+		x.mu.Lock()
+		delete(x.pending, path)
+		if err == nil {
+			x.tracks[path] = t
+			x.dirty = true
+		}
+		close(x.transcoded)
+		x.transcoded = make(chan struct{})
+		fn := x.onTranscoded
+		x.mu.Unlock()
+
+		if err != nil {
+			log.Printf("library: skipping %q: %v", path, err)
+			continue
+		}
+		if fn != nil {
+			fn(t)
+		}
+	}
+}
+
+// Save writes the index back to disk if anything changed since it was loaded.
+func (x *Index) Save() error {
+	x.saveMu.Lock()
+	defer x.saveMu.Unlock()
+
+	x.mu.Lock()
+	if !x.dirty || x.path == "" {
+		x.mu.Unlock()
+		return nil
+	}
+	stored := indexFile{
+		Version: indexVersion,
+		Tracks:  make([]Track, 0, len(x.tracks)),
+	}
+	for _, t := range x.tracks {
+		stored.Tracks = append(stored.Tracks, t)
+	}
+	x.dirty = false
+	x.mu.Unlock()
+
+	sort.Slice(stored.Tracks, func(i, j int) bool {
+		return stored.Tracks[i].Path < stored.Tracks[j].Path
+	})
+
+	raw, err := json.Marshal(stored)
+	if err != nil {
+		return err
+	}
+
+	if dir := filepath.Dir(x.path); dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return fmt.Errorf("create index dir: %w", err)
+		}
+	}
+
+	// write + rename so a crash mid-write never leaves a truncated index behind
+	tmp := x.path + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0o644); err != nil {
+		return fmt.Errorf("write index: %w", err)
+	}
+	if err := os.Rename(tmp, x.path); err != nil {
+		return fmt.Errorf("replace index: %w", err)
+	}
+
+	return nil
+}
+
+func (x *Index) pruneUnder(root string, keep map[string]struct{}) {
+	prefix := filepath.Clean(root) + string(filepath.Separator)
+
+	x.mu.Lock()
+	for p := range x.tracks {
+		if !strings.HasPrefix(p, prefix) {
+			continue
+		}
+		if _, ok := keep[p]; ok {
+			continue
+		}
+		delete(x.tracks, p)
+		x.dirty = true
+	}
+	x.mu.Unlock()
+}
+
+// cachedFileExists catches a transcode cache that was wiped behind our back.
+func cachedFileExists(t Track) bool {
+	if t.File == "" {
+		return true
+	}
+	_, err := os.Stat(t.File)
+	return err == nil
+}
+
+func readTrack(path string, info os.FileInfo, tc *Transcoder) (Track, error) {
+	// the source changed (or is new), so its content is hashed again; an
+	// unchanged hash still finds the existing transcode.
+	var hash, file string
+	if !IsOpus(path) {
+		if tc == nil || !IsTranscodable(path) {
+			return Track{}, errNotPlayable
+		}
+		var err error
+		if file, hash, err = tc.Opus(path, ""); err != nil {
+			return Track{}, err
+		}
+	}
+
+	play := path
+	if file != "" {
+		play = file
+	}
+
+	f, err := os.Open(play)
+	if err != nil {
+		return Track{}, err
+	}
+	defer func() { _ = f.Close() }()
+
+	dur, err := audio.OpusDuration(f)
+	if err != nil {
+		// still playable in most cases, we just don't know how long it is.
+		log.Printf("library: unable to read duration of %q: %v", path, err)
+		dur = 0
+	}
+
+	title, artists := ReadOpusTags(play)
+	if title == "" {
+		title = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+
+	return Track{
+		Path:     path,
+		Size:     info.Size(),
+		ModTime:  info.ModTime(),
+		Title:    title,
+		Artists:  artists,
+		Duration: dur,
+		Hash:     hash,
+		File:     file,
+	}, nil
+}
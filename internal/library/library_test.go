@@ -0,0 +1,203 @@
+package library
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var crcTable = func() (t [256]uint32) {
+	for i := range t {
+		r := uint32(i) << 24
+		for range 8 {
+			if r&0x80000000 != 0 {
+				r = r<<1 ^ 0x04c11db7
+			} else {
+				r <<= 1
+			}
+		}
+		t[i] = r
+	}
+	return t
+}()
+
+func testPage(granule uint64, seq uint32, packet []byte) []byte {
+	var lacing []byte
+	n := len(packet)
+	for ; n >= 255; n -= 255 {
+		lacing = append(lacing, 255)
+	}
+	lacing = append(lacing, byte(n))
+
+	page := append([]byte("OggS"), make([]byte, 22)...)
+	binary.LittleEndian.PutUint64(page[6:], granule)
+	binary.LittleEndian.PutUint32(page[18:], seq)
+	page = append(page, byte(len(lacing)))
+	page = append(page, lacing...)
+	page = append(page, packet...)
+
+	var crc uint32
+	for _, v := range page {
+		crc = crc<<8 ^ crcTable[byte(crc>>24)^v]
+	}
+	binary.LittleEndian.PutUint32(page[22:], crc)
+	return page
+}
+
+// writeOpus writes a tiny Ogg Opus file of the given length with no pre-skip.
+func writeOpus(t *testing.T, path string, length time.Duration, comments ...string) {
+	t.Helper()
+
+	head := []byte{'O', 'p', 'u', 's', 'H', 'e', 'a', 'd', 1, 2, 0, 0, 0x80, 0xbb, 0, 0, 0, 0, 0}
+	tags := []byte("OpusTags")
+	tags = binary.LittleEndian.AppendUint32(tags, 4)
+	tags = append(tags, "test"...)
+	tags = binary.LittleEndian.AppendUint32(tags, uint32(len(comments)))
+	for _, c := range comments {
+		tags = binary.LittleEndian.AppendUint32(tags, uint32(len(c)))
+		tags = append(tags, c...)
+	}
+
+	raw := testPage(0, 0, head)
+	raw = append(raw, testPage(0, 1, tags)...)
+	raw = append(raw, testPage(uint64(length/time.Millisecond)*48, 2, make([]byte, 50))...)
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, raw, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestScan(t *testing.T) {
+	root := t.TempDir()
+	writeOpus(t, filepath.Join(root, "b.opus"), 2*time.Second, "TITLE=Song B", "ARTIST=A & B")
+	writeOpus(t, filepath.Join(root, "sub", "a.opus"), 3*time.Second)
+	writeOpus(t, filepath.Join(root, ".hidden", "c.opus"), time.Second)
+	if err := os.WriteFile(filepath.Join(root, "notes.txt"), []byte("hi"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// not transcodable without a transcoder.
+	if err := os.WriteFile(filepath.Join(root, "d.mp3"), []byte("hi"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	idx := OpenIndex(filepath.Join(root, ".index.json"))
+	tracks, err := idx.Scan(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type got struct {
+		Path     string
+		Title    string
+		Artists  []string
+		Duration time.Duration
+	}
+	var gotTracks []got
+	for _, tr := range tracks {
+		gotTracks = append(gotTracks, got{tr.Path, tr.Title, tr.Artists, tr.Duration})
+	}
+	expected := []got{
+		{filepath.Join(root, "b.opus"), "Song B", []string{"A", "B"}, 2 * time.Second},
+		{filepath.Join(root, "sub", "a.opus"), "a", []string{}, 3 * time.Second},
+	}
+	if !reflect.DeepEqual(gotTracks, expected) {
+		t.Fatalf("expected %+v but got %+v", expected, gotTracks)
+	}
+
+	// a removed file drops out of the index on the next scan.
+	if err := os.Remove(filepath.Join(root, "b.opus")); err != nil {
+		t.Fatal(err)
+	}
+	if tracks, err = idx.Scan(root); err != nil || len(tracks) != 1 {
+		t.Fatalf("expected 1 track but got %d (%v)", len(tracks), err)
+	}
+	idx.mu.Lock()
+	_, stale := idx.tracks[filepath.Join(root, "b.opus")]
+	idx.mu.Unlock()
+	if stale {
+		t.Fatalf("expected the removed track to be pruned")
+	}
+}
+
+func TestLookup(t *testing.T) {
+	root := t.TempDir()
+	path := filepath.Join(root, "a.opus")
+	writeOpus(t, path, time.Second, "TITLE=One")
+
+	idx := OpenIndex("")
+	first, err := idx.Lookup(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// an unchanged file isn't read again.
+	idx.mu.Lock()
+	cached := idx.tracks[path]
+	cached.Title = "From Cache"
+	idx.tracks[path] = cached
+	idx.mu.Unlock()
+	if got, _ := idx.Lookup(path); got.Title != "From Cache" {
+		t.Fatalf("expected the cached entry but got %q", got.Title)
+	}
+
+	// a changed one is.
+	writeOpus(t, path, 2*time.Second, "TITLE=Two")
+	later := first.ModTime.Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	got, err := idx.Lookup(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Title != "Two" || got.Duration != 2*time.Second {
+		t.Fatalf("expected Two (2s) but got %q (%s)", got.Title, got.Duration)
+	}
+
+	if _, err := idx.Lookup(filepath.Join(root, "missing.opus")); err == nil {
+		t.Fatalf("expected an error for a missing file")
+	}
+	if _, err := idx.Lookup(root); err == nil {
+		t.Fatalf("expected an error for a directory")
+	}
+}
+
+func TestSave(t *testing.T) {
+	root := t.TempDir()
+	indexPath := filepath.Join(root, "state", "index.json")
+	path := filepath.Join(root, "a.opus")
+	writeOpus(t, path, time.Second, "TITLE=Saved")
+
+	idx := OpenIndex(indexPath)
+	if _, err := idx.Lookup(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if idx.dirty {
+		t.Fatalf("expected a clean index after saving")
+	}
+
+	reopened := OpenIndex(indexPath)
+	got, ok := reopened.tracks[path]
+	if !ok || got.Title != "Saved" || got.Duration != time.Second {
+		t.Fatalf("expected the saved track but got %+v", got)
+	}
+
+	// a corrupt or outdated index is ignored.
+	for _, raw := range []string{"{not json", `{"version":99,"tracks":[{"path":"x"}]}`} {
+		if err := os.WriteFile(indexPath, []byte(raw), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if n := len(OpenIndex(indexPath).tracks); n != 0 {
+			t.Fatalf("expected an empty index from %q but got %d tracks", raw, n)
+		}
+	}
+}
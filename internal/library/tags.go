@@ -0,0 +1,109 @@
+package library
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/pion/webrtc/v4/pkg/media/oggreader"
+)
+
+// ReadOpusTags returns a best-effort Title/Artist list from the file's OpusTags.
+// Missing tags are not an error; the title is simply empty.
+func ReadOpusTags(path string) (title string, artists []string) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", []string{}
+	}
+	defer func() { _ = f.Close() }()
+
+	r, err := oggreader.NewWithOptions(f, oggreader.WithDoChecksum(false))
+	if err != nil {
+		return "", []string{}
+	}
+
+	var artistVals []string
+
+	for {
+		payload, pageHeader, err := r.ParseNextPage()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil || pageHeader == nil || len(payload) < 8 {
+			break
+		}
+
+		ht, ok := pageHeader.HeaderType(payload)
+		if !ok || ht != oggreader.HeaderOpusTags {
+			continue
+		}
+
+		tags, err := oggreader.ParseOpusTags(payload)
+		if err != nil {
+			break
+		}
+
+		for _, c := range tags.UserComments {
+			key := strings.ToLower(strings.TrimSpace(c.Comment))
+			val := strings.TrimSpace(c.Value)
+
+			switch key {
+			case "title":
+				if title == "" && val != "" {
+					title = val
+				}
+			case "artist":
+				if val != "" {
+					artistVals = append(artistVals, val)
+				}
+			}
+		}
+
+		break
+	}
+
+	// normalize + de-dupe artists (never return nil)
+	seen := map[string]struct{}{}
+	out := make([]string, 0, len(artistVals))
+	for _, v := range artistVals {
+		for _, a := range SplitArtists(v) {
+			if a == "" {
+				continue
+			}
+			if _, ok := seen[a]; ok {
+				continue
+			}
+			seen[a] = struct{}{}
+			out = append(out, a)
+		}
+	}
+
+	return title, out
+}
+
+// SplitArtists breaks a combined artist tag ("A feat. B", "A & B", ...) into names.
+func SplitArtists(v string) []string {
+	s := strings.TrimSpace(v)
+	if s == "" {
+		return nil
+	}
+
+	// avoid splitting on commas in case artist names contain commas
+	seps := []string{" feat. ", " ft. ", " featuring ", ";", " & ", "/", " x "}
+	out := []string{s}
+	for _, sep := range seps {
+		var next []string
+		for _, cur := range out {
+			parts := strings.Split(cur, sep)
+			for _, p := range parts {
+				p = strings.TrimSpace(p)
+				if p != "" {
+					next = append(next, p)
+				}
+			}
+		}
+		out = next
+	}
+	return out
+}
@@ -0,0 +1,127 @@
+package library
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const transcodeBitrate = "128k"
+
+// transcodable are the non-Opus formats that get converted into the Opus cache.
+var transcodable = map[string]bool{
+	".flac": true,
+	".mp3":  true,
+	".wav":  true,
+	".m4a":  true,
+	".ogg":  true, // usually Vorbis, ffmpeg sorts it out either way
+}
+
+var errNotPlayable = errors.New("not a playable media file")
+
+// Transcoder converts non-Opus media into a content-addressed Ogg Opus cache:
+// every source is stored as <sha256 of its bytes>.opus, so renaming or copying a
+// file never triggers a second transcode.
+type Transcoder struct {
+	ffmpegBin string
+	cacheDir  string
+
+	mu sync.Mutex // one ffmpeg at a time
+}
+
+// NewTranscoder checks that ffmpeg is available and creates cacheDir.
+func NewTranscoder(ffmpegPath, cacheDir string) (*Transcoder, error) {
+	if strings.TrimSpace(ffmpegPath) == "" {
+		ffmpegPath = "ffmpeg"
+	}
+	bin, err := exec.LookPath(ffmpegPath)
+	if err != nil {
+		return nil, fmt.Errorf("ffmpeg not found (required for transcoding): %w", err)
+	}
+	if err := os.MkdirAll(cacheDir, 0o755); err != nil {
+		return nil, fmt.Errorf("create transcode cache dir: %w", err)
+	}
+
+	return &Transcoder{ffmpegBin: bin, cacheDir: cacheDir}, nil
+}
+
+// IsOpus reports whether path can be played as-is.
+func IsOpus(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), ".opus")
+}
+
+// IsTranscodable reports whether path is a format the Transcoder handles.
+func IsTranscodable(path string) bool {
+	return transcodable[strings.ToLower(filepath.Ext(path))]
+}
+
+// Opus returns the cached Opus copy of src, transcoding it if needed. hash is
+// the content hash from a previous run; pass "" to (re)hash the file.
+func (t *Transcoder) Opus(src, hash string) (cached string, sum string, err error) {
+	if hash == "" {
+		if hash, err = hashFile(src); err != nil {
+			return "", "", err
+		}
+	}
+
+	cached = filepath.Join(t.cacheDir, hash+".opus")
+	if info, err := os.Stat(cached); err == nil && info.Size() > 0 {
+		return cached, hash, nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	log.Printf("library: transcoding %q", src)
+
+	tmp := cached + ".tmp"
+	args := []string{
+		"-hide_banner", "-loglevel", "error", "-nostdin", "-y",
+		"-i", src,
+		"-map", "0:a:0",
+		"-map_metadata", "0",
+		"-c:a", "libopus",
+		"-b:a", transcodeBitrate,
+		"-ar", "48000",
+		"-f", "ogg",
+		tmp,
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.Command(t.ffmpegBin, args...)
+	cmd.Stdout = io.Discard
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		_ = os.Remove(tmp)
+		return "", "", fmt.Errorf("transcode %q: %w: %s", src, err, strings.TrimSpace(stderr.String()))
+	}
+	if err := os.Rename(tmp, cached); err != nil {
+		_ = os.Remove(tmp)
+		return "", "", fmt.Errorf("store transcode of %q: %w", src, err)
+	}
+
+	return cached, hash, nil
+}
+
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = f.Close() }()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("hash %q: %w", path, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
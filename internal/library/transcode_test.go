@@ -0,0 +1,125 @@
+package library
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func sha(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+// testTranscoder never runs ffmpeg, so only files already in the cache work.
+func testTranscoder(t *testing.T) *Transcoder {
+	return &Transcoder{ffmpegBin: filepath.Join(t.TempDir(), "no-ffmpeg"), cacheDir: t.TempDir()}
+}
+
+func TestTranscoderCacheKey(t *testing.T) {
+	tc := testTranscoder(t)
+	root := t.TempDir()
+
+	src := filepath.Join(root, "song.flac")
+	if err := os.WriteFile(src, []byte("some flac"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cached := filepath.Join(tc.cacheDir, sha("some flac")+".opus")
+	if err := os.WriteFile(cached, []byte("opus"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// the same bytes under another name are the same transcode.
+	copied := filepath.Join(root, "renamed", "copy.flac")
+	if err := os.MkdirAll(filepath.Dir(copied), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(copied, []byte("some flac"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{src, copied} {
+		file, hash, err := tc.Opus(path, "")
+		if err != nil {
+			t.Fatalf("expected the cached copy but got %v", err)
+		}
+		if file != cached || hash != sha("some flac") {
+			t.Fatalf("expected %s (%s) but got %s (%s)", cached, sha("some flac"), file, hash)
+		}
+	}
+
+	// a known hash isn't worked out again.
+	if file, _, err := tc.Opus(filepath.Join(root, "gone.flac"), sha("some flac")); err != nil || file != cached {
+		t.Fatalf("expected %s for a known hash but got %s (%v)", cached, file, err)
+	}
+
+	// anything else needs ffmpeg.
+	other := filepath.Join(root, "other.flac")
+	if err := os.WriteFile(other, []byte("other flac"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := tc.Opus(other, ""); err == nil {
+		t.Fatalf("expected an error without ffmpeg")
+	}
+}
+
+func TestBackgroundTranscode(t *testing.T) {
+	tc := testTranscoder(t)
+	root := t.TempDir()
+
+	src := filepath.Join(root, "song.mp3")
+	if err := os.WriteFile(src, []byte("some mp3"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// converted on an earlier run.
+	writeOpus(t, filepath.Join(tc.cacheDir, sha("some mp3")+".opus"), 2*time.Second, "TITLE=Converted")
+	// never converted, and there's no ffmpeg to do it.
+	if err := os.WriteFile(filepath.Join(root, "new.wav"), []byte("some wav"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	idx := OpenIndex("")
+	idx.SetTranscoder(tc)
+	done := make(chan Track, 2)
+	idx.OnTranscoded(func(t Track) { done <- t })
+
+	// nothing waits for the transcoder.
+	tracks, err := idx.Scan(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tracks) != 0 {
+		t.Fatalf("expected nothing playable yet but got %d tracks", len(tracks))
+	}
+	if _, err := idx.Lookup(src); !errors.Is(err, ErrTranscoding) {
+		t.Fatalf("expected %v but got %v", ErrTranscoding, err)
+	}
+
+	select {
+	case got := <-done:
+		if got.Path != src || got.Title != "Converted" || got.Duration != 2*time.Second || got.Hash != sha("some mp3") {
+			t.Fatalf("expected the converted track but got %+v", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the transcode to finish")
+	}
+	for {
+		next := idx.Transcoded()
+		if idx.Pending() == 0 {
+			break
+		}
+		<-next
+	}
+
+	tracks, err = idx.Scan(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tracks) != 1 || tracks[0].PlayPath() != filepath.Join(tc.cacheDir, sha("some mp3")+".opus") {
+		t.Fatalf("expected just the converted track but got %+v", tracks)
+	}
+}
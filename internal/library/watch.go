@@ -0,0 +1,218 @@
+package library
+
+import (
+	"errors"
+	"hash"
+	"hash/fnv"
+	"io/fs"
+	"log"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultPollInterval is used when native (inotify) watching is unavailable.
+const DefaultPollInterval = 30 * time.Second
+
+// collapse bursts of events (e.g. an rsync of an album) into a single change.
+var watchDebounce = 2 * time.Second
+
+var errNativeWatchUnsupported = errors.New("native file watching not supported on this platform")
+
+// Watcher reports changes under one or more directory trees. It uses inotify
+// where available and falls back to periodically polling the trees otherwise.
+type Watcher struct {
+	roots     []string
+	pollEvery time.Duration
+	onChange  func()
+
+	notify    chan struct{}
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// Watch starts watching every root recursively. onChange is called (from a
+// background goroutine) once per burst of changes. Call Close to stop watching.
+func Watch(roots []string, pollEvery time.Duration, onChange func()) *Watcher {
+	if pollEvery <= 0 {
+		pollEvery = DefaultPollInterval
+	}
+
+	w := &Watcher{
+		roots:     collapseRoots(roots),
+		pollEvery: pollEvery,
+		onChange:  onChange,
+		notify:    make(chan struct{}, 1),
+		closed:    make(chan struct{}),
+	}
+
+	go w.debounce()
+
+	if err := w.watchNative(); err != nil {
+		log.Printf("library: watching %q by polling every %s (%v)", w.roots, pollEvery, err)
+		go w.poll()
+	} else {
+		log.Printf("library: watching %q for changes", w.roots)
+	}
+
+	return w
+}
+
+// Covers reports whether every one of roots is already being watched.
+func (w *Watcher) Covers(roots []string) bool {
+	if w == nil {
+		return false
+	}
+	for _, r := range collapseRoots(roots) {
+		if !under(r, w.roots) {
+			return false
+		}
+	}
+	return true
+}
+
+// collapseRoots cleans roots and drops the ones inside another.
+func collapseRoots(roots []string) []string {
+	clean := make([]string, 0, len(roots))
+	for _, r := range roots {
+		if strings.TrimSpace(r) != "" {
+			clean = append(clean, filepath.Clean(r))
+		}
+	}
+	// parents sort before their children.
+	sort.Strings(clean)
+
+	out := make([]string, 0, len(clean))
+	for _, r := range clean {
+		if !under(r, out) {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+func under(path string, roots []string) bool {
+	for _, r := range roots {
+		if path == r || strings.HasPrefix(path, r+string(filepath.Separator)) || r == string(filepath.Separator) {
+			return true
+		}
+	}
+	return false
+}
+
+// Close stops the watcher. It is safe to call more than once.
+func (w *Watcher) Close() {
+	if w == nil {
+		return
+	}
+	w.closeOnce.Do(func() {
+		close(w.closed)
+	})
+}
+
+func (w *Watcher) isClosed() bool {
+	select {
+	case <-w.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// changed is called by the native/poll backends whenever something moved.
+func (w *Watcher) changed() {
+	select {
+	case w.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (w *Watcher) debounce() {
+	for {
+		select {
+		case <-w.closed:
+			return
+		case <-w.notify:
+		}
+
+		// wait until the tree has been quiet for a bit.
+		timer := time.NewTimer(watchDebounce)
+	quiet:
+		for {
+			select {
+			case <-w.closed:
+				timer.Stop()
+				return
+			case <-w.notify:
+				if !timer.Stop() {
+					<-timer.C
+				}
+				timer.Reset(watchDebounce)
+			case <-timer.C:
+				break quiet
+			}
+		}
+
+		if w.onChange != nil {
+			w.onChange()
+		}
+	}
+}
+
+func (w *Watcher) poll() {
+	ticker := time.NewTicker(w.pollEvery)
+	defer ticker.Stop()
+
+	last := treeSignature(w.roots)
+	for {
+		select {
+		case <-w.closed:
+			return
+		case <-ticker.C:
+		}
+
+		sig := treeSignature(w.roots)
+		if sig != last {
+			last = sig
+			w.changed()
+		}
+	}
+}
+
+// treeSignature hashes the path/size/mtime of every file under roots using
+// only stat calls, so polling a big library stays cheap.
+func treeSignature(roots []string) uint64 {
+	h := fnv.New64a()
+	for _, root := range roots {
+		walkSignature(h, root)
+	}
+	return h.Sum64()
+}
+
+func walkSignature(h hash.Hash64, root string) {
+	_ = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if path != root && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		_, _ = h.Write([]byte(path))
+		_, _ = h.Write([]byte(strconv.FormatInt(info.Size(), 10)))
+		_, _ = h.Write([]byte(strconv.FormatInt(info.ModTime().UnixNano(), 10)))
+		return nil
+	})
+}
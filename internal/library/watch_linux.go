@@ -0,0 +1,123 @@
+//go:build linux
+
+package library
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+const inotifyMask = syscall.IN_CREATE |
+	syscall.IN_DELETE |
+	syscall.IN_CLOSE_WRITE |
+	syscall.IN_MOVED_FROM |
+	syscall.IN_MOVED_TO |
+	syscall.IN_DELETE_SELF |
+	syscall.IN_MOVE_SELF
+
+// watchNative registers an inotify watch on every directory under the roots.
+// inotify isn't recursive, so new subdirectories get watched as they appear.
+func (w *Watcher) watchNative() error {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return fmt.Errorf("inotify init: %w", err)
+	}
+
+	// nonblocking fds are registered with the runtime poller, so Close unblocks Read.
+	f := os.NewFile(uintptr(fd), "inotify")
+
+	if err := w.addInotifyWatches(fd); err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	go func() {
+		<-w.closed
+		_ = f.Close()
+	}()
+
+	go w.readInotify(fd, f)
+
+	return nil
+}
+
+// addInotifyWatches watches every root that exists, it only fails when none
+// of them can be watched.
+func (w *Watcher) addInotifyWatches(fd int) error {
+	var firstErr error
+	watched := 0
+	for _, root := range w.roots {
+		if err := addTreeWatches(fd, root); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		watched++
+	}
+	if watched == 0 && firstErr != nil {
+		return firstErr
+	}
+	return nil
+}
+
+func addTreeWatches(fd int, root string) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == root {
+				return err
+			}
+			return nil
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if path != root && strings.HasPrefix(d.Name(), ".") {
+			return filepath.SkipDir
+		}
+		if _, err := syscall.InotifyAddWatch(fd, path, inotifyMask); err != nil {
+			if path == root {
+				return fmt.Errorf("inotify watch %q: %w", path, err)
+			}
+			// e.g. fs.inotify.max_user_watches reached, the rest of the tree still works
+			return filepath.SkipDir
+		}
+		return nil
+	})
+}
+
+func (w *Watcher) readInotify(fd int, f *os.File) {
+	buf := make([]byte, 64*1024)
+
+	for {
+		n, err := f.Read(buf)
+		if err != nil {
+			if !w.isClosed() {
+				// lost the native watcher, keep going the slow way.
+				go w.poll()
+			}
+			return
+		}
+
+		newDirs := false
+		for off := 0; off+syscall.SizeofInotifyEvent <= n; {
+			mask := binary.NativeEndian.Uint32(buf[off+4 : off+8])
+			nameLen := int(binary.NativeEndian.Uint32(buf[off+12 : off+16]))
+			if mask&syscall.IN_ISDIR != 0 && mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0 {
+				newDirs = true
+			}
+			off += syscall.SizeofInotifyEvent + nameLen
+		}
+
+		if newDirs {
+			_ = w.addInotifyWatches(fd)
+		}
+
+		w.changed()
+	}
+}
@@ -0,0 +1,7 @@
+//go:build !linux
+
+package library
+
+func (w *Watcher) watchNative() error {
+	return errNativeWatchUnsupported
+}
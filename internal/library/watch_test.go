@@ -0,0 +1,79 @@
+package library
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCollapseRoots(t *testing.T) {
+	tests := []struct {
+		name     string
+		roots    []string
+		expected []string
+	}{
+		{
+			name:     "Nested Dropped",
+			roots:    []string{"/srv/media/rock", "/srv/media", "/srv/media/jazz/"},
+			expected: []string{"/srv/media"},
+		},
+		{
+			name:     "Siblings Kept",
+			roots:    []string{"/srv/b", "/srv/a", "/srv/a", ""},
+			expected: []string{"/srv/a", "/srv/b"},
+		},
+		{
+			name:     "Prefix Isn't Parent",
+			roots:    []string{"/srv/media", "/srv/media2"},
+			expected: []string{"/srv/media", "/srv/media2"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var roots, expected []string
+			for _, r := range tc.roots {
+				roots = append(roots, filepath.FromSlash(r))
+			}
+			for _, r := range tc.expected {
+				expected = append(expected, filepath.FromSlash(r))
+			}
+			if got := collapseRoots(roots); !reflect.DeepEqual(got, expected) {
+				t.Fatalf("expected %q but got %q", expected, got)
+			}
+		})
+	}
+}
+
+func TestWatch(t *testing.T) {
+	defer func(d time.Duration) { watchDebounce = d }(watchDebounce)
+	watchDebounce = 50 * time.Millisecond
+
+	first, second := t.TempDir(), t.TempDir()
+	changed := make(chan struct{}, 8)
+	w := Watch([]string{first, second}, 50*time.Millisecond, func() {
+		changed <- struct{}{}
+	})
+	defer w.Close()
+
+	if !w.Covers([]string{filepath.Join(second, "album"), first}) {
+		t.Fatalf("expected both roots to be covered")
+	}
+	if w.Covers([]string{t.TempDir()}) {
+		t.Fatalf("expected an unrelated dir not to be covered")
+	}
+
+	// a change in either tree is picked up.
+	for _, dir := range []string{second, first} {
+		if err := os.WriteFile(filepath.Join(dir, "new.opus"), []byte("x"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case <-changed:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected a change in %s to be reported", dir)
+		}
+	}
+}
@@ -0,0 +1,335 @@
+package opusstream
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/philipch07/EggsFM/internal/audio"
+	"github.com/philipch07/EggsFM/internal/dvr"
+	"github.com/philipch07/EggsFM/internal/viewers"
+)
+
+// Streamer serves the tee'd Ogg Opus bytes as-is over HTTP, so players that
+// understand Opus get full quality without an ffmpeg in the way. Listeners get
+// the current logical stream's OpusHead/OpusTags first and then join at the
+// next page boundary.
+type Streamer struct {
+	stationName string
+	dvr         *dvr.Buffer
+
+	mu        sync.Mutex
+	pending   []byte // bytes of a page that hasn't fully arrived yet
+	header    []byte
+	partial   int // bytes of a new stream's header seen so far, 0 once complete
+	collector *audio.OpusHeaderCollector
+	clients   map[*client]struct{}
+
+	dropCnt uint64
+}
+
+type client struct {
+	ch chan []byte
+	// no usable header was cached when this client joined, so it waits for the
+	// next logical stream to begin or for the current one's header to complete.
+	needBOS bool
+}
+
+const (
+	clientBufferSize = 64
+
+	// give up on a partial page that never completes.
+	maxPendingBytes = 1 << 20
+)
+
+var capturePattern = []byte("OggS")
+
+// New makes a Streamer. With a dvrWindow listeners can start up to that far
+// back with ?offset=.
+func New(stationName string, dvrWindow time.Duration) *Streamer {
+	if strings.TrimSpace(stationName) == "" {
+		stationName = "EggsFM"
+	}
+
+	return &Streamer{
+		stationName: stationName,
+		dvr:         dvr.New(dvrWindow),
+		collector:   audio.NewOpusHeaderCollector(),
+		clients:     make(map[*client]struct{}),
+	}
+}
+
+// AudioWriter returns the writer to add to the Ogg tee.
+func (s *Streamer) AudioWriter() io.Writer {
+	return s
+}
+
+// DropCount returns how many listeners were dropped for falling behind.
+func (s *Streamer) DropCount() uint64 {
+	return atomic.LoadUint64(&s.dropCnt)
+}
+
+// Write takes raw Ogg bytes in whatever chunks the tee hands over and fans
+// them out one complete page (or run of pages) at a time. It never fails.
+func (s *Streamer) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pending = trimToCapture(append(s.pending, p...))
+	n := completePages(s.pending)
+	if n == 0 {
+		if len(s.pending) > maxPendingBytes {
+			s.pending = nil
+		}
+		return len(p), nil
+	}
+
+	chunk := make([]byte, n)
+	copy(chunk, s.pending[:n])
+	s.pending = append(s.pending[:0], s.pending[n:]...)
+
+	// the pages in front of a new stream's headers still belong to the old one.
+	s.dvr.Append(chunk, s.header)
+	bos := bosOffset(chunk)
+
+	// caught up is the new header plus the rest of this chunk, for listeners
+	// that joined while the header was still coming in.
+	var caughtUp []byte
+	if header := s.collector.Feed(chunk); header != nil {
+		if done := len(header) - s.partial; s.partial > 0 && done >= 0 && done <= len(chunk) {
+			caughtUp = append(append([]byte(nil), header...), chunk[done:]...)
+		}
+		s.header = header
+		s.partial = 0
+	} else if bos >= 0 {
+		// a new OpusHead without its OpusTags yet, the old header is stale.
+		s.header = nil
+		s.partial = len(chunk) - bos
+	} else if s.partial > 0 {
+		s.partial += len(chunk)
+	}
+
+	for c := range s.clients {
+		out := chunk
+		if c.needBOS {
+			switch {
+			case bos >= 0:
+				out = chunk[bos:]
+			case caughtUp != nil:
+				out = caughtUp
+			default:
+				continue
+			}
+			c.needBOS = false
+		}
+
+		select {
+		case c.ch <- out:
+		default:
+			// too far behind to catch up, let the player reconnect.
+			delete(s.clients, c)
+			close(c.ch)
+			atomic.AddUint64(&s.dropCnt, 1)
+		}
+	}
+
+	return len(p), nil
+}
+
+// trimToCapture drops anything in front of the first page, keeping a possibly
+// cut off "OggS" at the end.
+func trimToCapture(b []byte) []byte {
+	i := bytes.Index(b, capturePattern)
+	if i < 0 {
+		keep := min(len(b), len(capturePattern)-1)
+		return append(b[:0], b[len(b)-keep:]...)
+	}
+	return b[i:]
+}
+
+// completePages returns how many leading bytes of b are whole Ogg pages.
+func completePages(b []byte) int {
+	off := 0
+	for {
+		rest := b[off:]
+		if len(rest) < 27 || !bytes.HasPrefix(rest, capturePattern) {
+			return off
+		}
+		segs := int(rest[26])
+		if len(rest) < 27+segs {
+			return off
+		}
+		size := 27 + segs
+		for _, lace := range rest[27 : 27+segs] {
+			size += int(lace)
+		}
+		if len(rest) < size {
+			return off
+		}
+		off += size
+	}
+}
+
+// bosOffset finds the first page in chunk that starts a logical stream.
+func bosOffset(chunk []byte) int {
+	off := 0
+	for off+27 <= len(chunk) {
+		if !bytes.HasPrefix(chunk[off:], capturePattern) {
+			i := bytes.Index(chunk[off+1:], capturePattern)
+			if i < 0 {
+				return -1
+			}
+			off += i + 1
+			continue
+		}
+		if chunk[off+5]&0x02 != 0 {
+			return off
+		}
+		segs := int(chunk[off+26])
+		if off+27+segs > len(chunk) {
+			return -1
+		}
+		size := 27 + segs
+		for _, lace := range chunk[off+27 : off+27+segs] {
+			size += int(lace)
+		}
+		off += size
+	}
+	return -1
+}
+
+func (s *Streamer) addClient() (*client, []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c := &client{ch: make(chan []byte, clientBufferSize)}
+	header := append([]byte(nil), s.header...)
+	c.needBOS = len(header) == 0
+	s.clients[c] = struct{}{}
+	return c, header
+}
+
+func (s *Streamer) removeClient(c *client) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.clients[c]; ok {
+		delete(s.clients, c)
+		close(c.ch)
+	}
+}
+
+// Subscribe feeds the stream to an Icecast relay: the header (if any) to send
+// first, the pages, and a func to stop. Like a listener, a relay that falls
+// behind gets its channel closed.
+func (s *Streamer) Subscribe() (<-chan []byte, []byte, func()) {
+	c, header := s.addClient()
+	return c.ch, header, func() { s.removeClient(c) }
+}
+
+// Handler serves the live Ogg Opus stream.
+func (s *Streamer) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "audio/ogg; codecs=opus")
+		w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+		w.Header().Set("Pragma", "no-cache")
+		w.Header().Set("icy-name", s.stationName)
+		w.Header().Set("icy-description", s.stationName)
+		w.Header().Set("icy-pub", "1")
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+
+		if r.Method == http.MethodHead {
+			return
+		}
+
+		stopTracking := viewers.TrackConnection(viewers.ProtocolOpus, r)
+		defer stopTracking()
+
+		flusher, _ := w.(http.Flusher)
+
+		if offset := dvr.Offset(r); offset > 0 && s.dvr != nil {
+			s.serveRewind(w, r, flusher, offset)
+			return
+		}
+
+		c, header := s.addClient()
+		defer s.removeClient(c)
+
+		if len(header) > 0 {
+			if _, err := w.Write(header); err != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case chunk, ok := <-c.ch:
+				if !ok {
+					return
+				}
+				if _, err := w.Write(chunk); err != nil {
+					return
+				}
+				if flusher != nil {
+					flusher.Flush()
+				}
+			}
+		}
+	})
+}
+
+// serveRewind plays from offset back, at the listener's pace, starting with
+// the headers of the stream that was playing then.
+func (s *Streamer) serveRewind(w http.ResponseWriter, r *http.Request, flusher http.Flusher, offset time.Duration) {
+	seq, ok := s.dvr.Seek(offset)
+	if !ok {
+		return
+	}
+	started := false
+	for {
+		chunk, err := s.dvr.Next(r.Context(), seq)
+		if err != nil {
+			return
+		}
+		seq = chunk.Seq + 1
+
+		data := chunk.Data
+		if !started {
+			switch i := bosOffset(data); {
+			case i == 0:
+			case len(chunk.Header) > 0:
+				data = append(append([]byte(nil), chunk.Header...), data...)
+			case i > 0:
+				data = data[i:]
+			default:
+				// no headers to go with these pages, wait for the next stream.
+				continue
+			}
+			started = true
+		}
+
+		if _, err := w.Write(data); err != nil {
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package opusstream
+
+import (
+	"bytes"
+	"testing"
+)
+
+// page builds an Ogg page with a single packet.
+func page(headerType byte, payload string) []byte {
+	p := make([]byte, 27, 28+len(payload))
+	copy(p, "OggS")
+	p[5] = headerType
+	p[26] = 1
+	p = append(p, byte(len(payload)))
+	return append(p, payload...)
+}
+
+func TestWriteSplitsPages(t *testing.T) {
+	head := page(0x02, "OpusHead\x01\x02\x38\x01\x80\xbb\x00\x00\x00\x00\x00")
+	tags := page(0x00, "OpusTags\x00\x00\x00\x00\x00\x00\x00\x00")
+	audio1 := page(0x00, "audio-1")
+	audio2 := page(0x00, "audio-2")
+
+	tests := []struct {
+		name   string
+		writes [][]byte
+		header []byte
+		chunks [][]byte
+	}{
+		{
+			name:   "whole pages",
+			writes: [][]byte{append(append([]byte{}, head...), tags...), audio1},
+			header: append(append([]byte{}, head...), tags...),
+			chunks: [][]byte{append(append([]byte{}, head...), tags...), audio1},
+		},
+		{
+			name:   "page split across writes",
+			writes: [][]byte{head[:10], head[10:], audio1[:30], audio1[30:]},
+			chunks: [][]byte{head, audio1},
+		},
+		{
+			name:   "junk before a page",
+			writes: [][]byte{[]byte("garbage"), audio2},
+			chunks: [][]byte{audio2},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := New("", 0)
+			c := &client{ch: make(chan []byte, clientBufferSize)}
+			s.clients[c] = struct{}{}
+
+			for _, w := range tt.writes {
+				if n, err := s.Write(w); err != nil || n != len(w) {
+					t.Fatalf("expected write of %d bytes but got %d (%v)", len(w), n, err)
+				}
+			}
+
+			for i, want := range tt.chunks {
+				select {
+				case got := <-c.ch:
+					if !bytes.Equal(got, want) {
+						t.Fatalf("expected chunk %d to be %q but got %q", i, want, got)
+					}
+				default:
+					t.Fatalf("expected chunk %d but got none", i)
+				}
+			}
+			if tt.header != nil && !bytes.Equal(s.header, tt.header) {
+				t.Fatalf("expected cached header %q but got %q", tt.header, s.header)
+			}
+		})
+	}
+}
+
+func TestNewClientWaitsForStreamStart(t *testing.T) {
+	s := New("", 0)
+	c, header := s.addClient()
+	if len(header) != 0 {
+		t.Fatalf("expected no header but got %q", header)
+	}
+
+	audio := page(0x00, "audio")
+	bos := page(0x02, "OpusHead\x01\x02\x38\x01\x80\xbb\x00\x00\x00\x00\x00")
+	_, _ = s.Write(audio)
+	_, _ = s.Write(append(append([]byte{}, audio...), bos...))
+
+	got := <-c.ch
+	if !bytes.Equal(got, bos) {
+		t.Fatalf("expected the client to join at %q but got %q", bos, got)
+	}
+}
+
+func TestClientJoinsMidHeader(t *testing.T) {
+	headA := page(0x02, "OpusHead\x01\x02\x38\x01\x80\xbb\x00\x00\x00\x00\x00")
+	headB := page(0x02, "OpusHead\x01\x01\x38\x01\x44\xac\x00\x00\x00\x00\x00")
+	tags := page(0x00, "OpusTags\x00\x00\x00\x00\x00\x00\x00\x00")
+	audio := page(0x00, "audio")
+
+	s := New("", 0)
+	_, _ = s.Write(append(append(append([]byte{}, headA...), tags...), audio...))
+	_, _ = s.Write(headB)
+
+	// the new stream has its OpusHead but not its OpusTags, so the cached
+	// header of the old one mustn't be handed out.
+	c, header := s.addClient()
+	if len(header) != 0 {
+		t.Fatalf("expected no header while the new one is incomplete but got %q", header)
+	}
+	if !c.needBOS {
+		t.Fatalf("expected the client to wait for the header but it didn't")
+	}
+
+	_, _ = s.Write(append(append([]byte{}, tags...), audio...))
+
+	want := append(append(append([]byte{}, headB...), tags...), audio...)
+	select {
+	case got := <-c.ch:
+		if !bytes.Equal(got, want) {
+			t.Fatalf("expected the client to get %q but got %q", want, got)
+		}
+	default:
+		t.Fatalf("expected the client to get the completed header but got nothing")
+	}
+
+	expectedHeader := append(append([]byte{}, headB...), tags...)
+	if _, header := s.addClient(); !bytes.Equal(header, expectedHeader) {
+		t.Fatalf("expected cached header %q but got %q", expectedHeader, header)
+	}
+}
@@ -0,0 +1,311 @@
+package playlist
+
+import (
+	"bufio"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Entry is a single playable item from a playlist file.
+// Title/Artist are only set when the playlist carries them (EXTINF, PLS TitleN,
+// XSPF title/creator) and should override whatever the media file says.
+type Entry struct {
+	Path     string
+	Title    string
+	Artist   string
+	Duration time.Duration
+}
+
+var errUnsupportedFormat = errors.New("unsupported playlist format")
+
+// IsPlaylistFile reports whether path has an extension we know how to parse.
+func IsPlaylistFile(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".m3u", ".m3u8", ".pls", ".xspf":
+		return true
+	default:
+		return false
+	}
+}
+
+// Load parses the playlist at path. The format is picked from the extension and
+// relative entries are resolved against the playlist's own directory.
+func Load(path string) ([]Entry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	baseDir := filepath.Dir(path)
+
+	var entries []Entry
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".m3u", ".m3u8":
+		entries, err = ParseM3U(f, baseDir)
+	case ".pls":
+		entries, err = ParsePLS(f, baseDir)
+	case ".xspf":
+		entries, err = ParseXSPF(f, baseDir)
+	default:
+		return nil, fmt.Errorf("%w: %q", errUnsupportedFormat, filepath.Ext(path))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse playlist %q: %w", path, err)
+	}
+
+	return entries, nil
+}
+
+// ParseM3U reads a plain or extended M3U/M3U8 playlist.
+// "#EXTINF:<secs>,<artist> - <title>" applies to the next path line.
+func ParseM3U(r io.Reader, baseDir string) ([]Entry, error) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	var (
+		out     []Entry
+		pending Entry
+		first   = true
+	)
+
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if first {
+			// tolerate a UTF-8 BOM on the first line (common in .m3u8 exports)
+			line = strings.TrimPrefix(line, "\ufeff")
+			first = false
+		}
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "#") {
+			if rest, ok := strings.CutPrefix(line, "#EXTINF:"); ok {
+				pending = parseExtInf(rest)
+			}
+			continue
+		}
+
+		path, ok := resolvePath(line, baseDir)
+		if ok {
+			pending.Path = path
+			out = append(out, pending)
+		}
+		pending = Entry{}
+	}
+
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+func parseExtInf(rest string) Entry {
+	var e Entry
+
+	durPart, info, found := strings.Cut(rest, ",")
+	if !found {
+		info = ""
+	}
+
+	// the duration can be followed by key="value" attributes, ignore those.
+	if fields := strings.Fields(durPart); len(fields) > 0 {
+		if secs, err := strconv.ParseFloat(fields[0], 64); err == nil && secs > 0 {
+			e.Duration = time.Duration(secs * float64(time.Second))
+		}
+	}
+
+	info = strings.TrimSpace(info)
+	if artist, title, ok := strings.Cut(info, " - "); ok {
+		e.Artist = strings.TrimSpace(artist)
+		e.Title = strings.TrimSpace(title)
+	} else {
+		e.Title = info
+	}
+
+	return e
+}
+
+// ParsePLS reads a PLS (INI style) playlist. Entries are returned in FileN order.
+func ParsePLS(r io.Reader, baseDir string) ([]Entry, error) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	byIndex := map[int]*Entry{}
+	get := func(i int) *Entry {
+		e := byIndex[i]
+		if e == nil {
+			e = &Entry{}
+			byIndex[i] = e
+		}
+		return e
+	}
+
+	for sc.Scan() {
+		line := strings.TrimSpace(strings.TrimPrefix(sc.Text(), "\ufeff"))
+		if line == "" || strings.HasPrefix(line, "[") || strings.HasPrefix(line, ";") {
+			continue
+		}
+
+		key, val, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		val = strings.TrimSpace(val)
+
+		var (
+			field string
+			idx   int
+			err   error
+		)
+		switch {
+		case strings.HasPrefix(key, "file"):
+			field = "file"
+			idx, err = strconv.Atoi(key[len("file"):])
+		case strings.HasPrefix(key, "title"):
+			field = "title"
+			idx, err = strconv.Atoi(key[len("title"):])
+		case strings.HasPrefix(key, "length"):
+			field = "length"
+			idx, err = strconv.Atoi(key[len("length"):])
+		default:
+			continue
+		}
+		if err != nil {
+			continue
+		}
+
+		e := get(idx)
+		switch field {
+		case "file":
+			e.Path = val
+		case "title":
+			if artist, title, ok := strings.Cut(val, " - "); ok {
+				e.Artist = strings.TrimSpace(artist)
+				e.Title = strings.TrimSpace(title)
+			} else {
+				e.Title = val
+			}
+		case "length":
+			if secs, err := strconv.ParseFloat(val, 64); err == nil && secs > 0 {
+				e.Duration = time.Duration(secs * float64(time.Second))
+			}
+		}
+	}
+
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+
+	indexes := make([]int, 0, len(byIndex))
+	for i := range byIndex {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+
+	out := make([]Entry, 0, len(indexes))
+	for _, i := range indexes {
+		e := *byIndex[i]
+		path, ok := resolvePath(e.Path, baseDir)
+		if !ok {
+			continue
+		}
+		e.Path = path
+		out = append(out, e)
+	}
+
+	return out, nil
+}
+
+type xspfPlaylist struct {
+	Tracks []xspfTrack `xml:"trackList>track"`
+}
+
+type xspfTrack struct {
+	Location []string `xml:"location"`
+	Title    string   `xml:"title"`
+	Creator  string   `xml:"creator"`
+	Duration int64    `xml:"duration"` // milliseconds
+}
+
+// ParseXSPF reads an XSPF (XML Shareable Playlist Format) playlist.
+// The first resolvable <location> of each track wins.
+func ParseXSPF(r io.Reader, baseDir string) ([]Entry, error) {
+	var doc xspfPlaylist
+	if err := xml.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, err
+	}
+
+	out := make([]Entry, 0, len(doc.Tracks))
+	for _, t := range doc.Tracks {
+		for _, loc := range t.Location {
+			loc = strings.TrimSpace(loc)
+			if !strings.Contains(loc, "://") {
+				// relative locations are URI references, so they may be escaped too
+				if unescaped, err := url.PathUnescape(loc); err == nil {
+					loc = unescaped
+				}
+			}
+			path, ok := resolvePath(loc, baseDir)
+			if !ok {
+				continue
+			}
+			e := Entry{
+				Path:   path,
+				Title:  strings.TrimSpace(t.Title),
+				Artist: strings.TrimSpace(t.Creator),
+			}
+			if t.Duration > 0 {
+				e.Duration = time.Duration(t.Duration) * time.Millisecond
+			}
+			out = append(out, e)
+			break
+		}
+	}
+
+	return out, nil
+}
+
+// resolvePath turns a playlist location into a local file path.
+// Remote URLs are not playable by the autoplay loop so they are skipped.
+func resolvePath(loc, baseDir string) (string, bool) {
+	loc = strings.TrimSpace(loc)
+	if loc == "" {
+		return "", false
+	}
+
+	if strings.Contains(loc, "://") {
+		u, err := url.Parse(loc)
+		if err != nil || !strings.EqualFold(u.Scheme, "file") {
+			return "", false
+		}
+		loc = u.Path
+		if loc == "" {
+			return "", false
+		}
+	}
+
+	// playlists made on windows use backslashes
+	if filepath.Separator == '/' {
+		loc = strings.ReplaceAll(loc, `\`, "/")
+	}
+
+	loc = filepath.FromSlash(loc)
+	if !filepath.IsAbs(loc) {
+		loc = filepath.Join(baseDir, loc)
+	}
+
+	return filepath.Clean(loc), true
+}
@@ -0,0 +1,114 @@
+package playlist
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParsers(t *testing.T) {
+	base := filepath.FromSlash("/srv/media/lists")
+
+	tests := []struct {
+		name     string
+		parse    func(string) ([]Entry, error)
+		input    string
+		expected []Entry
+	}{
+		{
+			name: "M3U With EXTINF",
+			parse: func(in string) ([]Entry, error) {
+				return ParseM3U(strings.NewReader(in), base)
+			},
+			input: "\ufeff#EXTM3U\n" +
+				"#EXTINF:123,Some Artist - Some Title\n" +
+				"../album/01.opus\n" +
+				"\n" +
+				"# a comment\n" +
+				"/abs/02.opus\n" +
+				"https://example.com/remote.opus\n" +
+				"#EXTINF:-1 tvg-id=\"x\",Only Title\n" +
+				"file:///abs/03.opus\n",
+			expected: []Entry{
+				{Path: filepath.FromSlash("/srv/media/album/01.opus"), Title: "Some Title", Artist: "Some Artist", Duration: 123 * time.Second},
+				{Path: filepath.FromSlash("/abs/02.opus")},
+				{Path: filepath.FromSlash("/abs/03.opus"), Title: "Only Title"},
+			},
+		},
+		{
+			name: "PLS Ordered By Index",
+			parse: func(in string) ([]Entry, error) {
+				return ParsePLS(strings.NewReader(in), base)
+			},
+			input: "[playlist]\n" +
+				"File2=b.opus\n" +
+				"Title2=Artist B - Title B\n" +
+				"File1=a.opus\n" +
+				"Title1=Title A\n" +
+				"Length1=61.5\n" +
+				"NumberOfEntries=2\n" +
+				"Version=2\n",
+			expected: []Entry{
+				{Path: filepath.FromSlash("/srv/media/lists/a.opus"), Title: "Title A", Duration: 61500 * time.Millisecond},
+				{Path: filepath.FromSlash("/srv/media/lists/b.opus"), Title: "Title B", Artist: "Artist B"},
+			},
+		},
+		{
+			name: "XSPF",
+			parse: func(in string) ([]Entry, error) {
+				return ParseXSPF(strings.NewReader(in), base)
+			},
+			input: `<?xml version="1.0" encoding="UTF-8"?>
+<playlist version="1" xmlns="http://xspf.org/ns/0/">
+  <trackList>
+    <track>
+      <location>http://example.com/skip.opus</location>
+      <location>sub/My%20Song.opus</location>
+      <title>My Song</title>
+      <creator>Me</creator>
+      <duration>2000</duration>
+    </track>
+    <track>
+      <location>file:///abs/other.opus</location>
+    </track>
+  </trackList>
+</playlist>`,
+			expected: []Entry{
+				{Path: filepath.FromSlash("/srv/media/lists/sub/My Song.opus"), Title: "My Song", Artist: "Me", Duration: 2 * time.Second},
+				{Path: filepath.FromSlash("/abs/other.opus")},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.parse(tt.input)
+			if err != nil {
+				t.Fatalf("did not expect an error but got %v", err)
+			}
+
+			if len(got) != len(tt.expected) {
+				t.Fatalf("expected %d entries but got %d: %+v", len(tt.expected), len(got), got)
+			}
+
+			for i := range got {
+				if got[i] != tt.expected[i] {
+					t.Fatalf("entry %d: expected %+v but got %+v", i, tt.expected[i], got[i])
+				}
+			}
+		})
+	}
+}
+
+func TestLoadUnsupported(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "list.txt")
+	if err := os.WriteFile(path, []byte("a.opus\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Load(path); err == nil {
+		t.Fatalf("expected an error but got none")
+	}
+}
@@ -0,0 +1,129 @@
+package recorder
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// just enough Ogg to write a single Opus logical stream (RFC 3533, RFC 7845).
+
+const (
+	pageBOS = 0x02
+	pageEOS = 0x04
+
+	maxPageSegments = 255
+)
+
+var crcTable = func() (t [256]uint32) {
+	for i := range t {
+		r := uint32(i) << 24
+		for range 8 {
+			if r&0x80000000 != 0 {
+				r = r<<1 ^ 0x04c11db7
+			} else {
+				r <<= 1
+			}
+		}
+		t[i] = r
+	}
+	return t
+}()
+
+func oggCRC(b []byte) uint32 {
+	var crc uint32
+	for _, v := range b {
+		crc = crc<<8 ^ crcTable[byte(crc>>24)^v]
+	}
+	return crc
+}
+
+// lacing is how many segments packets need on a page.
+func lacing(packets [][]byte) int {
+	n := 0
+	for _, p := range packets {
+		n += len(p)/255 + 1
+	}
+	return n
+}
+
+// oggPage builds one page holding whole packets.
+func oggPage(flags byte, granule uint64, serial, seq uint32, packets [][]byte) []byte {
+	var segs, body []byte
+	for _, p := range packets {
+		n := len(p)
+		for ; n >= 255; n -= 255 {
+			segs = append(segs, 255)
+		}
+		segs = append(segs, byte(n))
+		body = append(body, p...)
+	}
+
+	page := make([]byte, 0, 27+len(segs)+len(body))
+	page = append(page, "OggS"...)
+	page = append(page, 0, flags)
+	page = binary.LittleEndian.AppendUint64(page, granule)
+	page = binary.LittleEndian.AppendUint32(page, serial)
+	page = binary.LittleEndian.AppendUint32(page, seq)
+	page = append(page, 0, 0, 0, 0) // crc, filled in below
+	page = append(page, byte(len(segs)))
+	page = append(page, segs...)
+	page = append(page, body...)
+
+	binary.LittleEndian.PutUint32(page[22:], oggCRC(page))
+	return page
+}
+
+// headerPackets pulls OpusHead and OpusTags out of the header pages the
+// collector hands over.
+func headerPackets(pages []byte) (head, tags []byte) {
+	var pkt []byte
+	for len(pages) >= 27 && bytes.HasPrefix(pages, []byte("OggS")) {
+		nsegs := int(pages[26])
+		if len(pages) < 27+nsegs {
+			break
+		}
+		segs := pages[27 : 27+nsegs]
+		off := 27 + nsegs
+		for _, lace := range segs {
+			if off+int(lace) > len(pages) {
+				return head, tags
+			}
+			pkt = append(pkt, pages[off:off+int(lace)]...)
+			off += int(lace)
+			if lace == 255 {
+				continue
+			}
+			switch {
+			case bytes.HasPrefix(pkt, []byte("OpusHead")):
+				head = pkt
+			case bytes.HasPrefix(pkt, []byte("OpusTags")):
+				tags = pkt
+			}
+			pkt = nil
+		}
+		pages = pages[off:]
+	}
+	return head, tags
+}
+
+// opusTags is our own comment header, the track's doesn't fit a recording
+// of many tracks.
+func opusTags(vendor string, comments ...string) []byte {
+	b := []byte("OpusTags")
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(vendor)))
+	b = append(b, vendor...)
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(comments)))
+	for _, c := range comments {
+		b = binary.LittleEndian.AppendUint32(b, uint32(len(c)))
+		b = append(b, c...)
+	}
+	return b
+}
+
+// preSkip reads the pre-skip out of an OpusHead packet.
+func preSkip(head []byte) uint64 {
+	if len(head) < 12 {
+		return 0
+	}
+	return uint64(binary.LittleEndian.Uint16(head[10:12]))
+}
@@ -0,0 +1,408 @@
+// Package recorder archives everything that goes out: the tee'd Ogg Opus is
+// cut into files of a fixed length (hourly by default), each a proper Ogg
+// Opus stream of its own, with a JSON cue sheet of the track changes next to
+// it. Old recordings are removed by age and/or total size.
+package recorder
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/philipch07/EggsFM/internal/audio"
+)
+
+type Config struct {
+	Dir      string
+	Rotate   time.Duration // length of a file, defaultRotate when 0
+	MaxAge   time.Duration // delete recordings older than this, 0 keeps them
+	MaxBytes int64         // delete the oldest past this total, 0 for no limit
+}
+
+// Recorder is a tee writer. Writes are queued and never block the stream.
+type Recorder struct {
+	cfg Config
+
+	events    chan event
+	dropCnt   uint64
+	dropOnce  sync.Once
+	closed    chan struct{}
+	closeOnce sync.Once
+	done      chan struct{}
+
+	// only touched by run.
+	collector  *audio.OpusHeaderCollector
+	packetizer audio.OggOpusPacketizer
+	head       []byte
+	nowPlaying *Cue
+	file       *recording
+	retryAt    time.Time // don't try opening a file again before this
+	openFailed bool      // the failure's been logged already
+}
+
+// Cue is one track change, as it lands in the cue sheet.
+type Cue struct {
+	Offset  float64   `json:"offset"` // seconds into the file
+	At      time.Time `json:"at"`
+	Title   string    `json:"title"`
+	Artists []string  `json:"artists,omitempty"`
+}
+
+type cueSheet struct {
+	File      string    `json:"file"`
+	StartedAt time.Time `json:"startedAt"`
+	Duration  float64   `json:"duration"`
+	Tracks    []Cue     `json:"tracks"`
+}
+
+type event struct {
+	audio []byte
+	cue   *Cue
+}
+
+const (
+	defaultRotate = time.Hour
+	eventSlots    = 512
+
+	sampleRate = 48000
+	// a page every second or so.
+	pageSamples = sampleRate
+
+	fileTimeLayout = "2006-01-02T15-04-05Z"
+
+	// how long to wait after a file couldn't be created.
+	openRetryDelay = 10 * time.Second
+	// names tried for files started in the same second, -1, -2 etc.
+	maxNameSuffix = 100
+)
+
+// Start makes Dir and starts taking audio.
+func Start(cfg Config) (*Recorder, error) {
+	if strings.TrimSpace(cfg.Dir) == "" {
+		return nil, errors.New("recorder needs a directory")
+	}
+	if cfg.Rotate <= 0 {
+		cfg.Rotate = defaultRotate
+	}
+	if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create recording dir: %w", err)
+	}
+
+	r := &Recorder{
+		cfg:       cfg,
+		events:    make(chan event, eventSlots),
+		closed:    make(chan struct{}),
+		done:      make(chan struct{}),
+		collector: audio.NewOpusHeaderCollector(),
+	}
+	go r.run()
+
+	log.Printf("Recording to %s (files of %s, keeping %s)", cfg.Dir, cfg.Rotate, r.retentionString())
+	return r, nil
+}
+
+// Write takes raw Ogg bytes from the tee. It never fails.
+func (r *Recorder) Write(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+	r.send(event{audio: append([]byte(nil), b...)})
+	return len(b), nil
+}
+
+// NowPlaying marks a track change at the point the audio has reached.
+func (r *Recorder) NowPlaying(title string, artists []string) {
+	r.send(event{cue: &Cue{
+		At:      time.Now().UTC(),
+		Title:   title,
+		Artists: append([]string(nil), artists...),
+	}})
+}
+
+// DropCount returns how many writes were lost to a full queue.
+func (r *Recorder) DropCount() uint64 {
+	return atomic.LoadUint64(&r.dropCnt)
+}
+
+func (r *Recorder) send(e event) {
+	select {
+	case <-r.closed:
+		return
+	default:
+	}
+
+	select {
+	case r.events <- e:
+	default:
+		atomic.AddUint64(&r.dropCnt, 1)
+		r.dropOnce.Do(func() {
+			log.Printf("recorder dropping audio: disk can't keep up")
+		})
+	}
+}
+
+// Close finishes the current file.
+func (r *Recorder) Close() {
+	r.closeOnce.Do(func() {
+		close(r.closed)
+		<-r.done
+	})
+}
+
+func (r *Recorder) run() {
+	defer close(r.done)
+
+	for {
+		select {
+		case e := <-r.events:
+			r.handle(e)
+		case <-r.closed:
+			// whatever's queued still goes in.
+			for {
+				select {
+				case e := <-r.events:
+					r.handle(e)
+				default:
+					r.finish()
+					return
+				}
+			}
+		}
+	}
+}
+
+func (r *Recorder) handle(e event) {
+	if e.cue != nil {
+		r.nowPlaying = e.cue
+		if r.file != nil {
+			r.file.cue(*e.cue)
+		}
+		return
+	}
+
+	if header := r.collector.Feed(e.audio); header != nil {
+		if head, _ := headerPackets(header); head != nil {
+			r.head = head
+		}
+	}
+	r.packetizer.Feed(e.audio, r.addPacket)
+}
+
+func (r *Recorder) addPacket(pkt []byte) {
+	now := time.Now()
+	if r.file != nil && !now.Before(r.file.rotateAt) {
+		r.finish()
+	}
+	if r.file == nil {
+		if r.head == nil {
+			// nothing to start a file with until the next track's headers.
+			return
+		}
+		if now.Before(r.retryAt) {
+			return
+		}
+		f, err := r.open(now)
+		if err != nil {
+			if !r.openFailed {
+				log.Printf("recorder: %v, retrying every %s", err, openRetryDelay)
+				r.openFailed = true
+			}
+			r.retryAt = now.Add(openRetryDelay)
+			return
+		}
+		if r.openFailed {
+			log.Printf("recorder: recording again to %s", f.path)
+			r.openFailed = false
+		}
+		r.file = f
+		r.prune()
+	}
+
+	r.file.addPacket(pkt)
+}
+
+func (r *Recorder) open(now time.Time) (*recording, error) {
+	fh, path, err := createRecording(r.cfg.Dir, now.UTC().Format(fileTimeLayout))
+	if err != nil {
+		return nil, fmt.Errorf("create recording: %w", err)
+	}
+
+	f := &recording{
+		path:      path,
+		fh:        fh,
+		out:       bufio.NewWriter(fh),
+		serial:    rand.Uint32(),
+		preSkip:   preSkip(r.head),
+		startedAt: now.UTC(),
+		// rotate on the boundary, e.g. on the hour.
+		rotateAt: now.Truncate(r.cfg.Rotate).Add(r.cfg.Rotate),
+	}
+	f.writePage(pageBOS, 0, [][]byte{r.head})
+	f.writePage(0, 0, [][]byte{opusTags("EggsFM", "DATE="+f.startedAt.Format(time.RFC3339))})
+
+	// what's on air carries over from the last file.
+	if r.nowPlaying != nil {
+		f.cue(*r.nowPlaying)
+	} else {
+		f.writeCues()
+	}
+	return f, nil
+}
+
+// createRecording makes a new file named after base. Names only go down to the
+// second, so a file started in the same second as the last one gets -1, -2 etc.
+func createRecording(dir, base string) (*os.File, string, error) {
+	for i := 0; ; i++ {
+		name := base + ".opus"
+		if i > 0 {
+			name = fmt.Sprintf("%s-%d.opus", base, i)
+		}
+		path := filepath.Join(dir, name)
+
+		fh, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if errors.Is(err, os.ErrExist) && i < maxNameSuffix {
+			continue
+		}
+		return fh, path, err
+	}
+}
+
+func (r *Recorder) finish() {
+	if r.file == nil {
+		return
+	}
+	r.file.close()
+	r.file = nil
+}
+
+func (r *Recorder) retentionString() string {
+	var parts []string
+	if r.cfg.MaxAge > 0 {
+		parts = append(parts, r.cfg.MaxAge.String())
+	}
+	if r.cfg.MaxBytes > 0 {
+		parts = append(parts, fmt.Sprintf("%d bytes", r.cfg.MaxBytes))
+	}
+	if len(parts) == 0 {
+		return "everything"
+	}
+	return strings.Join(parts, ", at most ")
+}
+
+// recording is one file being written.
+type recording struct {
+	path      string
+	fh        *os.File
+	out       *bufio.Writer
+	failed    bool
+	serial    uint32
+	pageSeq   uint32
+	preSkip   uint64
+	granule   uint64 // samples written, pre-skip included
+	startedAt time.Time
+	rotateAt  time.Time
+	cues      []Cue
+
+	pending        [][]byte // packets for the next page
+	pendingSamples uint64
+}
+
+func (f *recording) addPacket(pkt []byte) {
+	// flush before adding, so there's always a packet left for the EOS page.
+	if f.pendingSamples >= pageSamples || lacing(f.pending)+len(pkt)/255+1 > maxPageSegments {
+		f.flushPage(0)
+	}
+
+	f.pending = append(f.pending, pkt)
+	f.pendingSamples += uint64(audio.OpusPacketDuration(pkt) * sampleRate / time.Second)
+}
+
+// flushPage writes the pending packets out as a page.
+func (f *recording) flushPage(flags byte) {
+	if len(f.pending) == 0 {
+		return
+	}
+	f.granule += f.pendingSamples
+	f.writePage(flags, f.granule, f.pending)
+	f.pending = nil
+	f.pendingSamples = 0
+}
+
+func (f *recording) writePage(flags byte, granule uint64, packets [][]byte) {
+	if f.failed {
+		return
+	}
+	page := oggPage(flags, granule, f.serial, f.pageSeq, packets)
+	f.pageSeq++
+	if _, err := f.out.Write(page); err != nil {
+		f.failed = true
+		log.Printf("recorder: writing %s: %v", f.path, err)
+	}
+}
+
+// position is how far into the file the audio has got.
+func (f *recording) position() float64 {
+	samples := f.granule + f.pendingSamples
+	if samples < f.preSkip {
+		return 0
+	}
+	return float64(samples-f.preSkip) / sampleRate
+}
+
+func (f *recording) cue(c Cue) {
+	c.Offset = f.position()
+	f.cues = append(f.cues, c)
+	f.writeCues()
+}
+
+// writeCues rewrites the cue sheet, so it's there even if we never get to
+// close the file.
+func (f *recording) writeCues() {
+	sheet := cueSheet{
+		File:      filepath.Base(f.path),
+		StartedAt: f.startedAt,
+		Duration:  f.position(),
+		Tracks:    f.cues,
+	}
+	if sheet.Tracks == nil {
+		sheet.Tracks = []Cue{}
+	}
+	raw, err := json.MarshalIndent(sheet, "", "  ")
+	if err != nil {
+		return
+	}
+
+	path := cuePath(f.path)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0o644); err != nil {
+		log.Printf("recorder: writing %s: %v", path, err)
+		return
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		log.Printf("recorder: writing %s: %v", path, err)
+	}
+}
+
+func (f *recording) close() {
+	f.flushPage(pageEOS)
+	if err := f.out.Flush(); err != nil && !f.failed {
+		log.Printf("recorder: writing %s: %v", f.path, err)
+	}
+	if err := f.fh.Close(); err != nil {
+		log.Printf("recorder: closing %s: %v", f.path, err)
+	}
+	f.writeCues()
+}
+
+func cuePath(recording string) string {
+	return strings.TrimSuffix(recording, ".opus") + ".json"
+}
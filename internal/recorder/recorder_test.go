@@ -0,0 +1,223 @@
+package recorder
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// opusHead is a stereo OpusHead with a pre-skip of 312.
+var opusHead = []byte{'O', 'p', 'u', 's', 'H', 'e', 'a', 'd', 1, 2, 0x38, 0x01, 0x80, 0xbb, 0, 0, 0, 0, 0}
+
+func readPages(t *testing.T, b []byte) [][]byte {
+	t.Helper()
+
+	var pages [][]byte
+	for len(b) > 0 {
+		if len(b) < 27 || !bytes.HasPrefix(b, []byte("OggS")) {
+			t.Fatalf("expected a page but got %q", b[:min(len(b), 8)])
+		}
+		size := 27 + int(b[26])
+		for _, lace := range b[27 : 27+int(b[26])] {
+			size += int(lace)
+		}
+		page := append([]byte(nil), b[:size]...)
+
+		crc := binary.LittleEndian.Uint32(page[22:])
+		binary.LittleEndian.PutUint32(page[22:], 0)
+		if oggCRC(page) != crc {
+			t.Fatalf("expected a valid crc on page %d", len(pages))
+		}
+		binary.LittleEndian.PutUint32(page[22:], crc)
+
+		pages = append(pages, page)
+		b = b[size:]
+	}
+	return pages
+}
+
+func TestRecorder(t *testing.T) {
+	dir := t.TempDir()
+	r, err := Start(Config{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a track as the tee would send it: its own serial and granules.
+	packet := append([]byte{0xfc}, make([]byte, 99)...)
+	var stream []byte
+	stream = append(stream, oggPage(pageBOS, 0, 7, 0, [][]byte{opusHead})...)
+	stream = append(stream, oggPage(0, 0, 7, 1, [][]byte{opusTags("track")})...)
+	for i := 0; i < 20; i++ {
+		stream = append(stream, oggPage(0, 123456789, 7, uint32(i+2), [][]byte{packet, packet, packet, packet, packet})...)
+	}
+
+	r.NowPlaying("Song", []string{"Artist"})
+	_, _ = r.Write(stream)
+	r.Close()
+
+	recs, err := recordings(dir)
+	if err != nil || len(recs) != 1 {
+		t.Fatalf("expected 1 recording but got %v (%v)", recs, err)
+	}
+	raw, err := os.ReadFile(recs[0].path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pages := readPages(t, raw)
+	if pages[0][5] != pageBOS || !bytes.Contains(pages[0], opusHead) {
+		t.Fatalf("expected the OpusHead on a BOS page")
+	}
+	if !bytes.Contains(pages[1], []byte("OpusTags")) {
+		t.Fatalf("expected OpusTags on the second page")
+	}
+	last := pages[len(pages)-1]
+	// 100 packets of 20ms.
+	if granule := binary.LittleEndian.Uint64(last[6:]); last[5] != pageEOS || granule != 100*960 {
+		t.Fatalf("expected an EOS page at granule %d but got flags %d granule %d", 100*960, last[5], granule)
+	}
+	for i, page := range pages {
+		if seq := binary.LittleEndian.Uint32(page[18:]); seq != uint32(i) {
+			t.Fatalf("expected page sequence %d but got %d", i, seq)
+		}
+	}
+
+	var sheet cueSheet
+	cues, err := os.ReadFile(cuePath(recs[0].path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(cues, &sheet); err != nil {
+		t.Fatal(err)
+	}
+	if len(sheet.Tracks) != 1 || sheet.Tracks[0].Title != "Song" || sheet.Tracks[0].Offset != 0 {
+		t.Fatalf("expected Song at 0 but got %+v", sheet.Tracks)
+	}
+	if want := float64(100*960-312) / sampleRate; sheet.Duration != want {
+		t.Fatalf("expected duration %f but got %f", want, sheet.Duration)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now().UTC()
+
+	write := func(age time.Duration, size int) string {
+		path := filepath.Join(dir, now.Add(-age).Format(fileTimeLayout)+".opus")
+		if err := os.WriteFile(path, make([]byte, size), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(cuePath(path), []byte("{}"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	old := write(48*time.Hour, 10)
+	big := write(3*time.Hour, 1000)
+	recent := write(2*time.Hour, 1000)
+	current := write(0, 1000)
+	other := filepath.Join(dir, "notes.opus")
+	if err := os.WriteFile(other, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	r := &Recorder{
+		cfg:  Config{Dir: dir, MaxAge: 24 * time.Hour, MaxBytes: 2500},
+		file: &recording{path: current},
+	}
+	r.prune()
+
+	for path, kept := range map[string]bool{
+		old:     false,
+		big:     false,
+		recent:  true,
+		current: true,
+		other:   true,
+	} {
+		if _, err := os.Stat(path); (err == nil) != kept {
+			t.Fatalf("expected %s kept=%t", filepath.Base(path), kept)
+		}
+	}
+	if _, err := os.Stat(cuePath(old)); err == nil {
+		t.Fatalf("expected the cue sheet to go with its recording")
+	}
+}
+
+func TestCreateRecording(t *testing.T) {
+	dir := t.TempDir()
+	base := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC).Format(fileTimeLayout)
+
+	var paths []string
+	for i := 0; i < 3; i++ {
+		fh, path, err := createRecording(dir, base)
+		if err != nil {
+			t.Fatalf("expected file %d to be created but got %v", i, err)
+		}
+		_ = fh.Close()
+		paths = append(paths, path)
+	}
+	for i, want := range []string{base + ".opus", base + "-1.opus", base + "-2.opus"} {
+		if filepath.Base(paths[i]) != want {
+			t.Fatalf("expected file %d to be %s but got %s", i, want, filepath.Base(paths[i]))
+		}
+	}
+
+	recs, err := recordings(dir)
+	if err != nil || len(recs) != 3 {
+		t.Fatalf("expected 3 recordings but got %v (%v)", recs, err)
+	}
+	for i, rec := range recs {
+		if rec.path != paths[i] {
+			t.Fatalf("expected recording %d to be %s but got %s", i, paths[i], rec.path)
+		}
+	}
+}
+
+func TestOpenRetry(t *testing.T) {
+	var logs strings.Builder
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	dir := filepath.Join(t.TempDir(), "gone")
+	r := &Recorder{cfg: Config{Dir: dir, Rotate: time.Hour}, head: opusHead}
+	packet := append([]byte{0xfc}, make([]byte, 99)...)
+
+	for i := 0; i < 50; i++ {
+		r.addPacket(packet)
+	}
+	if r.file != nil {
+		t.Fatalf("expected no file without a directory")
+	}
+	if n := strings.Count(logs.String(), "retrying"); n != 1 {
+		t.Fatalf("expected the failure to be logged once but got %d times:\n%s", n, logs.String())
+	}
+	if time.Until(r.retryAt) <= 0 {
+		t.Fatalf("expected a retry to be scheduled but got %v", r.retryAt)
+	}
+
+	// the disk is back, but nothing's tried before the delay is up.
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	r.addPacket(packet)
+	if r.file != nil {
+		t.Fatalf("expected to wait before trying again")
+	}
+
+	r.retryAt = time.Now()
+	r.addPacket(packet)
+	if r.file == nil {
+		t.Fatalf("expected a file once the retry was due")
+	}
+	r.finish()
+	if !strings.Contains(logs.String(), "recording again") {
+		t.Fatalf("expected the recovery to be logged but got:\n%s", logs.String())
+	}
+}
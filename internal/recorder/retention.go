@@ -0,0 +1,112 @@
+package recorder
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type archived struct {
+	path      string
+	startedAt time.Time
+	suffix    int   // -N for files started in the same second
+	size      int64 // recording + cue sheet
+}
+
+// recordings lists what's in dir, oldest first. Only files named the way we
+// name them count, nothing else in there is touched.
+func recordings(dir string) ([]archived, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []archived
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, ".opus") {
+			continue
+		}
+		startedAt, suffix, ok := parseRecordingName(name)
+		if !ok {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+
+		path := filepath.Join(dir, name)
+		size := info.Size()
+		if cue, err := os.Stat(cuePath(path)); err == nil {
+			size += cue.Size()
+		}
+		out = append(out, archived{path: path, startedAt: startedAt, suffix: suffix, size: size})
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		if !out[i].startedAt.Equal(out[j].startedAt) {
+			return out[i].startedAt.Before(out[j].startedAt)
+		}
+		return out[i].suffix < out[j].suffix
+	})
+	return out, nil
+}
+
+// parseRecordingName reads the start time back out of a recording's name,
+// with or without a -N suffix.
+func parseRecordingName(name string) (startedAt time.Time, suffix int, ok bool) {
+	base, ok := strings.CutSuffix(name, ".opus")
+	if !ok {
+		return time.Time{}, 0, false
+	}
+	if i := strings.LastIndex(base, "Z-"); i >= 0 {
+		if n, err := strconv.Atoi(base[i+2:]); err == nil && n > 0 {
+			base, suffix = base[:i+1], n
+		}
+	}
+	startedAt, err := time.Parse(fileTimeLayout, base)
+	return startedAt, suffix, err == nil
+}
+
+// prune deletes the recordings past MaxAge, then the oldest ones until the
+// rest fit in MaxBytes. The file being written is never deleted.
+func (r *Recorder) prune() {
+	if r.cfg.MaxAge <= 0 && r.cfg.MaxBytes <= 0 {
+		return
+	}
+
+	all, err := recordings(r.cfg.Dir)
+	if err != nil {
+		log.Printf("recorder: listing %s: %v", r.cfg.Dir, err)
+		return
+	}
+
+	var total int64
+	for _, a := range all {
+		total += a.size
+	}
+
+	now := time.Now()
+	for _, a := range all {
+		if r.file != nil && a.path == r.file.path {
+			continue
+		}
+		tooOld := r.cfg.MaxAge > 0 && now.Sub(a.startedAt) > r.cfg.MaxAge
+		tooBig := r.cfg.MaxBytes > 0 && total > r.cfg.MaxBytes
+		if !tooOld && !tooBig {
+			continue
+		}
+
+		if err := os.Remove(a.path); err != nil && !os.IsNotExist(err) {
+			log.Printf("recorder: removing %s: %v", a.path, err)
+			continue
+		}
+		_ = os.Remove(cuePath(a.path))
+		total -= a.size
+	}
+}
@@ -0,0 +1,301 @@
+package schedule
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Config is the on-disk (JSON) schedule format.
+//
+//	{
+//	  "timezone": "America/Toronto",
+//	  "default": "media",
+//	  "slots": [
+//	    {"name": "Morning", "days": ["weekdays"], "start": "06:00", "end": "09:00", "source": "lists/morning.m3u8"},
+//	    {"name": "Show Y", "days": ["fri"], "start": "20:00", "end": "22:00", "source": "shows/y", "switch": "immediate"}
+//	  ]
+//	}
+type Config struct {
+	Timezone string       `json:"timezone"`
+	Default  string       `json:"default"`
+	Slots    []SlotConfig `json:"slots"`
+}
+
+// SlotConfig is a recurring time slot. End before Start means the slot runs
+// past midnight; Days always refers to the day the slot starts on.
+type SlotConfig struct {
+	Name   string   `json:"name"`
+	Days   []string `json:"days"`
+	Start  string   `json:"start"`
+	End    string   `json:"end"`
+	Source string   `json:"source"`
+	Switch string   `json:"switch"`
+}
+
+const (
+	SwitchTrackEnd  = "track_end"
+	SwitchImmediate = "immediate"
+)
+
+// Program is what should be on air at a given time.
+type Program struct {
+	Name   string
+	Source string
+	// Immediate programs cut the current track at the slot boundary instead of
+	// waiting for it to finish.
+	Immediate bool
+}
+
+type slot struct {
+	name      string
+	days      [7]bool
+	start     time.Duration // offset from local midnight
+	end       time.Duration
+	source    string
+	immediate bool
+}
+
+// Schedule resolves the active program for a point in time.
+type Schedule struct {
+	loc   *time.Location
+	def   Program
+	slots []slot
+}
+
+// Load reads a schedule file. Relative sources are resolved against the
+// schedule file's own directory.
+func Load(path string) (*Schedule, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg Config
+	if err := json.Unmarshal(raw, &cfg); err != nil {
+		return nil, fmt.Errorf("parse schedule %q: %w", path, err)
+	}
+
+	baseDir := filepath.Dir(path)
+	resolve := func(src string) string {
+		src = strings.TrimSpace(src)
+		if src == "" || filepath.IsAbs(src) {
+			return src
+		}
+		return filepath.Join(baseDir, src)
+	}
+
+	cfg.Default = resolve(cfg.Default)
+	for i := range cfg.Slots {
+		cfg.Slots[i].Source = resolve(cfg.Slots[i].Source)
+	}
+
+	return New(cfg)
+}
+
+// New validates cfg and builds a Schedule from it.
+func New(cfg Config) (*Schedule, error) {
+	loc := time.Local
+	if tz := strings.TrimSpace(cfg.Timezone); tz != "" {
+		l, err := time.LoadLocation(tz)
+		if err != nil {
+			return nil, fmt.Errorf("schedule timezone: %w", err)
+		}
+		loc = l
+	}
+
+	s := &Schedule{
+		loc: loc,
+		def: Program{Name: "default", Source: strings.TrimSpace(cfg.Default)},
+	}
+
+	for i, sc := range cfg.Slots {
+		name := strings.TrimSpace(sc.Name)
+		if name == "" {
+			name = fmt.Sprintf("slot %d", i+1)
+		}
+
+		sl := slot{
+			name:   name,
+			source: strings.TrimSpace(sc.Source),
+		}
+		if sl.source == "" {
+			return nil, fmt.Errorf("schedule slot %q: source is required", name)
+		}
+
+		var err error
+		if sl.start, err = parseClock(sc.Start); err != nil {
+			return nil, fmt.Errorf("schedule slot %q: start: %w", name, err)
+		}
+		if sl.end, err = parseClock(sc.End); err != nil {
+			return nil, fmt.Errorf("schedule slot %q: end: %w", name, err)
+		}
+		if sl.start == sl.end {
+			return nil, fmt.Errorf("schedule slot %q: start and end are the same", name)
+		}
+
+		if sl.days, err = parseDays(sc.Days); err != nil {
+			return nil, fmt.Errorf("schedule slot %q: %w", name, err)
+		}
+
+		switch strings.ToLower(strings.TrimSpace(sc.Switch)) {
+		case "", SwitchTrackEnd:
+		case SwitchImmediate:
+			sl.immediate = true
+		default:
+			return nil, fmt.Errorf("schedule slot %q: unknown switch mode %q", name, sc.Switch)
+		}
+
+		s.slots = append(s.slots, sl)
+	}
+
+	return s, nil
+}
+
+// Location returns the timezone the schedule is evaluated in.
+func (s *Schedule) Location() *time.Location {
+	return s.loc
+}
+
+// At returns the program that is on air at t. Earlier slots in the file win
+// when slots overlap. Outside every slot the default program is returned;
+// its Source is empty if the schedule has no default.
+func (s *Schedule) At(t time.Time) Program {
+	if sl := s.slotAt(t); sl != nil {
+		return Program{Name: sl.name, Source: sl.source, Immediate: sl.immediate}
+	}
+	return s.def
+}
+
+// Boundary reports whether crossing from a program to another at the slot
+// boundary should cut the current track: true when either side is immediate.
+func Boundary(from, to Program) (changed bool, immediate bool) {
+	if from.Source == to.Source && from.Name == to.Name {
+		return false, false
+	}
+	return true, from.Immediate || to.Immediate
+}
+
+// NextBoundary returns the first slot start/end strictly after t.
+// It returns the zero time if the schedule has no slots.
+func (s *Schedule) NextBoundary(t time.Time) time.Time {
+	local := t.In(s.loc)
+	y, m, d := local.Date()
+
+	var next time.Time
+	for dayOff := -1; dayOff <= 7; dayOff++ {
+		day := time.Date(y, m, d+dayOff, 0, 0, 0, 0, s.loc)
+		for _, sl := range s.slots {
+			if !sl.days[day.Weekday()] {
+				continue
+			}
+			start, end := sl.window(day)
+			for _, b := range [2]time.Time{start, end} {
+				if b.After(t) && (next.IsZero() || b.Before(next)) {
+					next = b
+				}
+			}
+		}
+	}
+
+	return next
+}
+
+func (s *Schedule) slotAt(t time.Time) *slot {
+	local := t.In(s.loc)
+	y, m, d := local.Date()
+	today := time.Date(y, m, d, 0, 0, 0, 0, s.loc)
+	yesterday := time.Date(y, m, d-1, 0, 0, 0, 0, s.loc)
+
+	for i := range s.slots {
+		sl := &s.slots[i]
+		// a slot that wraps past midnight may have started yesterday
+		for _, day := range [2]time.Time{today, yesterday} {
+			if !sl.days[day.Weekday()] {
+				continue
+			}
+			start, end := sl.window(day)
+			if !t.Before(start) && t.Before(end) {
+				return sl
+			}
+		}
+	}
+
+	return nil
+}
+
+// window returns the absolute start/end of the slot when it starts on day.
+// Clock times go through time.Date so DST shifts are handled by the location.
+func (sl *slot) window(day time.Time) (time.Time, time.Time) {
+	y, m, d := day.Date()
+	loc := day.Location()
+	at := func(dayOff int, off time.Duration) time.Time {
+		return time.Date(y, m, d+dayOff, int(off/time.Hour), int(off%time.Hour/time.Minute), 0, 0, loc)
+	}
+
+	start := at(0, sl.start)
+	if sl.end > sl.start {
+		return start, at(0, sl.end)
+	}
+	return start, at(1, sl.end)
+}
+
+func parseClock(raw string) (time.Duration, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "24:00" {
+		return 0, nil
+	}
+	t, err := time.Parse("15:04", raw)
+	if err != nil {
+		return 0, fmt.Errorf("invalid clock time %q (want HH:MM)", raw)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+var dayNames = map[string][]time.Weekday{
+	"sun":      {time.Sunday},
+	"mon":      {time.Monday},
+	"tue":      {time.Tuesday},
+	"wed":      {time.Wednesday},
+	"thu":      {time.Thursday},
+	"fri":      {time.Friday},
+	"sat":      {time.Saturday},
+	"weekdays": {time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday},
+	"weekends": {time.Saturday, time.Sunday},
+	"daily":    {time.Sunday, time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday, time.Saturday},
+}
+
+var errUnknownDay = errors.New("unknown day")
+
+func parseDays(raw []string) ([7]bool, error) {
+	var days [7]bool
+	if len(raw) == 0 {
+		for i := range days {
+			days[i] = true
+		}
+		return days, nil
+	}
+
+	for _, r := range raw {
+		key := strings.ToLower(strings.TrimSpace(r))
+		// accept full names too ("friday" -> "fri")
+		if len(key) > 3 {
+			if _, ok := dayNames[key]; !ok {
+				key = key[:3]
+			}
+		}
+		wds, ok := dayNames[key]
+		if !ok {
+			return days, fmt.Errorf("%w %q", errUnknownDay, r)
+		}
+		for _, wd := range wds {
+			days[wd] = true
+		}
+	}
+
+	return days, nil
+}
@@ -0,0 +1,84 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScheduleAt(t *testing.T) {
+	s, err := New(Config{
+		Timezone: "America/Toronto",
+		Default:  "media",
+		Slots: []SlotConfig{
+			{Name: "Morning", Days: []string{"weekdays"}, Start: "06:00", End: "09:00", Source: "morning.m3u8"},
+			{Name: "Late", Days: []string{"friday"}, Start: "22:00", End: "02:00", Source: "late", Switch: "immediate"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("did not expect an error but got %v", err)
+	}
+
+	loc := s.Location()
+	tests := []struct {
+		name     string
+		at       time.Time
+		expected string
+	}{
+		{"Weekday Morning", time.Date(2026, 10, 14, 7, 30, 0, 0, loc), "Morning"},
+		{"Weekday Morning End Is Exclusive", time.Date(2026, 10, 14, 9, 0, 0, 0, loc), "default"},
+		{"Weekend Morning", time.Date(2026, 10, 17, 7, 30, 0, 0, loc), "default"},
+		{"Friday Late", time.Date(2026, 10, 16, 23, 0, 0, 0, loc), "Late"},
+		{"Friday Late Wraps Into Saturday", time.Date(2026, 10, 17, 1, 59, 0, 0, loc), "Late"},
+		{"Thursday Late Does Not Wrap", time.Date(2026, 10, 16, 1, 0, 0, 0, loc), "default"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := s.At(tt.at).Name; got != tt.expected {
+				t.Fatalf("expected %q but got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestScheduleNextBoundary(t *testing.T) {
+	s, err := New(Config{
+		Timezone: "UTC",
+		Slots: []SlotConfig{
+			{Name: "Morning", Days: []string{"daily"}, Start: "06:00", End: "09:00", Source: "x"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("did not expect an error but got %v", err)
+	}
+
+	from := time.Date(2026, 10, 14, 7, 0, 0, 0, time.UTC)
+	if got, expected := s.NextBoundary(from), time.Date(2026, 10, 14, 9, 0, 0, 0, time.UTC); !got.Equal(expected) {
+		t.Fatalf("expected %s but got %s", expected, got)
+	}
+
+	from = time.Date(2026, 10, 14, 9, 0, 0, 0, time.UTC)
+	if got, expected := s.NextBoundary(from), time.Date(2026, 10, 15, 6, 0, 0, 0, time.UTC); !got.Equal(expected) {
+		t.Fatalf("expected %s but got %s", expected, got)
+	}
+}
+
+func TestScheduleInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		slot SlotConfig
+	}{
+		{"Missing Source", SlotConfig{Start: "06:00", End: "07:00"}},
+		{"Bad Clock", SlotConfig{Start: "6am", End: "07:00", Source: "x"}},
+		{"Bad Day", SlotConfig{Days: []string{"someday"}, Start: "06:00", End: "07:00", Source: "x"}},
+		{"Bad Switch", SlotConfig{Start: "06:00", End: "07:00", Source: "x", Switch: "sometimes"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New(Config{Slots: []SlotConfig{tt.slot}}); err == nil {
+				t.Fatalf("expected an error but got none")
+			}
+		})
+	}
+}
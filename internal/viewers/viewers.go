@@ -3,6 +3,7 @@ package viewers
 import (
 	"crypto/sha256"
 	"encoding/hex"
+	"log"
 	"net"
 	"net/http"
 	"os"
@@ -17,16 +18,19 @@ type Protocol string
 const (
 	ProtocolHLS     Protocol = "hls"
 	ProtocolIcecast Protocol = "icecast"
+	ProtocolOpus    Protocol = "opus"
 )
 
 type ProtocolCounts struct {
 	HLS     int `json:"hls"`
 	Icecast int `json:"icecast"`
+	Opus    int `json:"opus"`
 }
 
 const (
 	defaultHLSTTL       = 45 * time.Second
 	defaultIcecastTTL   = 0
+	defaultOpusTTL      = 0
 	defaultCleanupEvery = 30 * time.Second
 )
 
@@ -34,9 +38,11 @@ type tracker struct {
 	mu           sync.Mutex
 	entries      map[Protocol]map[string]*viewerEntry
 	ttl          map[Protocol]time.Duration
+	peak         map[Protocol]int
 	lastCleanup  time.Time
 	cleanupEvery time.Duration
 	hashSalt     []byte
+	trusted      []*net.IPNet // proxies whose forwarding headers we believe
 }
 
 type viewerEntry struct {
@@ -58,19 +64,79 @@ func Counts() ProtocolCounts {
 	return defaultTracker.counts()
 }
 
+// Peaks returns the most listeners each protocol has had at once.
+func Peaks() ProtocolCounts {
+	return defaultTracker.peaks()
+}
+
+// ClientID returns the salted hash of the request's client IP, or "" if it has
+// none. It's stable per listener, so it can key rate limits without storing IPs.
+// Forwarding headers only count when they come from TRUSTED_PROXIES.
+func ClientID(r *http.Request) string {
+	return defaultTracker.hashIP(defaultTracker.clientIP(r))
+}
+
 func newTracker() *tracker {
 	return &tracker{
 		entries: map[Protocol]map[string]*viewerEntry{
 			ProtocolHLS:     {},
 			ProtocolIcecast: {},
+			ProtocolOpus:    {},
 		},
 		ttl: map[Protocol]time.Duration{
 			ProtocolHLS:     parseDurationEnv("VIEWER_TTL_HLS", defaultHLSTTL),
 			ProtocolIcecast: parseDurationEnv("VIEWER_TTL_ICECAST", defaultIcecastTTL),
+			ProtocolOpus:    parseDurationEnv("VIEWER_TTL_OPUS", defaultOpusTTL),
 		},
+		peak:         map[Protocol]int{},
 		cleanupEvery: defaultCleanupEvery,
 		hashSalt:     []byte(os.Getenv("VIEWER_HASH_SALT")),
+		trusted:      parseTrustedProxies(os.Getenv("TRUSTED_PROXIES")),
+	}
+}
+
+// parseTrustedProxies reads a comma separated list of IPs and CIDRs.
+func parseTrustedProxies(raw string) []*net.IPNet {
+	var out []*net.IPNet
+	for _, field := range strings.Split(raw, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		if !strings.Contains(field, "/") {
+			ip := net.ParseIP(field)
+			if ip == nil {
+				log.Printf("ignoring invalid TRUSTED_PROXIES entry %q", field)
+				continue
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			out = append(out, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(field)
+		if err != nil {
+			log.Printf("ignoring invalid TRUSTED_PROXIES entry %q", field)
+			continue
+		}
+		out = append(out, network)
 	}
+	return out
+}
+
+func (t *tracker) isTrusted(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, network := range t.trusted {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+	return false
 }
 
 func (t *tracker) trackRequest(protocol Protocol, r *http.Request) {
@@ -80,7 +146,7 @@ func (t *tracker) trackRequest(protocol Protocol, r *http.Request) {
 	if r.Method != http.MethodGet && r.Method != http.MethodHead {
 		return
 	}
-	ip := clientIP(r)
+	ip := viewerIP(r)
 	if ip == "" {
 		return
 	}
@@ -91,8 +157,11 @@ func (t *tracker) trackRequest(protocol Protocol, r *http.Request) {
 
 	now := time.Now()
 	t.mu.Lock()
-	entry := t.getEntry(protocol, hash)
+	entry, added := t.getEntry(protocol, hash)
 	entry.lastSeen = now
+	if added {
+		t.notePeakLocked(protocol, now)
+	}
 	t.maybeCleanupLocked(now)
 	t.mu.Unlock()
 }
@@ -104,7 +173,7 @@ func (t *tracker) trackConnection(protocol Protocol, r *http.Request) func() {
 	if r.Method != http.MethodGet {
 		return func() {}
 	}
-	ip := clientIP(r)
+	ip := viewerIP(r)
 	if ip == "" {
 		return func() {}
 	}
@@ -115,9 +184,12 @@ func (t *tracker) trackConnection(protocol Protocol, r *http.Request) func() {
 
 	now := time.Now()
 	t.mu.Lock()
-	entry := t.getEntry(protocol, hash)
+	entry, added := t.getEntry(protocol, hash)
 	entry.active++
 	entry.lastSeen = now
+	if added {
+		t.notePeakLocked(protocol, now)
+	}
 	t.maybeCleanupLocked(now)
 	t.mu.Unlock()
 
@@ -145,13 +217,30 @@ func (t *tracker) counts() ProtocolCounts {
 	t.mu.Lock()
 	hls := t.countLocked(ProtocolHLS, now)
 	icecast := t.countLocked(ProtocolIcecast, now)
+	opus := t.countLocked(ProtocolOpus, now)
 	t.mu.Unlock()
 	return ProtocolCounts{
 		HLS:     hls,
 		Icecast: icecast,
+		Opus:    opus,
 	}
 }
 
+func (t *tracker) peaks() ProtocolCounts {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return ProtocolCounts{
+		HLS:     t.peak[ProtocolHLS],
+		Icecast: t.peak[ProtocolIcecast],
+		Opus:    t.peak[ProtocolOpus],
+	}
+}
+
+// notePeakLocked raises the protocol's peak after a new listener shows up.
+func (t *tracker) notePeakLocked(protocol Protocol, now time.Time) {
+	t.peak[protocol] = max(t.peak[protocol], t.countLocked(protocol, now))
+}
+
 func (t *tracker) countLocked(protocol Protocol, now time.Time) int {
 	entries := t.entries[protocol]
 	ttl := t.ttl[protocol]
@@ -180,7 +269,8 @@ func (t *tracker) countLocked(protocol Protocol, now time.Time) int {
 	return count
 }
 
-func (t *tracker) getEntry(protocol Protocol, hash string) *viewerEntry {
+// getEntry returns the listener's entry, and whether it had to be added.
+func (t *tracker) getEntry(protocol Protocol, hash string) (*viewerEntry, bool) {
 	entries := t.entries[protocol]
 	if entries == nil {
 		entries = map[string]*viewerEntry{}
@@ -190,8 +280,9 @@ func (t *tracker) getEntry(protocol Protocol, hash string) *viewerEntry {
 	if entry == nil {
 		entry = &viewerEntry{}
 		entries[hash] = entry
+		return entry, true
 	}
-	return entry
+	return entry, false
 }
 
 func (t *tracker) maybeCleanupLocked(now time.Time) {
@@ -219,18 +310,21 @@ func (t *tracker) hashIP(ip string) string {
 	return hex.EncodeToString(h.Sum(nil))
 }
 
-func clientIP(r *http.Request) string {
+// viewerIP is the address viewers are counted by. A made up header only
+// skews the counts, so like always the first forwarded address is taken,
+// from anyone.
+func viewerIP(r *http.Request) string {
 	if r == nil {
 		return ""
 	}
 	if forwarded := r.Header.Get("Forwarded"); forwarded != "" {
-		if ip := parseForwardedFor(forwarded); ip != "" {
-			return ip
+		if hops := parseForwardedFor(forwarded); len(hops) > 0 && hops[0] != "" {
+			return hops[0]
 		}
 	}
 	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
-		if ip := headerFirst(xff); ip != "" {
-			return ip
+		if hops := splitHops(xff); len(hops) > 0 && hops[0] != "" {
+			return hops[0]
 		}
 	}
 	if xr := strings.TrimSpace(r.Header.Get("X-Real-IP")); xr != "" {
@@ -242,42 +336,88 @@ func clientIP(r *http.Request) string {
 	return normalizeIP(r.RemoteAddr)
 }
 
-func parseForwardedFor(value string) string {
-	if value == "" {
+// clientIP is the address the request came from, for request limits and votes. Anyone can send forwarding
+// headers, so they're only read when the connection is from a trusted proxy,
+// and then only up to the first hop that isn't one.
+func (t *tracker) clientIP(r *http.Request) string {
+	if r == nil {
 		return ""
 	}
-	parts := strings.Split(value, ",")
-	for _, part := range parts {
+	remote := normalizeIP(r.RemoteAddr)
+	if !t.isTrusted(remote) {
+		return remote
+	}
+
+	if forwarded := r.Header.Values("Forwarded"); len(forwarded) > 0 {
+		if ip := t.lastUntrusted(parseForwardedFor(strings.Join(forwarded, ","))); ip != "" {
+			return ip
+		}
+	}
+	if xff := r.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+		if ip := t.lastUntrusted(splitHops(strings.Join(xff, ","))); ip != "" {
+			return ip
+		}
+	}
+	if xr := strings.TrimSpace(r.Header.Get("X-Real-IP")); xr != "" {
+		if ip := normalizeIP(xr); ip != "" {
+			return ip
+		}
+	}
+
+	return remote
+}
+
+// lastUntrusted walks the hops back from our side and returns the first one
+// that isn't a trusted proxy, the earlier ones could have been made up.
+func (t *tracker) lastUntrusted(hops []string) string {
+	for i := len(hops) - 1; i >= 0; i-- {
+		if hops[i] == "" {
+			// garbage in the chain, nothing before it can be trusted.
+			return ""
+		}
+		if !t.isTrusted(hops[i]) {
+			return hops[i]
+		}
+	}
+	if len(hops) > 0 {
+		return hops[0]
+	}
+	return ""
+}
+
+// parseForwardedFor returns the for= address of every element of a
+// Forwarded header, in order ("" where it isn't an IP).
+func parseForwardedFor(value string) []string {
+	var out []string
+	for _, part := range strings.Split(value, ",") {
 		part = strings.TrimSpace(part)
 		if part == "" {
 			continue
 		}
+		ip := ""
 		for _, pair := range strings.Split(part, ";") {
 			pair = strings.TrimSpace(pair)
 			if !strings.HasPrefix(strings.ToLower(pair), "for=") {
 				continue
 			}
-			raw := strings.TrimSpace(pair[4:])
-			raw = strings.Trim(raw, "\"")
-			raw = strings.TrimPrefix(raw, "[")
-			raw = strings.TrimSuffix(raw, "]")
-			if ip := normalizeIP(raw); ip != "" {
-				return ip
-			}
+			ip = normalizeIP(strings.Trim(strings.TrimSpace(pair[4:]), "\""))
+			break
 		}
-		break
+		out = append(out, ip)
 	}
-	return ""
+	return out
 }
 
-func headerFirst(value string) string {
-	if value == "" {
-		return ""
-	}
-	if idx := strings.Index(value, ","); idx >= 0 {
-		value = value[:idx]
+// splitHops returns every address of an X-Forwarded-For header, in order.
+func splitHops(value string) []string {
+	var out []string
+	for _, hop := range strings.Split(value, ",") {
+		if strings.TrimSpace(hop) == "" {
+			continue
+		}
+		out = append(out, normalizeIP(hop))
 	}
-	return normalizeIP(value)
+	return out
 }
 
 func normalizeIP(value string) string {
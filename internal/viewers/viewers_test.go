@@ -0,0 +1,126 @@
+package viewers
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name     string
+		trusted  string
+		remote   string
+		headers  map[string]string
+		expected string
+	}{
+		{
+			name:     "Remote Address",
+			remote:   "203.0.113.7:51234",
+			expected: "203.0.113.7",
+		},
+		{
+			name:     "Headers Ignored Without Trusted Proxies",
+			remote:   "203.0.113.7:51234",
+			headers:  map[string]string{"X-Forwarded-For": "198.51.100.1", "X-Real-IP": "198.51.100.2", "Forwarded": "for=198.51.100.3"},
+			expected: "203.0.113.7",
+		},
+		{
+			name:     "Headers Ignored From An Untrusted Peer",
+			trusted:  "127.0.0.1",
+			remote:   "203.0.113.7:51234",
+			headers:  map[string]string{"X-Forwarded-For": "198.51.100.1"},
+			expected: "203.0.113.7",
+		},
+		{
+			name:     "X-Forwarded-For From A Trusted Proxy",
+			trusted:  "127.0.0.1",
+			remote:   "127.0.0.1:40000",
+			headers:  map[string]string{"X-Forwarded-For": "198.51.100.1"},
+			expected: "198.51.100.1",
+		},
+		{
+			name:     "Spoofed Hops Before The Real Client",
+			trusted:  "127.0.0.1, 10.0.0.0/8",
+			remote:   "127.0.0.1:40000",
+			headers:  map[string]string{"X-Forwarded-For": "1.2.3.4, 198.51.100.1, 10.1.2.3"},
+			expected: "198.51.100.1",
+		},
+		{
+			name:     "Forwarded Header",
+			trusted:  "::1",
+			remote:   "[::1]:40000",
+			headers:  map[string]string{"Forwarded": `for=1.2.3.4, for="[2001:db8::1]:4711";proto=https`},
+			expected: "2001:db8::1",
+		},
+		{
+			name:     "X-Real-IP From A Trusted Proxy",
+			trusted:  "127.0.0.0/8",
+			remote:   "127.0.0.1:40000",
+			headers:  map[string]string{"X-Real-IP": "198.51.100.2"},
+			expected: "198.51.100.2",
+		},
+		{
+			name:     "Garbage Hop",
+			trusted:  "127.0.0.1",
+			remote:   "127.0.0.1:40000",
+			headers:  map[string]string{"X-Forwarded-For": "198.51.100.1, nonsense"},
+			expected: "127.0.0.1",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tr := newTracker()
+			tr.trusted = parseTrustedProxies(tc.trusted)
+
+			r, _ := http.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tc.remote
+			for k, v := range tc.headers {
+				r.Header.Set(k, v)
+			}
+
+			if got := tr.clientIP(r); got != tc.expected {
+				t.Fatalf("expected %q but got %q", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestViewersBehindProxy(t *testing.T) {
+	t.Setenv("TRUSTED_PROXIES", "")
+	tr := newTracker()
+
+	// without TRUSTED_PROXIES listeners behind nginx still count apart.
+	for _, ip := range []string{"198.51.100.1", "198.51.100.2", "198.51.100.2"} {
+		r, _ := http.NewRequest(http.MethodGet, "/api/hls/live.m3u8", nil)
+		r.RemoteAddr = "127.0.0.1:40000"
+		r.Header.Set("X-Forwarded-For", ip+", 127.0.0.1")
+		tr.trackRequest(ProtocolHLS, r)
+	}
+	if got := tr.counts().HLS; got != 2 {
+		t.Fatalf("expected 2 hls listeners but got %d", got)
+	}
+}
+
+func TestPeaks(t *testing.T) {
+	tr := newTracker()
+	connect := func(ip string) func() {
+		r, _ := http.NewRequest(http.MethodGet, "/api/stream.opus", nil)
+		r.RemoteAddr = ip + ":1234"
+		return tr.trackConnection(ProtocolOpus, r)
+	}
+
+	stopA := connect("198.51.100.1")
+	stopB := connect("198.51.100.2")
+	stopA()
+	stopB()
+	stopC := connect("198.51.100.3")
+	defer stopC()
+
+	if counts := tr.counts(); counts.Opus != 1 {
+		t.Fatalf("expected 1 opus listener but got %d", counts.Opus)
+	}
+	if peaks := tr.peaks(); peaks.Opus != 2 || peaks.Icecast != 0 {
+		t.Fatalf("expected an opus peak of 2 and no icecast peak but got %d and %d", peaks.Opus, peaks.Icecast)
+	}
+}
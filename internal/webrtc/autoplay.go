@@ -19,6 +19,9 @@ import (
 	"time"
 
 	"github.com/philipch07/EggsFM/internal/audio"
+	"github.com/philipch07/EggsFM/internal/library"
+	"github.com/philipch07/EggsFM/internal/playlist"
+	"github.com/philipch07/EggsFM/internal/schedule"
 	"github.com/pion/webrtc/v4"
 	"github.com/pion/webrtc/v4/pkg/media"
 	"github.com/pion/webrtc/v4/pkg/media/oggreader"
@@ -33,13 +36,22 @@ var (
 	autoplayState struct {
 		mu       sync.Mutex
 		running  bool
-		mediaDir string
+		source   autoplaySource
 		stop     chan struct{}
 		done     chan struct{}
 		writer   *sampleWriter
+		watcher  *library.Watcher
+		reloaded []TrackMeta // picked up by the loop at the next track boundary
+
+		// set when playing from a schedule; active is the program on air right now.
+		schedule  *schedule.Schedule
+		active    autoplaySource
+		program   string
+		interrupt chan struct{}
 	}
 
-	errAutoplayStopped = errors.New("autoplay stopped")
+	errAutoplayStopped  = errors.New("autoplay stopped")
+	errTrackInterrupted = errors.New("track interrupted")
 )
 
 type sampleWriter struct {
@@ -99,24 +111,105 @@ func (w *sampleWriter) drain() {
 	}
 }
 
-// StartAutoplayFromMediaDir loads all .opus files from mediaDir and begins the stream
+// autoplaySource is where the autoplay loop gets its tracks from:
+// either every playable file in a media dir or the entries of a playlist file.
+type autoplaySource struct {
+	mediaDir     string
+	playlistFile string
+}
+
+func (a autoplaySource) isZero() bool {
+	return a.mediaDir == "" && a.playlistFile == ""
+}
+
+func (a autoplaySource) String() string {
+	if a.playlistFile != "" {
+		return a.playlistFile
+	}
+	return a.mediaDir
+}
+
+func (a autoplaySource) load() ([]TrackMeta, error) {
+	if a.playlistFile != "" {
+		return LoadPlaylistFile(a.playlistFile)
+	}
+	return LoadOpusPlaylist(a.mediaDir)
+}
+
+// baseDir is the source's own folder: the media dir, or the playlist's folder.
+func (a autoplaySource) baseDir() string {
+	if a.playlistFile != "" {
+		return filepath.Dir(a.playlistFile)
+	}
+	return a.mediaDir
+}
+
+// watchRoots are the directories whose changes should trigger a reload.
+// For playlists that is the playlist's folder plus the folder of every entry,
+// wherever they live.
+func (a autoplaySource) watchRoots() []string {
+	roots := []string{a.baseDir()}
+	if a.playlistFile == "" {
+		return roots
+	}
+
+	entries, err := playlist.Load(a.playlistFile)
+	if err != nil {
+		return roots
+	}
+	for _, e := range entries {
+		roots = append(roots, filepath.Dir(e.Path))
+	}
+	return roots
+}
+
+// StartAutoplayFromMediaDir loads all playable files from mediaDir and begins the stream
 // it also loops the playlist (all the files) indefinitely.
 func StartAutoplayFromMediaDir(mediaDir string) error {
 	if mediaDir == "" {
 		mediaDir = "media"
 	}
 
+	return startAutoplay(autoplaySource{mediaDir: mediaDir})
+}
+
+// StartAutoplayFromPlaylist loads the playable entries of an M3U/M3U8, PLS or XSPF
+// playlist and loops them indefinitely in playlist order.
+func StartAutoplayFromPlaylist(playlistPath string) error {
+	if strings.TrimSpace(playlistPath) == "" {
+		return errors.New("playlist path is required")
+	}
+
+	return startAutoplay(autoplaySource{playlistFile: playlistPath})
+}
+
+func startAutoplay(source autoplaySource) error {
 	track, err := GetAudioTrack()
 	if err != nil {
 		return err
 	}
 
-	playlist, err := LoadOpusPlaylist(mediaDir)
+	autoplayState.mu.Lock()
+	sched := autoplayState.schedule
+	autoplayState.mu.Unlock()
+
+	// with a schedule, start on whatever program is on air right now.
+	active, program := source, ""
+	if sched != nil {
+		active, program = resolveProgram(sched, source, time.Now())
+	}
+
+	playlist, err := loadWhenPlayable(active)
+	if err != nil && active != source {
+		log.Printf("autoplay: unable to load program %q (%v); falling back to %q", program, err, source)
+		active, program = source, ""
+		playlist, err = loadWhenPlayable(active)
+	}
 	if err != nil {
 		return err
 	}
 	if len(playlist) == 0 {
-		return fmt.Errorf("no .opus tracks found in %q", mediaDir)
+		return fmt.Errorf("no playable tracks found in %q", active)
 	}
 
 	autoplayState.mu.Lock()
@@ -125,51 +218,94 @@ func StartAutoplayFromMediaDir(mediaDir string) error {
 		return nil
 	}
 	autoplayState.running = true
-	autoplayState.mediaDir = mediaDir
+	autoplayState.source = source
 	stop := make(chan struct{})
 	done := make(chan struct{})
+	interrupt := make(chan struct{}, 1)
 	writer := newSampleWriter(track)
 	autoplayState.stop = stop
 	autoplayState.done = done
 	autoplayState.writer = writer
+	autoplayState.interrupt = interrupt
+	autoplayState.reloaded = nil
+	autoplayState.active = active
+	autoplayState.program = program
+	autoplayState.watcher = watchAutoplaySource(active)
 	autoplayState.mu.Unlock()
 
-	log.Printf("Loaded %d track(s) from %q", len(playlist), mediaDir)
+	if program != "" {
+		log.Printf("Loaded %d track(s) from %q for program %q", len(playlist), active, program)
+	} else {
+		log.Printf("Loaded %d track(s) from %q", len(playlist), active)
+	}
+
+	initPlayOrder()
+	ordered := orderPlaylist(playlist)
+	startIndex, startOffset := initialPosition(active, ordered)
 
 	// Publish + log the first track immediately on start
-	first := playlist[0]
+	first := ordered[startIndex]
 	log.Printf("Now playing: %q", filepath.Base(first.Path))
 	PublishNowPlaying(first.Title, first.Artists)
 
+	if sched != nil {
+		go watchScheduleBoundaries(sched, source, stop)
+	}
+	go persistPlaybackState(stop)
+
 	go func() {
-		autoplayPlaylistLoop(playlist, writer, stop)
+		autoplayPlaylistLoop(source, active, playlist, ordered, startIndex, startOffset, writer, stop, interrupt)
+		savePlaybackState()
 		close(done)
 	}()
 
 	return nil
 }
 
-// RestartAutoplay stops the current autoplay loop (if any) and starts it again.
+// RestartAutoplay stops the current autoplay loop (if any) and starts it again
+// from the same media dir or playlist file.
 func RestartAutoplay() error {
-	return RestartAutoplayFromMediaDir("")
+	return restartAutoplay(autoplaySource{})
 }
 
 // RestartAutoplayFromMediaDir restarts autoplay using the specified media dir.
 func RestartAutoplayFromMediaDir(mediaDir string) error {
+	return restartAutoplay(autoplaySource{mediaDir: mediaDir})
+}
+
+// RestartAutoplayFromPlaylist restarts autoplay using the specified playlist file.
+func RestartAutoplayFromPlaylist(playlistPath string) error {
+	return restartAutoplay(autoplaySource{playlistFile: playlistPath})
+}
+
+func restartAutoplay(source autoplaySource) error {
 	autoplayState.mu.Lock()
-	if mediaDir == "" {
-		mediaDir = autoplayState.mediaDir
+	if source.isZero() {
+		source = autoplayState.source
 	}
 	running := autoplayState.running
 	stop := autoplayState.stop
 	done := autoplayState.done
 	writer := autoplayState.writer
+	watcher := autoplayState.watcher
 	autoplayState.running = false
 	autoplayState.stop = nil
 	autoplayState.done = nil
 	autoplayState.writer = nil
+	autoplayState.watcher = nil
+	autoplayState.reloaded = nil
+	autoplayState.interrupt = nil
+	// a restart always comes back playing; the operator queue is kept.
+	if control.resume != nil {
+		close(control.resume)
+		control.resume = nil
+	}
+	control.pending = nil
+	control.list = nil
 	autoplayState.mu.Unlock()
 
+	watcher.Close()
+
 	if running && stop != nil {
 		close(stop)
 		if done != nil {
@@ -184,11 +320,120 @@ func RestartAutoplayFromMediaDir(mediaDir string) error {
 		writer.close()
 	}
 
-	if mediaDir == "" {
+	if source.isZero() {
 		return errors.New("autoplay not started")
 	}
 
-	return StartAutoplayFromMediaDir(mediaDir)
+	return startAutoplay(source)
+}
+
+// watchAutoplaySource reloads the source whenever its files change so the loop
+// can swap the new list in at the next track boundary (see takeReloadedPlaylist).
+// MEDIA_WATCH=off disables this, MEDIA_POLL_INTERVAL tunes the polling fallback.
+func watchAutoplaySource(source autoplaySource) *library.Watcher {
+	if strings.EqualFold(strings.TrimSpace(os.Getenv("MEDIA_WATCH")), "off") {
+		return nil
+	}
+
+	pollEvery := library.DefaultPollInterval
+	if raw := strings.TrimSpace(os.Getenv("MEDIA_POLL_INTERVAL")); raw != "" {
+		if d, err := time.ParseDuration(raw); err == nil && d > 0 {
+			pollEvery = d
+		}
+	}
+
+	return library.Watch(source.watchRoots(), pollEvery, func() {
+		reloadAutoplaySource(source)
+	})
+}
+
+// reloadActiveSource reloads whatever is on air, e.g. once new tracks are
+// transcoded.
+func reloadActiveSource() {
+	autoplayState.mu.Lock()
+	running, source := autoplayState.running, autoplayState.active
+	autoplayState.mu.Unlock()
+
+	if running {
+		reloadAutoplaySource(source)
+	}
+}
+
+// reloadAutoplaySource loads source again and queues the list for the loop
+// to swap in at the next track boundary (see takeReloadedPlaylist).
+func reloadAutoplaySource(source autoplaySource) {
+	list, err := source.load()
+	if err != nil {
+		log.Printf("autoplay: library changed but reload failed: %v", err)
+		return
+	}
+
+	// an edited playlist can point into folders we aren't watching yet.
+	roots := source.watchRoots()
+
+	autoplayState.mu.Lock()
+	var old *library.Watcher
+	if autoplayState.running && autoplayState.active == source {
+		autoplayState.reloaded = list
+		if autoplayState.watcher != nil && !autoplayState.watcher.Covers(roots) {
+			old = autoplayState.watcher
+			autoplayState.watcher = watchAutoplaySource(source)
+		}
+	}
+	autoplayState.mu.Unlock()
+	old.Close()
+
+	log.Printf("autoplay: library changed, %d track(s) queued for next track boundary", len(list))
+}
+
+// switchActiveSource points the library watcher at the program that just went
+// on air and drops any reload that was queued for the previous one.
+func switchActiveSource(source autoplaySource, program string) {
+	autoplayState.mu.Lock()
+	old := autoplayState.watcher
+	autoplayState.active = source
+	autoplayState.program = program
+	autoplayState.reloaded = nil
+	autoplayState.watcher = watchAutoplaySource(source)
+	autoplayState.mu.Unlock()
+
+	old.Close()
+}
+
+// takeReloadedPlaylist returns (and clears) a list reloaded by the watcher, if any.
+func takeReloadedPlaylist() []TrackMeta {
+	autoplayState.mu.Lock()
+	list := autoplayState.reloaded
+	autoplayState.reloaded = nil
+	autoplayState.mu.Unlock()
+
+	return list
+}
+
+func indexOfPath(list []TrackMeta, path string) int {
+	for i, m := range list {
+		if m.Path == path {
+			return i
+		}
+	}
+	return -1
+}
+
+// mergeReload swaps a reloaded list in for the running one (list, with next
+// the index about to play) and finds where to carry on in it.
+func mergeReload(tracks, list []TrackMeta, finishedPath string, next int) ([]TrackMeta, int) {
+	return playOrderSnapshot().merge(tracks, list, finishedPath, next)
+}
+
+// resumeIndex finds where to continue in a reloaded list: right after the track
+// that just finished, or at the same position if that track was removed.
+func resumeIndex(list []TrackMeta, finishedPath string, next int) int {
+	for i, m := range list {
+		if m.Path == finishedPath {
+			return i + 1
+		}
+	}
+	return next
 }
 
 // AutoplayDropCount returns the total number of dropped WebRTC samples.
@@ -203,22 +448,116 @@ func AutoplayDropCount() uint64 {
 	return writer.DropCount()
 }
 
-func autoplayPlaylistLoop(list []TrackMeta, writer *sampleWriter, stop <-chan struct{}) {
+// autoplayPlaylistLoop plays list (tracks in PLAYBACK_ORDER) from start, re-ordering
+// tracks for every new pass over the playlist.
+func autoplayPlaylistLoop(base, source autoplaySource, tracks, list []TrackMeta, start int, offset time.Duration, writer *sampleWriter, stop <-chan struct{}, interrupt chan struct{}) {
 	if len(list) == 0 {
 		return
 	}
 
-	i := 0
+	autoplayState.mu.Lock()
+	sched := autoplayState.schedule
+	autoplayState.mu.Unlock()
+
+	i := start
 
-	// already published track 0 in StartAutoplayFromMediaDir,
+	// where each program left off, so a recurring show continues instead of restarting.
+	positions := map[autoplaySource]int{}
+
+	// already published the first track in startAutoplay,
 	// so seed lastPath to avoid double publish/log on first iteration.
-	lastPath := list[0].Path
+	lastPath := list[i].Path
+
+	// the track that played last, so an admin seek can replay it.
+	var current TrackMeta
+
+	jingles := loadJingleRules()
 
 	for {
 		if isAutoplayStopped(stop) {
 			return
 		}
-		m := list[i]
+
+		// anything that arrived between tracks is stale now; the schedule check below
+		// already accounts for a boundary we may have crossed.
+		drainInterrupt(interrupt)
+
+		// a DJ is on air: hold here and pick up with the next track once they leave.
+		if waitForLive(stop) {
+			if isAutoplayStopped(stop) {
+				return
+			}
+			drainInterrupt(interrupt)
+			lastPath = ""
+			if epoch, ok := syncEpoch(); ok {
+				if idx, off, ok := clockPosition(list, epoch, time.Now()); ok {
+					i, offset = idx, off
+				}
+			}
+		}
+
+		if sched != nil {
+			next, program := resolveProgram(sched, base, time.Now())
+			if next != source {
+				if nextList, err := next.load(); err != nil || len(nextList) == 0 {
+					log.Printf("autoplay: unable to switch to program %q: %v", program, err)
+				} else {
+					log.Printf("Autoplay: switching to program %q (%d track(s) from %q)", program, len(nextList), next)
+					positions[source] = i
+					source = next
+					tracks = nextList
+					list = orderPlaylist(tracks)
+					i = positions[source] % len(list)
+					offset = 0
+					if epoch, ok := syncEpoch(); ok {
+						if idx, off, ok := clockPosition(list, epoch, time.Now()); ok {
+							i, offset = idx, off
+						}
+					}
+					switchActiveSource(source, program)
+				}
+			}
+		}
+
+		setControlList(list)
+
+		// queued tracks and admin replays don't move us along the playlist.
+		m, advance, replay := list[i], true, false
+		act, hasAct := takeControlAction()
+		if hasAct && act.kind == controlSeek && act.path != current.Path {
+			log.Printf("autoplay: dropping seek, %q is no longer playing", filepath.Base(act.path))
+			hasAct = false
+		}
+		switch {
+		case hasAct && act.kind == controlSeek && current.Path != "" && act.path == current.Path:
+			m, advance, replay, offset = current, false, true, act.offset
+		case hasAct && act.kind == controlJump && act.target(list) >= 0:
+			i, offset = act.target(list), 0
+			m = list[i]
+		default:
+			if q, ok := popAutoplayQueue(); ok {
+				m, advance, offset = q, false, 0
+			} else if r, ok := popDueRequest(); ok {
+				m, advance, offset = r, false, 0
+			} else if w, ok := closeVoteRound(); ok {
+				// carry on through the running order from the winner when we can.
+				if j := indexOfPath(list, w.Path); j >= 0 {
+					i, m, offset = j, list[j], 0
+				} else {
+					m, advance, offset = w, false, 0
+				}
+			}
+		}
+		current = m
+		if !replay {
+			noteTrackPlayed(m.Path, advance)
+
+			next := i
+			if advance {
+				next = i + 1
+			}
+			openVoteRound(list, next, m, m.Duration-offset)
+		}
 
 		// track change via log + publish
 		if m.Path != lastPath {
@@ -227,7 +566,10 @@ func autoplayPlaylistLoop(list []TrackMeta, writer *sampleWriter, stop <-chan st
 			lastPath = m.Path
 		}
 
-		if err := playOnce(m.Path, writer, stop); err != nil {
+		setPlayhead(source, i, m.Path, offset)
+		err := playOnce(m.playPath(), writer, stop, interrupt, offset)
+		offset = 0
+		if err != nil {
 			if errors.Is(err, io.ErrClosedPipe) {
 				log.Println("autoplay: track closed; stopping")
 				return
@@ -235,18 +577,40 @@ func autoplayPlaylistLoop(list []TrackMeta, writer *sampleWriter, stop <-chan st
 			if errors.Is(err, errAutoplayStopped) {
 				return
 			}
-			log.Println("autoplay:", err)
-			time.Sleep(time.Second)
+			if !errors.Is(err, errTrackInterrupted) {
+				log.Println("autoplay:", err)
+				time.Sleep(time.Second)
+			}
 		}
 
-		i++
+		if advance {
+			i++
+		}
+		if reloaded := takeReloadedPlaylist(); len(reloaded) > 0 {
+			log.Printf("Autoplay: merged library changes (%d -> %d track(s))", len(tracks), len(reloaded))
+			tracks = reloaded
+			list, i = mergeReload(tracks, list, m.Path, i)
+		}
 		if i >= len(list) {
+			list = nextCycle(tracks, list)
 			i = 0
 		}
+		if epoch, ok := syncEpoch(); ok {
+			i, offset = syncedNext(list, i, epoch, time.Now())
+		}
+
+		if jingles != nil && !replay && jingles.trackDone(time.Now()) && !controlPending() {
+			if err := jingles.play(source, i, list[i].Path, writer, stop, interrupt); err != nil {
+				return
+			}
+			// the station id may have taken over the now playing title.
+			lastPath = ""
+		}
 	}
 }
 
-func playOnce(path string, writer *sampleWriter, stop <-chan struct{}) error {
+// playOnce streams a single file in realtime, starting offset into the track.
+func playOnce(path string, writer *sampleWriter, stop <-chan struct{}, interrupt <-chan struct{}, offset time.Duration) error {
 	// ensure that we can play the opus file.
 	opusFile, err := os.Open(path)
 	if err != nil {
@@ -263,7 +627,7 @@ func playOnce(path string, writer *sampleWriter, stop <-chan struct{}) error {
 		return fmt.Errorf("rewind ogg: %w", err)
 	}
 
-	reader, err := prepareReader(opusFile, rate)
+	reader, err := prepareReader(opusFile, rate, offset)
 	if err != nil {
 		return fmt.Errorf("unable to prepare opus reader: %w", err)
 	}
@@ -274,6 +638,13 @@ func playOnce(path string, writer *sampleWriter, stop <-chan struct{}) error {
 		if isAutoplayStopped(stop) {
 			return errAutoplayStopped
 		}
+		if isTrackInterrupted(interrupt) {
+			return errTrackInterrupted
+		}
+		// a DJ went live; the loop waits for them at the track boundary.
+		if LiveOnAir() {
+			return errTrackInterrupted
+		}
 		pkt, dur, _, err := reader.Next()
 		if errors.Is(err, io.EOF) {
 			return nil
@@ -295,6 +666,13 @@ func playOnce(path string, writer *sampleWriter, stop <-chan struct{}) error {
 		if str != nil && str.cursor != nil {
 			str.cursor.Advance(dur)
 		}
+		advancePlayhead(dur)
+
+		if waited, err := waitWhilePaused(stop, interrupt); err != nil {
+			return err
+		} else if waited {
+			nextSend = time.Now()
+		}
 
 		nextSend = nextSend.Add(dur)
 		// somehow this doesn't break on windows
@@ -319,10 +697,43 @@ func isAutoplayStopped(stop <-chan struct{}) bool {
 	}
 }
 
+func isTrackInterrupted(interrupt <-chan struct{}) bool {
+	if interrupt == nil {
+		return false
+	}
+	select {
+	case <-interrupt:
+		return true
+	default:
+		return false
+	}
+}
+
+func drainInterrupt(interrupt <-chan struct{}) {
+	// the channel only ever holds one pending interrupt
+	_ = isTrackInterrupted(interrupt)
+}
+
+// interruptAutoplayTrack cuts the current track short; the loop moves on as if
+// it had finished normally.
+func interruptAutoplayTrack() {
+	autoplayState.mu.Lock()
+	interrupt := autoplayState.interrupt
+	autoplayState.mu.Unlock()
+
+	if interrupt == nil {
+		return
+	}
+	select {
+	case interrupt <- struct{}{}:
+	default:
+	}
+}
+
 // when preparing the reader we have two options:
 //
-//  1. if no RESUME_TIMESTAMP is set in the .env cfg then
-//     we are simply starting at the beginning of the file.
+//  1. if there's no offset (no RESUME_TIMESTAMP for the first file, no
+//     wall-clock catch up) then we are simply starting at the beginning of the file.
 //
 //  2. otherwise, we must seek to the provided timestamp in the file.
 //     because we must use a teeReader to pass data from the opus file
@@ -331,21 +742,19 @@ func isAutoplayStopped(stop <-chan struct{}) bool {
 //
 //     Warning: using the teeReader during large seeks WILL result in a cpu thread
 //     being maxed out.
-func prepareReader(opusFile *os.File, rate uint32) (*audio.OggOpusPacketReader, error) {
-	// if the resume timestamp is NOT set then just set the tee reader
-	resumeTimestamp := getResumeTimestamp()
-	log.Printf("Resuming at %v", resumeTimestamp)
-
-	// if no timestamp is set in the cfg then use the teeReader immediately.
-	if resumeTimestamp == 0 {
-		var src io.Reader = opusFile
+func prepareReader(opusFile *os.File, rate uint32, resumeTimestamp time.Duration) (*audio.OggOpusPacketReader, error) {
+	// if no timestamp is set then use the tee immediately.
+	if resumeTimestamp <= 0 {
+		reader := audio.NewOggOpusPacketReader(opusFile, rate)
 		if str != nil {
-			src = str.teeReader(src)
+			reader.Tee(str.teeWriter())
 		}
-		return audio.NewOggOpusPacketReader(src, rate), nil
+		return reader, nil
 	}
 
-	// a timestamp is set in the cfg so we must seek to that location in the file.
+	log.Printf("Resuming at %v", resumeTimestamp)
+
+	// a timestamp is set so we must seek to that location in the file.
 	// first, save the opus headerPages for ffmpeg.
 	headerPages, err := audio.ReadOpusHeaderPages(opusFile)
 	if err != nil {
@@ -361,12 +770,11 @@ func prepareReader(opusFile *os.File, rate uint32) (*audio.OggOpusPacketReader,
 	// build the source with the headerPages and the opusFile
 	src := io.MultiReader(bytes.NewReader(headerPages), opusFile)
 
-	// now create the tee reader, note that ffmpeg sees headers first
+	// now tee the reader, note that ffmpeg sees headers first
+	reader := audio.NewOggOpusPacketReader(src, rate)
 	if str != nil {
-		src = str.teeReader(src)
+		reader.Tee(str.teeWriter())
 	}
-
-	reader := audio.NewOggOpusPacketReader(src, rate)
 	reader.SetSeekState(prevGranule, uint64(preSkip))
 	return reader, nil
 }
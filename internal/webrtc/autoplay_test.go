@@ -0,0 +1,106 @@
+package webrtc
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestWatchRoots(t *testing.T) {
+	root := t.TempDir()
+	lists := filepath.Join(root, "lists")
+	if err := os.MkdirAll(lists, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	playlistFile := filepath.Join(lists, "station.m3u")
+	body := "#EXTM3U\n../albums/one/01.opus\n" + filepath.Join(root, "elsewhere", "02.opus") + "\nlocal.opus\n"
+	if err := os.WriteFile(playlistFile, []byte(body), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	got := autoplaySource{playlistFile: playlistFile}.watchRoots()
+	expected := []string{lists, filepath.Join(root, "albums", "one"), filepath.Join(root, "elsewhere"), lists}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected %q but got %q", expected, got)
+	}
+
+	if got := (autoplaySource{mediaDir: root}).watchRoots(); !reflect.DeepEqual(got, []string{root}) {
+		t.Fatalf("expected just the media dir but got %q", got)
+	}
+}
+
+func TestMergeReload(t *testing.T) {
+	orderMu.Lock()
+	saved := currentOrder
+	currentOrder = playOrder{mode: orderSorted}
+	orderMu.Unlock()
+	defer func() {
+		orderMu.Lock()
+		currentOrder = saved
+		orderMu.Unlock()
+	}()
+
+	tracks := func(paths ...string) []TrackMeta {
+		out := make([]TrackMeta, 0, len(paths))
+		for _, p := range paths {
+			out = append(out, TrackMeta{Path: p})
+		}
+		return out
+	}
+
+	tests := []struct {
+		name     string
+		reloaded []TrackMeta
+		finished string
+		next     int
+		expected int
+	}{
+		{
+			name:     "Carries On After The Finished Track",
+			reloaded: tracks("a", "new", "b", "c"),
+			finished: "b",
+			next:     2,
+			expected: 3,
+		},
+		{
+			name:     "Finished Track Removed",
+			reloaded: tracks("a", "c"),
+			finished: "b",
+			next:     2,
+			expected: 2,
+		},
+		{
+			name:     "Finished Track Was Last",
+			reloaded: tracks("a", "b", "c"),
+			finished: "c",
+			next:     3,
+			expected: 3,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			list, i := mergeReload(tc.reloaded, tracks("a", "b", "c"), tc.finished, tc.next)
+			if !reflect.DeepEqual(list, tc.reloaded) {
+				t.Fatalf("expected the sorted list back but got %v", list)
+			}
+			if i != tc.expected {
+				t.Fatalf("expected index %d but got %d", tc.expected, i)
+			}
+		})
+	}
+}
+
+func TestTakeReloadedPlaylist(t *testing.T) {
+	autoplayState.mu.Lock()
+	autoplayState.reloaded = []TrackMeta{{Path: "a"}}
+	autoplayState.mu.Unlock()
+
+	if got := takeReloadedPlaylist(); len(got) != 1 {
+		t.Fatalf("expected the reloaded list but got %v", got)
+	}
+	if got := takeReloadedPlaylist(); got != nil {
+		t.Fatalf("expected the reload to be taken only once but got %v", got)
+	}
+}
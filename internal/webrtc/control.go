@@ -0,0 +1,396 @@
+package webrtc
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// runtime controls for the autoplay loop. Anything that changes what's playing
+// goes through the loop itself (interrupt + pending action) so the cursor, the
+// now playing metadata and the HLS/Icecast tee all see one continuous stream.
+
+var (
+	errAutoplayNotRunning = errors.New("autoplay is not running")
+	errQueueItemNotFound  = errors.New("queue item not found")
+	errNothingToSeek      = errors.New("no track is on air to seek")
+)
+
+type controlKind int
+
+const (
+	controlJump controlKind = iota + 1
+	controlSeek
+)
+
+type controlAction struct {
+	kind   controlKind
+	index  int    // jump: position in the running order
+	path   string // jump: track to find in the running order, seek: track being seeked
+	offset time.Duration
+}
+
+// target resolves a jump against the list the loop is playing right now.
+func (a controlAction) target(list []TrackMeta) int {
+	if a.path != "" {
+		for i, m := range list {
+			if m.Path == a.path {
+				return i
+			}
+		}
+		return -1
+	}
+	if a.index < 0 || a.index >= len(list) {
+		return -1
+	}
+	return a.index
+}
+
+// QueueItem is a track an operator queued to play next.
+type QueueItem struct {
+	ID         string   `json:"id"`
+	Path       string   `json:"path"`
+	Title      string   `json:"title"`
+	Artists    []string `json:"artists"`
+	DurationMs int64    `json:"durationMs"`
+
+	meta TrackMeta
+}
+
+// AutoplayStatus is what the admin API reports about the loop.
+type AutoplayStatus struct {
+	Running  bool        `json:"running"`
+	Paused   bool        `json:"paused"`
+	Source   string      `json:"source"`
+	Path     string      `json:"path"`
+	Index    int         `json:"index"`
+	OffsetMs int64       `json:"offsetMs"`
+	Queue    []QueueItem `json:"queue"`
+	Upcoming []QueueItem `json:"upcoming"`
+}
+
+var control struct {
+	pending *controlAction
+	queue   []QueueItem
+	nextID  uint64
+	resume  chan struct{} // non-nil while paused, closed on resume
+	list    []TrackMeta   // running order the loop is on, for the status + jumps
+}
+
+// all of control is guarded by autoplayState.mu.
+
+func requireAutoplayRunning() error {
+	if !autoplayState.running {
+		return errAutoplayNotRunning
+	}
+	return nil
+}
+
+// SkipTrack ends the current track; the loop moves on to the next queued or
+// playlist track as if it had finished.
+func SkipTrack() error {
+	autoplayState.mu.Lock()
+	err := requireAutoplayRunning()
+	autoplayState.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	log.Println("admin: skipping current track")
+	interruptAutoplayTrack()
+	return nil
+}
+
+// JumpToTrack cuts to a track in the running order, either by index or by path.
+func JumpToTrack(index int, path string) error {
+	path = strings.TrimSpace(path)
+
+	autoplayState.mu.Lock()
+	if err := requireAutoplayRunning(); err != nil {
+		autoplayState.mu.Unlock()
+		return err
+	}
+	act := controlAction{kind: controlJump, index: index, path: path}
+	if act.target(control.list) < 0 {
+		autoplayState.mu.Unlock()
+		if path != "" {
+			return fmt.Errorf("%q is not in the current playlist", path)
+		}
+		return fmt.Errorf("track index %d is out of range", index)
+	}
+	control.pending = &act
+	autoplayState.mu.Unlock()
+
+	log.Printf("admin: jumping to track %s", jumpLabel(index, path))
+	interruptAutoplayTrack()
+	return nil
+}
+
+func jumpLabel(index int, path string) string {
+	if path != "" {
+		return strconv.Quote(path)
+	}
+	return strconv.Itoa(index)
+}
+
+// SeekTrack restarts the current track at offset. It fails while a station id
+// or a live DJ is on air, since the loop has no track of its own to replay then.
+func SeekTrack(offset time.Duration) error {
+	if offset < 0 {
+		return errors.New("offset must not be negative")
+	}
+
+	playhead.mu.Lock()
+	path, held := playhead.path, playhead.held
+	playhead.mu.Unlock()
+
+	if path == "" || held || LiveOnAir() {
+		return errNothingToSeek
+	}
+
+	autoplayState.mu.Lock()
+	if err := requireAutoplayRunning(); err != nil {
+		autoplayState.mu.Unlock()
+		return err
+	}
+	control.pending = &controlAction{kind: controlSeek, path: path, offset: offset}
+	autoplayState.mu.Unlock()
+
+	log.Printf("admin: seeking %q to %s", filepath.Base(path), offset)
+	interruptAutoplayTrack()
+	return nil
+}
+
+// PauseAutoplay holds the stream on the current packet until ResumeAutoplay.
+func PauseAutoplay() error {
+	autoplayState.mu.Lock()
+	defer autoplayState.mu.Unlock()
+
+	if err := requireAutoplayRunning(); err != nil {
+		return err
+	}
+	if control.resume == nil {
+		control.resume = make(chan struct{})
+		log.Println("admin: autoplay paused")
+	}
+	return nil
+}
+
+// ResumeAutoplay continues a paused stream where it stopped.
+func ResumeAutoplay() {
+	autoplayState.mu.Lock()
+	defer autoplayState.mu.Unlock()
+
+	if control.resume != nil {
+		close(control.resume)
+		control.resume = nil
+		log.Println("admin: autoplay resumed")
+	}
+}
+
+// AutoplayPaused reports whether an operator paused the stream, so the cursor
+// watchdog doesn't mistake it for a stall.
+func AutoplayPaused() bool {
+	autoplayState.mu.Lock()
+	defer autoplayState.mu.Unlock()
+
+	return control.resume != nil
+}
+
+// EnqueueTrack queues a media file to play after the current track. Relative
+// paths are resolved against the folder of the source that's on air.
+func EnqueueTrack(path string) (QueueItem, error) {
+	path = strings.TrimSpace(path)
+	if path == "" {
+		return QueueItem{}, errors.New("path is required")
+	}
+	if !mediaIndex().Accepts(path) {
+		return QueueItem{}, fmt.Errorf("%q is not a playable media file", path)
+	}
+
+	autoplayState.mu.Lock()
+	root := autoplayState.active.baseDir()
+	autoplayState.mu.Unlock()
+
+	if !filepath.IsAbs(path) && root != "" {
+		path = filepath.Join(root, path)
+	}
+
+	t, err := mediaIndex().Lookup(path)
+	if err != nil {
+		return QueueItem{}, err
+	}
+	go saveMediaIndex()
+
+	autoplayState.mu.Lock()
+	defer autoplayState.mu.Unlock()
+
+	control.nextID++
+	item := newQueueItem(strconv.FormatUint(control.nextID, 10), trackMetaFromLibrary(t))
+	control.queue = append(control.queue, item)
+
+	log.Printf("admin: queued %q (%d in queue)", filepath.Base(item.Path), len(control.queue))
+	return item, nil
+}
+
+func newQueueItem(id string, m TrackMeta) QueueItem {
+	return QueueItem{
+		ID:         id,
+		Path:       m.Path,
+		Title:      m.Title,
+		Artists:    m.Artists,
+		DurationMs: m.Duration.Milliseconds(),
+		meta:       m,
+	}
+}
+
+// MoveQueueItem moves a queued item to position (0 plays next).
+func MoveQueueItem(id string, position int) error {
+	autoplayState.mu.Lock()
+	defer autoplayState.mu.Unlock()
+
+	from := queueIndex(id)
+	if from < 0 {
+		return errQueueItemNotFound
+	}
+
+	item := control.queue[from]
+	control.queue = append(control.queue[:from], control.queue[from+1:]...)
+
+	position = min(max(position, 0), len(control.queue))
+	control.queue = append(control.queue, QueueItem{})
+	copy(control.queue[position+1:], control.queue[position:])
+	control.queue[position] = item
+
+	return nil
+}
+
+// RemoveQueueItem drops a queued item before it gets played.
+func RemoveQueueItem(id string) error {
+	autoplayState.mu.Lock()
+	defer autoplayState.mu.Unlock()
+
+	i := queueIndex(id)
+	if i < 0 {
+		return errQueueItemNotFound
+	}
+	control.queue = append(control.queue[:i], control.queue[i+1:]...)
+
+	return nil
+}
+
+func queueIndex(id string) int {
+	for i, item := range control.queue {
+		if item.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// GetAutoplayStatus returns the playhead, the operator queue and the rest of
+// the running order, starting after the playhead and wrapping around.
+func GetAutoplayStatus() AutoplayStatus {
+	playhead.mu.Lock()
+	st := AutoplayStatus{
+		Source:   playhead.source,
+		Path:     playhead.path,
+		Index:    playhead.index,
+		OffsetMs: playhead.offset.Milliseconds(),
+	}
+	playhead.mu.Unlock()
+
+	autoplayState.mu.Lock()
+	st.Running = autoplayState.running
+	st.Paused = control.resume != nil
+	st.Queue = append([]QueueItem{}, control.queue...)
+	st.Upcoming = upcomingItems(control.list, st.Index, st.Path)
+	autoplayState.mu.Unlock()
+
+	return st
+}
+
+// upcomingItems lists the running order from the track after the playhead,
+// wrapping around to the ones before it. IDs are running order indexes so they
+// can be passed straight to a jump. When a queued or requested track is on air
+// the playhead index hasn't been played yet, so it comes first.
+func upcomingItems(list []TrackMeta, index int, path string) []QueueItem {
+	if len(list) == 0 {
+		return []QueueItem{}
+	}
+
+	start, n := index, len(list)
+	if index >= 0 && index < len(list) && list[index].Path == path {
+		start, n = index+1, len(list)-1
+	}
+
+	items := make([]QueueItem, 0, n)
+	for k := range n {
+		i := ((start+k)%len(list) + len(list)) % len(list)
+		items = append(items, newQueueItem(strconv.Itoa(i), list[i]))
+	}
+	return items
+}
+
+func setControlList(list []TrackMeta) {
+	autoplayState.mu.Lock()
+	control.list = list
+	autoplayState.mu.Unlock()
+}
+
+func takeControlAction() (controlAction, bool) {
+	autoplayState.mu.Lock()
+	defer autoplayState.mu.Unlock()
+
+	if control.pending == nil {
+		return controlAction{}, false
+	}
+	act := *control.pending
+	control.pending = nil
+	return act, true
+}
+
+// controlPending reports whether an admin action is waiting for the loop.
+func controlPending() bool {
+	autoplayState.mu.Lock()
+	defer autoplayState.mu.Unlock()
+
+	return control.pending != nil
+}
+
+func popAutoplayQueue() (TrackMeta, bool) {
+	autoplayState.mu.Lock()
+	defer autoplayState.mu.Unlock()
+
+	if len(control.queue) == 0 {
+		return TrackMeta{}, false
+	}
+	item := control.queue[0]
+	control.queue = control.queue[1:]
+	return item.meta, true
+}
+
+// waitWhilePaused blocks while the stream is paused. It reports whether it
+// actually waited so the caller can reset its send clock.
+func waitWhilePaused(stop, interrupt <-chan struct{}) (bool, error) {
+	autoplayState.mu.Lock()
+	resume := control.resume
+	autoplayState.mu.Unlock()
+
+	if resume == nil {
+		return false, nil
+	}
+
+	select {
+	case <-resume:
+		return true, nil
+	case <-stop:
+		return true, errAutoplayStopped
+	case <-interrupt:
+		return true, errTrackInterrupted
+	}
+}
@@ -0,0 +1,208 @@
+package webrtc
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// withRunningAutoplay pretends the loop is up, with list as its running order
+// and the playhead on path.
+func withRunningAutoplay(t *testing.T, list []TrackMeta, index int, path string) {
+	t.Helper()
+
+	autoplayState.mu.Lock()
+	savedRunning, savedList, savedPending := autoplayState.running, control.list, control.pending
+	autoplayState.running = true
+	control.list = list
+	control.pending = nil
+	autoplayState.mu.Unlock()
+
+	playhead.mu.Lock()
+	savedIndex, savedPath, savedHeld := playhead.index, playhead.path, playhead.held
+	playhead.mu.Unlock()
+	setPlayhead(autoplaySource{mediaDir: "media"}, index, path, 0)
+
+	t.Cleanup(func() {
+		ResumeAutoplay()
+
+		autoplayState.mu.Lock()
+		autoplayState.running, control.list, control.pending = savedRunning, savedList, savedPending
+		autoplayState.mu.Unlock()
+
+		playhead.mu.Lock()
+		playhead.index, playhead.path, playhead.held = savedIndex, savedPath, savedHeld
+		playhead.mu.Unlock()
+	})
+}
+
+func pendingAction() *controlAction {
+	autoplayState.mu.Lock()
+	defer autoplayState.mu.Unlock()
+
+	return control.pending
+}
+
+func TestControlNotRunning(t *testing.T) {
+	autoplayState.mu.Lock()
+	saved := autoplayState.running
+	autoplayState.running = false
+	autoplayState.mu.Unlock()
+	defer func() {
+		autoplayState.mu.Lock()
+		autoplayState.running = saved
+		autoplayState.mu.Unlock()
+	}()
+
+	for _, tc := range []struct {
+		name string
+		fn   func() error
+	}{
+		{"skip", SkipTrack},
+		{"pause", PauseAutoplay},
+		{"jump", func() error { return JumpToTrack(0, "") }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.fn(); !errors.Is(err, errAutoplayNotRunning) {
+				t.Fatalf("expected %v but got %v", errAutoplayNotRunning, err)
+			}
+		})
+	}
+}
+
+func TestJumpToTrack(t *testing.T) {
+	list := []TrackMeta{{Path: "a.opus"}, {Path: "b.opus"}, {Path: "c.opus"}}
+
+	for _, tc := range []struct {
+		name     string
+		index    int
+		path     string
+		expected int
+		fails    bool
+	}{
+		{"by index", 2, "", 2, false},
+		{"by path", -1, "b.opus", 1, false},
+		{"index out of range", 3, "", 0, true},
+		{"negative index", -1, "", 0, true},
+		{"path not in playlist", -1, "z.opus", 0, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			withRunningAutoplay(t, list, 0, "a.opus")
+
+			err := JumpToTrack(tc.index, tc.path)
+			if tc.fails {
+				if err == nil {
+					t.Fatalf("expected an error but got none")
+				}
+				if act := pendingAction(); act != nil {
+					t.Fatalf("expected no pending action but got %+v", *act)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error but got %v", err)
+			}
+			act := pendingAction()
+			if act == nil || act.kind != controlJump || act.target(list) != tc.expected {
+				t.Fatalf("expected a jump to %d but got %+v", tc.expected, act)
+			}
+		})
+	}
+}
+
+func TestSeekTrack(t *testing.T) {
+	list := []TrackMeta{{Path: "a.opus"}, {Path: "b.opus"}}
+
+	for _, tc := range []struct {
+		name     string
+		path     string
+		held     bool
+		offset   time.Duration
+		expected error
+	}{
+		{"track on air", "a.opus", false, 90 * time.Second, nil},
+		{"request on air", "requested.opus", false, time.Second, nil},
+		{"station id on air", "b.opus", true, time.Second, errNothingToSeek},
+		{"nothing played yet", "", false, time.Second, errNothingToSeek},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			withRunningAutoplay(t, list, 0, tc.path)
+			if tc.held {
+				holdPlayhead()
+			}
+
+			err := SeekTrack(tc.offset)
+			if !errors.Is(err, tc.expected) {
+				t.Fatalf("expected %v but got %v", tc.expected, err)
+			}
+			act := pendingAction()
+			if tc.expected != nil {
+				if act != nil {
+					t.Fatalf("expected no pending action but got %+v", *act)
+				}
+				return
+			}
+			expected := controlAction{kind: controlSeek, path: tc.path, offset: tc.offset}
+			if act == nil || *act != expected {
+				t.Fatalf("expected %+v but got %+v", expected, act)
+			}
+		})
+	}
+
+	t.Run("negative offset", func(t *testing.T) {
+		withRunningAutoplay(t, list, 0, "a.opus")
+		if err := SeekTrack(-time.Second); err == nil {
+			t.Fatalf("expected an error but got none")
+		}
+	})
+}
+
+func TestPauseResume(t *testing.T) {
+	withRunningAutoplay(t, nil, 0, "a.opus")
+
+	if err := PauseAutoplay(); err != nil {
+		t.Fatalf("expected no error but got %v", err)
+	}
+	if !AutoplayPaused() || !GetAutoplayStatus().Paused {
+		t.Fatalf("expected autoplay to be paused")
+	}
+
+	ResumeAutoplay()
+	if AutoplayPaused() || GetAutoplayStatus().Paused {
+		t.Fatalf("expected autoplay to be resumed")
+	}
+}
+
+func TestUpcomingItems(t *testing.T) {
+	list := []TrackMeta{{Path: "a.opus"}, {Path: "b.opus"}, {Path: "c.opus"}, {Path: "d.opus"}}
+
+	for _, tc := range []struct {
+		name     string
+		index    int
+		path     string
+		expected []string
+	}{
+		{"first track on air", 0, "a.opus", []string{"1", "2", "3"}},
+		{"wraps around", 2, "c.opus", []string{"3", "0", "1"}},
+		{"last track on air", 3, "d.opus", []string{"0", "1", "2"}},
+		{"queued track on air", 2, "queued.opus", []string{"2", "3", "0", "1"}},
+		{"nothing played yet", 0, "", []string{"0", "1", "2", "3"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			withRunningAutoplay(t, list, tc.index, tc.path)
+
+			ids := []string{}
+			for _, item := range GetAutoplayStatus().Upcoming {
+				ids = append(ids, item.ID)
+			}
+			if !reflect.DeepEqual(ids, tc.expected) {
+				t.Fatalf("expected upcoming %v but got %v", tc.expected, ids)
+			}
+		})
+	}
+
+	if got := upcomingItems(nil, 0, ""); len(got) != 0 {
+		t.Fatalf("expected no upcoming tracks but got %v", got)
+	}
+}
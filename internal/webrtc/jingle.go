@@ -0,0 +1,160 @@
+package webrtc
+
+import (
+	"errors"
+	"io"
+	"log"
+	mrand "math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// jingleRules inserts station IDs/jingles from JINGLE_DIR between tracks:
+//
+//	JINGLE_EVERY_TRACKS=4      after every 4th track
+//	JINGLE_EVERY=15m           once 15 minutes have passed since the last one
+//	JINGLE_TOP_OF_HOUR=true    at the first track boundary of every hour
+//
+// Any rule that fires plays one jingle, picked from a shuffled rotation.
+type jingleRules struct {
+	dir          string
+	everyTracks  int
+	every        time.Duration
+	topOfHour    bool
+	publishTitle bool
+
+	tracksSince int
+	lastAt      time.Time
+	lastHour    time.Time
+
+	rotation []TrackMeta
+	rng      *mrand.Rand
+}
+
+// loadJingleRules reads the JINGLE_* config, returning nil if jingles are off.
+func loadJingleRules() *jingleRules {
+	dir := strings.TrimSpace(os.Getenv("JINGLE_DIR"))
+	if dir == "" {
+		return nil
+	}
+
+	now := time.Now()
+	r := &jingleRules{
+		dir:          dir,
+		topOfHour:    envBool("JINGLE_TOP_OF_HOUR"),
+		publishTitle: envBool("JINGLE_PUBLISH_TITLE"),
+		lastAt:       now,
+		lastHour:     topOfHour(now),
+		rng:          mrand.New(mrand.NewSource(now.UnixNano())),
+	}
+
+	if raw := strings.TrimSpace(os.Getenv("JINGLE_EVERY_TRACKS")); raw != "" {
+		if n, err := strconv.Atoi(raw); err == nil && n > 0 {
+			r.everyTracks = n
+		} else {
+			log.Printf("autoplay: ignoring invalid JINGLE_EVERY_TRACKS %q", raw)
+		}
+	}
+	if raw := strings.TrimSpace(os.Getenv("JINGLE_EVERY")); raw != "" {
+		if d, err := time.ParseDuration(raw); err == nil && d > 0 {
+			r.every = d
+		} else {
+			log.Printf("autoplay: ignoring invalid JINGLE_EVERY %q", raw)
+		}
+	}
+
+	if r.everyTracks == 0 && r.every == 0 && !r.topOfHour {
+		log.Printf("autoplay: JINGLE_DIR is set but no JINGLE_EVERY_TRACKS/JINGLE_EVERY/JINGLE_TOP_OF_HOUR rule is; jingles are off")
+		return nil
+	}
+	if _, ok := syncEpoch(); ok {
+		log.Printf("autoplay: jingles are off while SYNC_EPOCH is set")
+		return nil
+	}
+
+	return r
+}
+
+func envBool(name string) bool {
+	v, err := strconv.ParseBool(strings.TrimSpace(os.Getenv(name)))
+	return err == nil && v
+}
+
+// trackDone counts a finished track and reports whether a jingle is due now.
+func (r *jingleRules) trackDone(now time.Time) bool {
+	r.tracksSince++
+
+	switch {
+	case r.everyTracks > 0 && r.tracksSince >= r.everyTracks:
+		return true
+	case r.every > 0 && now.Sub(r.lastAt) >= r.every:
+		return true
+	case r.topOfHour && topOfHour(now).After(r.lastHour):
+		return true
+	}
+	return false
+}
+
+// next picks the next jingle, reshuffling the rotation once it runs out.
+func (r *jingleRules) next() (TrackMeta, bool) {
+	if len(r.rotation) == 0 {
+		list, err := LoadOpusPlaylist(r.dir)
+		if err != nil {
+			log.Printf("autoplay: unable to load jingles from %q: %v", r.dir, err)
+			return TrackMeta{}, false
+		}
+		r.rng.Shuffle(len(list), func(i, j int) { list[i], list[j] = list[j], list[i] })
+		r.rotation = list
+	}
+	if len(r.rotation) == 0 {
+		return TrackMeta{}, false
+	}
+
+	m := r.rotation[0]
+	r.rotation = r.rotation[1:]
+	return m, true
+}
+
+func (r *jingleRules) played(now time.Time) {
+	r.tracksSince = 0
+	r.lastAt = now
+	r.lastHour = topOfHour(now)
+}
+
+// topOfHour goes by the local wall clock; Truncate works on absolute time,
+// which is off in zones with a half hour offset.
+func topOfHour(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, t.Location())
+}
+
+// play plays one jingle between tracks. It returns an error only when the
+// loop should stop.
+func (r *jingleRules) play(source autoplaySource, next int, upcoming string, writer *sampleWriter, stop <-chan struct{}, interrupt <-chan struct{}) error {
+	j, ok := r.next()
+	if !ok {
+		return nil
+	}
+
+	log.Printf("Autoplay: station id %q", filepath.Base(j.Path))
+	if r.publishTitle {
+		PublishNowPlaying(j.Title, j.Artists)
+	}
+
+	// point the saved state at the start of the upcoming track rather than
+	// the jingle, so a restart mid-jingle resumes with that track.
+	setPlayhead(source, next, upcoming, 0)
+	holdPlayhead()
+	err := playOnce(j.playPath(), writer, stop, interrupt, 0)
+	r.played(time.Now())
+
+	if errors.Is(err, io.ErrClosedPipe) || errors.Is(err, errAutoplayStopped) {
+		return err
+	}
+	if err != nil && !errors.Is(err, errTrackInterrupted) {
+		log.Println("autoplay: jingle:", err)
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package webrtc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestJingleTrackDone(t *testing.T) {
+	utc := time.Date(2026, 10, 16, 14, 0, 0, 0, time.UTC)
+	kolkata := time.FixedZone("IST", 5*3600+1800)
+
+	for _, tc := range []struct {
+		name     string
+		rules    jingleRules
+		tracks   int // tracks finished before the one being checked
+		now      time.Time
+		expected bool
+	}{
+		{"every 3 tracks, 2nd", jingleRules{everyTracks: 3, lastAt: utc}, 1, utc, false},
+		{"every 3 tracks, 3rd", jingleRules{everyTracks: 3, lastAt: utc}, 2, utc, true},
+		{"every 3 tracks, past due", jingleRules{everyTracks: 3, lastAt: utc}, 5, utc, true},
+		{"every 15m, too soon", jingleRules{every: 15 * time.Minute, lastAt: utc}, 0, utc.Add(14 * time.Minute), false},
+		{"every 15m, due", jingleRules{every: 15 * time.Minute, lastAt: utc}, 0, utc.Add(15 * time.Minute), true},
+		{"top of hour, same hour", jingleRules{topOfHour: true, lastHour: utc}, 0, utc.Add(59 * time.Minute), false},
+		{"top of hour, next hour", jingleRules{topOfHour: true, lastHour: utc}, 0, utc.Add(time.Hour), true},
+		{
+			"top of hour, half hour zone, same hour",
+			jingleRules{topOfHour: true, lastHour: time.Date(2026, 10, 16, 14, 0, 0, 0, kolkata)},
+			0, time.Date(2026, 10, 16, 14, 45, 0, 0, kolkata), false,
+		},
+		{
+			"top of hour, half hour zone, next hour",
+			jingleRules{topOfHour: true, lastHour: time.Date(2026, 10, 16, 14, 0, 0, 0, kolkata)},
+			0, time.Date(2026, 10, 16, 15, 1, 0, 0, kolkata), true,
+		},
+		{"no rule fires", jingleRules{everyTracks: 4, every: time.Hour, topOfHour: true, lastAt: utc, lastHour: utc}, 0, utc.Add(time.Minute), false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := tc.rules
+			r.tracksSince = tc.tracks
+
+			if got := r.trackDone(tc.now); got != tc.expected {
+				t.Fatalf("expected %v but got %v", tc.expected, got)
+			}
+			if r.tracksSince != tc.tracks+1 {
+				t.Fatalf("expected %d tracks counted but got %d", tc.tracks+1, r.tracksSince)
+			}
+		})
+	}
+}
+
+func TestJinglePlayed(t *testing.T) {
+	kolkata := time.FixedZone("IST", 5*3600+1800)
+	now := time.Date(2026, 10, 16, 14, 45, 0, 0, kolkata)
+
+	r := jingleRules{everyTracks: 2, every: 15 * time.Minute, topOfHour: true, tracksSince: 2}
+	r.played(now)
+
+	if r.tracksSince != 0 {
+		t.Fatalf("expected the track count to reset but got %d", r.tracksSince)
+	}
+	if !r.lastAt.Equal(now) {
+		t.Fatalf("expected last jingle at %v but got %v", now, r.lastAt)
+	}
+	if expected := time.Date(2026, 10, 16, 14, 0, 0, 0, kolkata); !r.lastHour.Equal(expected) {
+		t.Fatalf("expected hour %v but got %v", expected, r.lastHour)
+	}
+	if r.trackDone(now.Add(time.Minute)) {
+		t.Fatalf("expected no jingle right after one played")
+	}
+}
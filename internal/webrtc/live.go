@@ -0,0 +1,207 @@
+package webrtc
+
+import (
+	"errors"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pion/webrtc/v4/pkg/media"
+)
+
+// live DJ sets (WHIP or an Icecast source client). While a DJ is on air their
+// Opus packets go out on the shared audio track, move the cursor and feed the
+// HLS/Icecast tee. The autoplay loop steps aside at the next packet and carries
+// on with the next track as soon as the DJ leaves.
+
+const (
+	// a DJ that stops sending for this long is treated as gone.
+	liveReadTimeout = 5 * time.Second
+
+	// how long to wait for autoplay to let go of the outputs before going live anyway.
+	liveHandoffTimeout = 2 * time.Second
+
+	defaultLiveTitle = "Live"
+)
+
+var (
+	ErrLiveBusy = errors.New("a DJ is already live")
+	ErrNotLive  = errors.New("no DJ is live")
+)
+
+// liveSession is one connected DJ, whatever protocol they came in over.
+type liveSession struct {
+	id    string // for the WHIP resource URL
+	name  string
+	close func() // disconnects the DJ
+}
+
+var live struct {
+	onAir atomic.Bool // checked for every autoplay packet
+
+	mu      sync.Mutex
+	session *liveSession
+	done    chan struct{} // closed when the DJ leaves
+	handoff chan struct{} // closed once autoplay has stopped writing
+	yielded bool
+}
+
+// LiveOnAir reports whether a DJ is publishing right now.
+func LiveOnAir() bool {
+	return live.onAir.Load()
+}
+
+// reserveLive claims the live slot for a connecting DJ. Only one DJ can be
+// connected at a time.
+func reserveLive(name string, closeFn func()) (*liveSession, error) {
+	live.mu.Lock()
+	defer live.mu.Unlock()
+
+	if live.session != nil {
+		return nil, ErrLiveBusy
+	}
+	live.session = &liveSession{id: uuid.New().String(), name: strings.TrimSpace(name), close: closeFn}
+	return live.session, nil
+}
+
+// StopLive disconnects the DJ connected as session id. A stale id (from a set
+// that already ended) never ends whoever is live now.
+func StopLive(id string) error {
+	live.mu.Lock()
+	s := live.session
+	live.mu.Unlock()
+
+	if s == nil || s.id != id {
+		return ErrNotLive
+	}
+	endLive(s)
+	return nil
+}
+
+// goLive puts the DJ on air once their audio starts flowing. It returns after
+// autoplay has stepped aside (or liveHandoffTimeout), with a channel that closes
+// once the DJ is taken off air.
+func goLive(s *liveSession) (<-chan struct{}, bool) {
+	live.mu.Lock()
+	if live.session != s || live.done != nil {
+		live.mu.Unlock()
+		return nil, false
+	}
+	live.done = make(chan struct{})
+	live.handoff = make(chan struct{})
+	live.yielded = false
+	handoff, done := live.handoff, live.done
+	live.onAir.Store(true)
+	live.mu.Unlock()
+
+	autoplayState.mu.Lock()
+	running := autoplayState.running
+	autoplayState.mu.Unlock()
+	if !running {
+		yieldToLive()
+	}
+
+	title := strings.TrimSpace(os.Getenv("LIVE_TITLE"))
+	if title == "" {
+		title = defaultLiveTitle
+	}
+	var artists []string
+	if s.name != "" {
+		artists = []string{s.name}
+	}
+
+	log.Printf("live: DJ %q is on air", s.name)
+	PublishNowPlaying(title, artists)
+
+	// don't interleave with the last autoplay pages on the tee.
+	select {
+	case <-handoff:
+	case <-time.After(liveHandoffTimeout):
+	}
+
+	return done, true
+}
+
+// endLive disconnects the DJ and hands the stream back to autoplay.
+func endLive(s *liveSession) {
+	live.mu.Lock()
+	if live.session != s {
+		live.mu.Unlock()
+		return
+	}
+	done := live.done
+	live.session = nil
+	live.done = nil
+	live.handoff = nil
+	live.onAir.Store(false)
+	live.mu.Unlock()
+
+	if done != nil {
+		close(done)
+		log.Println("live: DJ left, back to autoplay")
+	}
+
+	if s.close != nil {
+		s.close()
+	}
+}
+
+func yieldToLive() {
+	live.mu.Lock()
+	defer live.mu.Unlock()
+
+	if live.handoff != nil && !live.yielded {
+		live.yielded = true
+		close(live.handoff)
+	}
+}
+
+// waitForLive parks the autoplay loop while a DJ is on air. It reports whether
+// it waited so the loop can pick a fresh position afterwards.
+func waitForLive(stop <-chan struct{}) bool {
+	live.mu.Lock()
+	done := live.done
+	live.mu.Unlock()
+
+	if done == nil {
+		return false
+	}
+	yieldToLive()
+
+	select {
+	case <-done:
+	case <-stop:
+	}
+	return true
+}
+
+// sendLivePacket puts one Opus packet of a live set on the webrtc track and
+// moves the shared cursor along.
+func sendLivePacket(pkt []byte, dur time.Duration) {
+	writeLiveSample(media.Sample{Data: pkt, Duration: dur})
+
+	if str.cursor != nil {
+		str.cursor.Advance(dur)
+	}
+}
+
+// writeLiveSample goes through the autoplay writer when there is one so the
+// DJ's first packets queue up behind whatever autoplay already handed off.
+func writeLiveSample(sample media.Sample) {
+	autoplayState.mu.Lock()
+	writer := autoplayState.writer
+	autoplayState.mu.Unlock()
+
+	if writer != nil {
+		writer.writeSample(sample)
+		return
+	}
+	if err := str.audioTrack.WriteSample(sample); err != nil && !errors.Is(err, io.ErrClosedPipe) {
+		log.Printf("live: webrtc sample write error: %v", err)
+	}
+}
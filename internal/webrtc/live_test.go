@@ -0,0 +1,244 @@
+package webrtc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/philipch07/EggsFM/internal/audio"
+	"github.com/pion/webrtc/v4"
+)
+
+// withTestStream stands in for Configure, with a tee that collects everything.
+func withTestStream(t *testing.T) *syncBuffer {
+	t.Helper()
+
+	track, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus, ClockRate: 48000, Channels: 2}, "audio", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tee := &syncBuffer{}
+	saved := str
+	str = &stream{
+		audioTrack:        track,
+		whepSessions:      map[string]struct{}{},
+		cursor:            audio.NewCursor(),
+		nowPlayingTitle:   "-",
+		nowPlayingArtists: []string{},
+		hlsWriters:        []io.Writer{tee},
+	}
+	t.Cleanup(func() { str = saved })
+	return tee
+}
+
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Len()
+}
+
+func livePage(granule uint64, packets ...[]byte) []byte {
+	var lacing, body []byte
+	for _, p := range packets {
+		lacing = append(lacing, byte(len(p)))
+		body = append(body, p...)
+	}
+	page := append([]byte("OggS"), make([]byte, 22)...)
+	binary.LittleEndian.PutUint64(page[6:], granule)
+	page = append(page, byte(len(lacing)))
+	page = append(page, lacing...)
+	return append(page, body...)
+}
+
+func TestLiveSlot(t *testing.T) {
+	closed := 0
+	s, err := reserveLive(" DJ One ", func() { closed++ })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.name != "DJ One" {
+		t.Fatalf("expected the name trimmed but got %q", s.name)
+	}
+	if _, err := reserveLive("DJ Two", nil); !errors.Is(err, ErrLiveBusy) {
+		t.Fatalf("expected %v but got %v", ErrLiveBusy, err)
+	}
+
+	if err := StopLive("some other set"); !errors.Is(err, ErrNotLive) {
+		t.Fatalf("expected %v but got %v", ErrNotLive, err)
+	}
+	if closed != 0 {
+		t.Fatalf("expected a stale id to leave the DJ connected")
+	}
+
+	if err := StopLive(s.id); err != nil {
+		t.Fatal(err)
+	}
+	if closed != 1 {
+		t.Fatalf("expected the DJ to be disconnected")
+	}
+	if err := StopLive(s.id); !errors.Is(err, ErrNotLive) {
+		t.Fatalf("expected %v but got %v", ErrNotLive, err)
+	}
+
+	// the slot is free again.
+	s, err = reserveLive("DJ Two", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	endLive(s)
+}
+
+func TestLiveHandoff(t *testing.T) {
+	autoplayState.mu.Lock()
+	autoplayState.running = true
+	autoplayState.mu.Unlock()
+	defer func() {
+		autoplayState.mu.Lock()
+		autoplayState.running = false
+		autoplayState.mu.Unlock()
+	}()
+
+	stop := make(chan struct{})
+	defer close(stop)
+
+	if waitForLive(stop) {
+		t.Fatalf("expected autoplay not to wait with nobody live")
+	}
+
+	s, err := reserveLive("DJ", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	onAir := make(chan (<-chan struct{}))
+	go func() {
+		done, _ := goLive(s)
+		onAir <- done
+	}()
+
+	// autoplay steps aside at its next packet...
+	for !LiveOnAir() {
+		time.Sleep(time.Millisecond)
+	}
+	waited := make(chan bool)
+	go func() { waited <- waitForLive(stop) }()
+
+	// ...which lets the DJ on well before the handoff timeout.
+	var done <-chan struct{}
+	select {
+	case done = <-onAir:
+	case <-time.After(liveHandoffTimeout / 2):
+		t.Fatalf("expected the DJ on air once autoplay yielded")
+	}
+
+	select {
+	case <-waited:
+		t.Fatalf("expected autoplay to stay parked while the DJ is on")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	// the DJ leaves and autoplay carries on.
+	endLive(s)
+	select {
+	case ok := <-waited:
+		if !ok {
+			t.Fatalf("expected waitForLive to report that it waited")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected autoplay to resume when the DJ left")
+	}
+	if LiveOnAir() {
+		t.Fatalf("expected nobody on air")
+	}
+	select {
+	case <-done:
+	default:
+		t.Fatalf("expected the DJ's done channel closed")
+	}
+}
+
+func TestIngestSource(t *testing.T) {
+	tee := withTestStream(t)
+
+	var body bytes.Buffer
+	body.Write(livePage(0, []byte("OpusHead\x01\x02\x00\x00\x80\xbb\x00\x00\x00\x00\x00")))
+	body.Write(livePage(0, []byte("OpusTags")))
+	packet := append([]byte{0xfc}, make([]byte, 40)...)
+	body.Write(livePage(5*960, packet, packet, packet, packet, packet))
+	sent := body.Len()
+
+	var titles []string
+	OnNowPlaying(func(title string, artists []string) {
+		if len(artists) > 0 {
+			title += " / " + artists[0]
+		}
+		titles = append(titles, title)
+	})
+	defer func() {
+		nowPlayingHooks.mu.Lock()
+		nowPlayingHooks.hooks = nil
+		nowPlayingHooks.mu.Unlock()
+	}()
+
+	accepted := false
+	start := str.cursor.Position()
+	err := IngestSource("DJ", io.NopCloser(&body), func() { accepted = true })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !accepted {
+		t.Fatalf("expected the source to be accepted")
+	}
+	if got := str.cursor.Position() - start; got != 100*time.Millisecond {
+		t.Fatalf("expected the cursor to move 100ms but got %s", got)
+	}
+	if tee.Len() != sent {
+		t.Fatalf("expected the stream passed through to the tee (%d bytes) but got %d", sent, tee.Len())
+	}
+	if len(titles) < 2 || titles[len(titles)-1] != defaultLiveTitle+" / DJ" {
+		t.Fatalf("expected the DJ's title published but got %q", titles)
+	}
+	if LiveOnAir() {
+		t.Fatalf("expected the DJ off air once the source ended")
+	}
+	if s, err := reserveLive("next", nil); err != nil {
+		t.Fatalf("expected the slot free but got %v", err)
+	} else {
+		endLive(s)
+	}
+}
+
+func TestLiveNotConfigured(t *testing.T) {
+	saved := str
+	str = nil
+	defer func() { str = saved }()
+
+	if _, _, err := WHIP("v=0", "DJ"); err == nil {
+		t.Fatalf("expected WHIP to fail before Configure")
+	}
+	if err := IngestSource("DJ", io.NopCloser(&bytes.Buffer{}), nil); !errors.Is(err, errNotConfigured) {
+		t.Fatalf("expected %v but got %v", errNotConfigured, err)
+	}
+
+	// neither kept the slot.
+	s, err := reserveLive("DJ", nil)
+	if err != nil {
+		t.Fatalf("expected the slot free but got %v", err)
+	}
+	endLive(s)
+}
@@ -2,18 +2,52 @@ package webrtc
 
 import (
 	"errors"
-	"io"
+	"fmt"
+	"log"
 	"os"
-	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 
-	"github.com/pion/webrtc/v4/pkg/media/oggreader"
+	"github.com/philipch07/EggsFM/internal/library"
+	"github.com/philipch07/EggsFM/internal/playlist"
 )
 
 type TrackMeta struct {
-	Path    string
-	Title   string
-	Artists []string
+	Path     string
+	Title    string
+	Artists  []string
+	Duration time.Duration
+	// File is the transcoded Opus copy for non-Opus sources.
+	File string
+}
+
+func (m TrackMeta) playPath() string {
+	if m.File != "" {
+		return m.File
+	}
+	return m.Path
+}
+
+var nowPlayingHooks struct {
+	mu    sync.RWMutex
+	hooks []func(title string, artists []string)
+}
+
+// OnNowPlaying registers fn to be called with every title change, e.g. to
+// push it into in-stream metadata. fn must not block.
+func OnNowPlaying(fn func(title string, artists []string)) {
+	if fn == nil {
+		return
+	}
+	nowPlayingHooks.mu.Lock()
+	nowPlayingHooks.hooks = append(nowPlayingHooks.hooks, fn)
+	nowPlayingHooks.mu.Unlock()
+
+	// catch up with whatever is already on air.
+	if str != nil {
+		fn(CurrentNowPlaying())
+	}
 }
 
 // PublishNowPlaying updates the shared metadata used by /status.
@@ -29,6 +63,14 @@ func PublishNowPlaying(title string, artists []string) {
 	str.nowPlayingArtists = dst
 
 	str.nowPlayingLock.Unlock()
+
+	nowPlayingHooks.mu.RLock()
+	hooks := nowPlayingHooks.hooks
+	nowPlayingHooks.mu.RUnlock()
+
+	for _, fn := range hooks {
+		fn(title, append([]string(nil), artists...))
+	}
 }
 
 func CurrentNowPlaying() (title string, artists []string) {
@@ -44,141 +86,181 @@ func CurrentNowPlaying() (title string, artists []string) {
 	return title, out
 }
 
-// LoadOpusPlaylist returns all *.opus (Ogg Opus) files in mediaDir,
-// with best-effort Title/Artist extracted from OpusTags.
-// This is unsorted for now, but will be sorted in the future.
+const (
+	defaultLibraryIndex = "library-index.json"
+	defaultTranscodeDir = "transcode-cache"
+)
+
+var (
+	libraryIndexOnce sync.Once
+	libraryIndex     *library.Index
+)
+
+// mediaIndex returns the shared on-disk track index so restarts only re-read
+// files that changed. LIBRARY_INDEX overrides where it is stored.
+// Non-Opus media is transcoded into TRANSCODE_CACHE_DIR unless TRANSCODE=off.
+func mediaIndex() *library.Index {
+	libraryIndexOnce.Do(func() {
+		path := strings.TrimSpace(os.Getenv("LIBRARY_INDEX"))
+		if path == "" {
+			path = defaultLibraryIndex
+		}
+		libraryIndex = library.OpenIndex(path)
+
+		if strings.EqualFold(strings.TrimSpace(os.Getenv("TRANSCODE")), "off") {
+			return
+		}
+		cacheDir := strings.TrimSpace(os.Getenv("TRANSCODE_CACHE_DIR"))
+		if cacheDir == "" {
+			cacheDir = defaultTranscodeDir
+		}
+		tc, err := library.NewTranscoder(os.Getenv("FFMPEG_BIN"), cacheDir)
+		if err != nil {
+			log.Printf("library: only .opus files will be played: %v", err)
+			return
+		}
+		libraryIndex.SetTranscoder(tc)
+		libraryIndex.OnTranscoded(func(library.Track) { scheduleTranscodeReload() })
+	})
+	return libraryIndex
+}
+
+// tracks that finish transcoding go on air with the next reload; a burst of
+// them is picked up in one go.
+const transcodeReloadDelay = 5 * time.Second
+
+var transcodeReload struct {
+	mu    sync.Mutex
+	timer *time.Timer
+}
+
+func scheduleTranscodeReload() {
+	transcodeReload.mu.Lock()
+	defer transcodeReload.mu.Unlock()
+	if transcodeReload.timer != nil {
+		return
+	}
+	transcodeReload.timer = time.AfterFunc(transcodeReloadDelay, func() {
+		transcodeReload.mu.Lock()
+		transcodeReload.timer = nil
+		transcodeReload.mu.Unlock()
+
+		saveMediaIndex()
+		reloadActiveSource()
+	})
+}
+
+// loadWhenPlayable loads source, and if nothing in it is playable yet because
+// it's all still being transcoded, waits for the transcodes and tries again.
+// Only for startup, the loop can't sit waiting.
+func loadWhenPlayable(source autoplaySource) ([]TrackMeta, error) {
+	idx := mediaIndex()
+	for {
+		done := idx.Transcoded()
+		list, err := source.load()
+		if len(list) > 0 || idx.Pending() == 0 {
+			return list, err
+		}
+		log.Printf("library: nothing in %q is playable yet, waiting for %d transcode(s)", source, idx.Pending())
+		<-done
+	}
+}
+
+func saveMediaIndex() {
+	if err := mediaIndex().Save(); err != nil {
+		log.Printf("library: unable to save index: %v", err)
+	}
+}
+
+func trackMetaFromLibrary(t library.Track) TrackMeta {
+	artists := t.Artists
+	if artists == nil {
+		artists = []string{}
+	}
+	return TrackMeta{
+		Path:     t.Path,
+		Title:    t.Title,
+		Artists:  artists,
+		Duration: t.Duration,
+		File:     t.File,
+	}
+}
+
+// LoadOpusPlaylist returns all *.opus (Ogg Opus) files under mediaDir (including
+// subfolders) sorted by path, with best-effort Title/Artist extracted from OpusTags.
+// Other formats (flac, mp3, wav, m4a, ogg) are included via the transcode cache.
+// Metadata comes from the library index so unchanged files are not re-read.
 func LoadOpusPlaylist(mediaDir string) ([]TrackMeta, error) {
 	if mediaDir == "" {
 		mediaDir = "media"
 	}
 
-	entries, err := os.ReadDir(mediaDir)
+	idx := mediaIndex()
+	tracks, err := idx.Scan(mediaDir)
 	if err != nil {
 		return nil, err
 	}
+	defer saveMediaIndex()
 
-	paths := make([]string, 0, len(entries))
-	for _, e := range entries {
-		if e.IsDir() {
-			continue
-		}
-		if filepath.Ext(e.Name()) == ".opus" { // ONLY .opus
-			paths = append(paths, filepath.Join(mediaDir, e.Name()))
-		}
+	if len(tracks) == 0 {
+		return nil, errors.New("no playable files found")
 	}
 
-	if len(paths) == 0 {
-		return nil, errors.New("no .opus files found")
-	}
-
-	out := make([]TrackMeta, 0, len(paths))
-	for _, p := range paths {
-		title, artists := readOpusTagsBestEffort(p)
-		if title == "" {
-			title = strings.TrimSuffix(filepath.Base(p), filepath.Ext(p))
-		}
-		out = append(out, TrackMeta{
-			Path:    p,
-			Title:   title,
-			Artists: artists,
-		})
+	out := make([]TrackMeta, 0, len(tracks))
+	for _, t := range tracks {
+		out = append(out, trackMetaFromLibrary(t))
 	}
 
 	return out, nil
 }
 
-func readOpusTagsBestEffort(path string) (title string, artists []string) {
-	f, err := os.Open(path)
+// LoadPlaylistFile returns the playable entries of an M3U/M3U8, PLS or XSPF playlist
+// in playlist order. Titles/artists from the playlist (e.g. #EXTINF) win over OpusTags.
+func LoadPlaylistFile(playlistPath string) ([]TrackMeta, error) {
+	entries, err := playlist.Load(playlistPath)
 	if err != nil {
-		return "", []string{}
+		return nil, err
 	}
-	defer func() { _ = f.Close() }()
+	defer saveMediaIndex()
 
-	r, err := oggreader.NewWithOptions(f, oggreader.WithDoChecksum(false))
-	if err != nil {
-		return "", []string{}
+	out := playlistTracks(playlistPath, entries)
+	if len(out) == 0 {
+		return nil, fmt.Errorf("no playable entries in %q", playlistPath)
 	}
 
-	var artistVals []string
+	return out, nil
+}
 
-	for {
-		payload, pageHeader, err := r.ParseNextPage()
-		if errors.Is(err, io.EOF) {
-			break
-		}
-		if err != nil || pageHeader == nil || len(payload) < 8 {
-			break
+func playlistTracks(playlistPath string, entries []playlist.Entry) []TrackMeta {
+	idx := mediaIndex()
+	out := make([]TrackMeta, 0, len(entries))
+	for _, e := range entries {
+		if !idx.Accepts(e.Path) {
+			log.Printf("playlist %q: skipping unsupported entry %q", playlistPath, e.Path)
+			continue
 		}
 
-		ht, ok := pageHeader.HeaderType(payload)
-		if !ok || ht != oggreader.HeaderOpusTags {
+		t, err := idx.Lookup(e.Path)
+		if errors.Is(err, library.ErrTranscoding) {
 			continue
 		}
-
-		tags, err := oggreader.ParseOpusTags(payload)
 		if err != nil {
-			break
+			log.Printf("playlist %q: skipping entry %q: %v", playlistPath, e.Path, err)
+			continue
 		}
 
-		for _, c := range tags.UserComments {
-			key := strings.ToLower(strings.TrimSpace(c.Comment))
-			val := strings.TrimSpace(c.Value)
-
-			switch key {
-			case "title":
-				if title == "" && val != "" {
-					title = val
-				}
-			case "artist":
-				if val != "" {
-					artistVals = append(artistVals, val)
-				}
-			}
+		m := trackMetaFromLibrary(t)
+		if e.Title != "" {
+			m.Title = e.Title
 		}
-
-		break
-	}
-
-	// normalize + de-dupe artists (never return nil)
-	seen := map[string]struct{}{}
-	out := make([]string, 0, len(artistVals))
-	for _, v := range artistVals {
-		for _, a := range splitArtists(v) {
-			if a == "" {
-				continue
-			}
-			if _, ok := seen[a]; ok {
-				continue
-			}
-			seen[a] = struct{}{}
-			out = append(out, a)
+		if e.Artist != "" {
+			m.Artists = library.SplitArtists(e.Artist)
 		}
-	}
-
-	return title, out
-}
-
-func splitArtists(v string) []string {
-	s := strings.TrimSpace(v)
-	if s == "" {
-		return nil
-	}
-
-	// avoid splitting on commas in case artist names contain commas
-	seps := []string{" feat. ", " ft. ", " featuring ", ";", " & ", "/", " x "}
-	out := []string{s}
-	for _, sep := range seps {
-		var next []string
-		for _, cur := range out {
-			parts := strings.Split(cur, sep)
-			for _, p := range parts {
-				p = strings.TrimSpace(p)
-				if p != "" {
-					next = append(next, p)
-				}
-			}
+		if m.Duration <= 0 {
+			m.Duration = e.Duration
 		}
-		out = next
+
+		out = append(out, m)
 	}
 	return out
 }
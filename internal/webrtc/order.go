@@ -0,0 +1,267 @@
+package webrtc
+
+import (
+	crand "crypto/rand"
+	"encoding/binary"
+	"log"
+	mrand "math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PLAYBACK_ORDER modes.
+const (
+	orderSorted          = "sorted"
+	orderShuffle         = "shuffle"
+	orderShuffleNoRepeat = "shuffle-norepeat"
+	orderArtistShuffle   = "artist-shuffle"
+
+	defaultArtistSeparation = 3
+)
+
+// playOrder decides the running order of one pass ("cycle") over the list.
+// Every cycle is derived from seed+cycle so the order is reproducible, and the
+// seed is saved alongside the playback state.
+type playOrder struct {
+	mode      string
+	seed      int64
+	cycle     int
+	artistGap int
+}
+
+var (
+	orderMu      sync.Mutex
+	currentOrder playOrder
+)
+
+func parseOrderMode(raw string) string {
+	switch mode := strings.ToLower(strings.TrimSpace(raw)); mode {
+	case "", orderSorted:
+		return orderSorted
+	case orderShuffle, orderShuffleNoRepeat, orderArtistShuffle:
+		return mode
+	default:
+		log.Printf("autoplay: unknown PLAYBACK_ORDER %q; using %q", raw, orderSorted)
+		return orderSorted
+	}
+}
+
+// initPlayOrder sets up the order for a (re)started loop. SHUFFLE_SEED pins the
+// seed; otherwise a saved seed for the same mode is reused, or a new one is drawn.
+func initPlayOrder() {
+	o := playOrder{
+		mode:      parseOrderMode(os.Getenv("PLAYBACK_ORDER")),
+		artistGap: defaultArtistSeparation,
+	}
+
+	if raw := strings.TrimSpace(os.Getenv("ARTIST_SEPARATION")); raw != "" {
+		if n, err := strconv.Atoi(raw); err == nil && n >= 0 {
+			o.artistGap = n
+		}
+	}
+
+	if raw := strings.TrimSpace(os.Getenv("SHUFFLE_SEED")); raw != "" {
+		if seed, err := strconv.ParseInt(raw, 10, 64); err == nil {
+			o.seed = seed
+		} else {
+			log.Printf("autoplay: ignoring invalid SHUFFLE_SEED %q", raw)
+		}
+	}
+
+	if o.mode != orderSorted {
+		if epoch, ok := syncEpoch(); ok && o.seed == 0 {
+			// every replica has to come up with the same order on its own.
+			o.seed = epoch.Unix()
+		} else if st, ok := loadPlaybackState(); ok && st.Order == o.mode && (o.seed == 0 || o.seed == st.Seed) {
+			o.seed = st.Seed
+			o.cycle = st.Cycle
+		}
+		if o.seed == 0 {
+			o.seed = newShuffleSeed()
+		}
+		log.Printf("autoplay: playback order %q (seed %d)", o.mode, o.seed)
+	}
+
+	orderMu.Lock()
+	currentOrder = o
+	orderMu.Unlock()
+}
+
+func newShuffleSeed() int64 {
+	var b [8]byte
+	if _, err := crand.Read(b[:]); err != nil {
+		return time.Now().UnixNano()
+	}
+	if seed := int64(binary.LittleEndian.Uint64(b[:]) >> 1); seed != 0 {
+		return seed
+	}
+	return 1
+}
+
+func playOrderSnapshot() playOrder {
+	orderMu.Lock()
+	defer orderMu.Unlock()
+
+	return currentOrder
+}
+
+// orderPlaylist returns list in the running order of the current cycle.
+// With SYNC_EPOCH the order never changes between cycles, since the clock
+// position has to map onto the same list every time.
+func orderPlaylist(list []TrackMeta) []TrackMeta {
+	return playOrderSnapshot().apply(list, nil)
+}
+
+// nextCycle advances to the next pass over the list and returns its order.
+// prev is the pass that just finished, so the seam between them doesn't repeat.
+func nextCycle(list, prev []TrackMeta) []TrackMeta {
+	orderMu.Lock()
+	if _, synced := syncEpoch(); !synced && currentOrder.mode != orderSorted {
+		currentOrder.cycle++
+	}
+	o := currentOrder
+	orderMu.Unlock()
+
+	return o.apply(list, prev)
+}
+
+func (o playOrder) apply(list, prev []TrackMeta) []TrackMeta {
+	if o.mode == orderSorted || len(list) < 2 {
+		return list
+	}
+
+	rng := mrand.New(mrand.NewSource(o.seed + int64(o.cycle)*0x9E3779B9))
+
+	switch o.mode {
+	case orderShuffle:
+		// independent picks: a track can come up again before others have played.
+		out := make([]TrackMeta, len(list))
+		last := lastPath(prev)
+		for i := range out {
+			m := list[rng.Intn(len(list))]
+			if m.Path == last {
+				m = list[rng.Intn(len(list))]
+			}
+			out[i] = m
+			last = m.Path
+		}
+		return out
+
+	case orderShuffleNoRepeat:
+		out := shuffled(list, rng)
+		if last := lastPath(prev); last != "" && out[0].Path == last {
+			out[0], out[len(out)-1] = out[len(out)-1], out[0]
+		}
+		return out
+
+	case orderArtistShuffle:
+		return separateArtists(shuffled(list, rng), prev, o.artistGap)
+	}
+
+	return list
+}
+
+// merge fits a reloaded list into the cycle that's playing. Shuffled orders
+// keep what already played this cycle and only shuffle what hasn't, so
+// nothing comes around twice before the cycle ends.
+func (o playOrder) merge(tracks, list []TrackMeta, finishedPath string, next int) ([]TrackMeta, int) {
+	if _, synced := syncEpoch(); synced || o.mode == orderSorted {
+		// the order has to follow from the list alone.
+		ordered := o.apply(tracks, nil)
+		return ordered, resumeIndex(ordered, finishedPath, next)
+	}
+
+	// what's still there, as it is now.
+	present := make(map[string]TrackMeta, len(tracks))
+	for _, m := range tracks {
+		present[m.Path] = m
+	}
+
+	next = min(next, len(list))
+	played := make([]TrackMeta, 0, next)
+	seen := make(map[string]bool, next)
+	for _, m := range list[:next] {
+		if cur, ok := present[m.Path]; ok && !seen[m.Path] {
+			played = append(played, cur)
+			seen[m.Path] = true
+		}
+	}
+
+	rest := make([]TrackMeta, 0, len(tracks))
+	for _, m := range tracks {
+		if !seen[m.Path] {
+			rest = append(rest, m)
+		}
+	}
+
+	out := append(played, o.apply(rest, played)...)
+	return out, len(played)
+}
+
+func shuffled(list []TrackMeta, rng *mrand.Rand) []TrackMeta {
+	out := make([]TrackMeta, len(list))
+	copy(out, list)
+	rng.Shuffle(len(out), func(i, j int) { out[i], out[j] = out[j], out[i] })
+	return out
+}
+
+func lastPath(list []TrackMeta) string {
+	if len(list) == 0 {
+		return ""
+	}
+	return list[len(list)-1].Path
+}
+
+// separateArtists reorders pool so no artist comes back within gap tracks,
+// greedily taking the first track that fits. When nothing fits (e.g. one
+// artist dominates the library) it takes the next track anyway.
+func separateArtists(pool, prev []TrackMeta, gap int) []TrackMeta {
+	if gap <= 0 {
+		return pool
+	}
+
+	recent := make([]TrackMeta, 0, gap)
+	if len(prev) > gap {
+		prev = prev[len(prev)-gap:]
+	}
+	recent = append(recent, prev...)
+
+	out := make([]TrackMeta, 0, len(pool))
+	remaining := append([]TrackMeta(nil), pool...)
+	for len(remaining) > 0 {
+		pick := 0
+		for i, m := range remaining {
+			if !sharesArtist(m, recent) {
+				pick = i
+				break
+			}
+		}
+
+		m := remaining[pick]
+		remaining = append(remaining[:pick], remaining[pick+1:]...)
+		out = append(out, m)
+
+		recent = append(recent, m)
+		if len(recent) > gap {
+			recent = recent[1:]
+		}
+	}
+
+	return out
+}
+
+func sharesArtist(m TrackMeta, recent []TrackMeta) bool {
+	for _, a := range m.Artists {
+		for _, r := range recent {
+			for _, b := range r.Artists {
+				if strings.EqualFold(a, b) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
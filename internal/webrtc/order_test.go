@@ -0,0 +1,162 @@
+package webrtc
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func testTracks(n int) []TrackMeta {
+	out := make([]TrackMeta, 0, n)
+	for i := range n {
+		out = append(out, TrackMeta{
+			Path:    fmt.Sprintf("%02d.opus", i),
+			Artists: []string{fmt.Sprintf("artist %d", i/2)},
+		})
+	}
+	return out
+}
+
+func paths(list []TrackMeta) []string {
+	out := make([]string, 0, len(list))
+	for _, m := range list {
+		out = append(out, m.Path)
+	}
+	return out
+}
+
+func isPermutation(a, b []TrackMeta) bool {
+	x, y := paths(a), paths(b)
+	sort.Strings(x)
+	sort.Strings(y)
+	return reflect.DeepEqual(x, y)
+}
+
+func TestPlayOrders(t *testing.T) {
+	tracks := testTracks(10)
+
+	tests := []struct {
+		name  string
+		order playOrder
+		check func(t *testing.T, out []TrackMeta)
+	}{
+		{
+			name:  "Sorted",
+			order: playOrder{mode: orderSorted, seed: 42},
+			check: func(t *testing.T, out []TrackMeta) {
+				if !reflect.DeepEqual(out, tracks) {
+					t.Fatalf("expected the list as is but got %v", paths(out))
+				}
+			},
+		},
+		{
+			name:  "Shuffle",
+			order: playOrder{mode: orderShuffle, seed: 42},
+			check: func(t *testing.T, out []TrackMeta) {
+				if len(out) != len(tracks) {
+					t.Fatalf("expected %d picks but got %d", len(tracks), len(out))
+				}
+				for _, m := range out {
+					if indexOfPath(tracks, m.Path) < 0 {
+						t.Fatalf("expected picks from the list but got %q", m.Path)
+					}
+				}
+			},
+		},
+		{
+			name:  "Shuffle No Repeat",
+			order: playOrder{mode: orderShuffleNoRepeat, seed: 42},
+			check: func(t *testing.T, out []TrackMeta) {
+				if !isPermutation(out, tracks) {
+					t.Fatalf("expected every track once but got %v", paths(out))
+				}
+				if reflect.DeepEqual(out, tracks) {
+					t.Fatalf("expected a shuffled order")
+				}
+			},
+		},
+		{
+			name:  "Artist Shuffle",
+			order: playOrder{mode: orderArtistShuffle, seed: 42, artistGap: 1},
+			check: func(t *testing.T, out []TrackMeta) {
+				if !isPermutation(out, tracks) {
+					t.Fatalf("expected every track once but got %v", paths(out))
+				}
+				for i := 1; i < len(out); i++ {
+					if sharesArtist(out[i], out[i-1:i]) {
+						t.Fatalf("expected no artist back to back but got %v", paths(out))
+					}
+				}
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			out := tc.order.apply(tracks, nil)
+			tc.check(t, out)
+
+			// the same seed and cycle always give the same order.
+			if again := tc.order.apply(tracks, nil); !reflect.DeepEqual(again, out) {
+				t.Fatalf("expected %v again but got %v", paths(out), paths(again))
+			}
+
+			next := tc.order
+			next.cycle++
+			if tc.order.mode != orderSorted && reflect.DeepEqual(next.apply(tracks, out), out) {
+				t.Fatalf("expected a new order for the next cycle")
+			}
+		})
+	}
+}
+
+func TestShuffleNoRepeatSeam(t *testing.T) {
+	tracks := testTracks(6)
+	o := playOrder{mode: orderShuffleNoRepeat, seed: 7}
+
+	// a previous cycle that ended on what this one would start with.
+	first := o.apply(tracks, nil)[0]
+	prev := []TrackMeta{{Path: "x"}, first}
+	if out := o.apply(tracks, prev); out[0].Path == first.Path {
+		t.Fatalf("expected %q not to play twice in a row", first.Path)
+	}
+}
+
+func TestMergeMidCycle(t *testing.T) {
+	t.Setenv("SYNC_EPOCH", "")
+
+	tracks := testTracks(8)
+	for _, mode := range []string{orderShuffleNoRepeat, orderArtistShuffle} {
+		t.Run(mode, func(t *testing.T) {
+			o := playOrder{mode: mode, seed: 3, artistGap: 1}
+			list := o.apply(tracks, nil)
+			next := 4 // 4 tracks into the cycle
+
+			// drop one that played and one that hasn't, add a new one.
+			playedGone, unplayedGone := list[1].Path, list[6].Path
+			var reloaded []TrackMeta
+			for _, m := range tracks {
+				if m.Path != playedGone && m.Path != unplayedGone {
+					reloaded = append(reloaded, m)
+				}
+			}
+			reloaded = append(reloaded, TrackMeta{Path: "new.opus", Artists: []string{"someone new"}})
+
+			merged, i := o.merge(reloaded, list, list[next-1].Path, next)
+
+			expectedPlayed := []string{list[0].Path, list[2].Path, list[3].Path}
+			if got := paths(merged[:i]); !reflect.DeepEqual(got, expectedPlayed) {
+				t.Fatalf("expected the played part %v kept but got %v", expectedPlayed, got)
+			}
+			if !isPermutation(merged, reloaded) {
+				t.Fatalf("expected every reloaded track exactly once but got %v", paths(merged))
+			}
+			for _, m := range merged[i:] {
+				if indexOfPath(merged[:i], m.Path) >= 0 {
+					t.Fatalf("expected %q not to come around again this cycle", m.Path)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,304 @@
+package webrtc
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// listener song requests. Requests are mixed in between scheduled tracks (one
+// every REQUEST_EVERY tracks) and limited per hashed client, per track and by
+// queue length.
+
+const (
+	defaultRequestQueueMax    = 10
+	defaultRequestLimit       = 3
+	defaultRequestLimitWindow = time.Hour
+	defaultRequestCooldown    = 2 * time.Hour
+	defaultRequestEvery       = 1
+)
+
+var (
+	ErrRequestsDisabled = errors.New("requests are disabled")
+	ErrUnknownTrack     = errors.New("unknown track")
+	ErrRequestLimit     = errors.New("request limit reached, try again later")
+	ErrTrackCooldown    = errors.New("track was played or requested recently")
+	ErrRequestQueueFull = errors.New("request queue is full")
+)
+
+// RequestItem is a pending listener request as shown on /api/status.
+type RequestItem struct {
+	TrackID     string    `json:"trackId"`
+	Title       string    `json:"title"`
+	Artists     []string  `json:"artists"`
+	RequestedAt time.Time `json:"requestedAt"`
+
+	meta TrackMeta
+}
+
+// RequestableTrack is a track listeners can pick from.
+type RequestableTrack struct {
+	ID         string   `json:"id"`
+	Title      string   `json:"title"`
+	Artists    []string `json:"artists"`
+	DurationMs int64    `json:"durationMs"`
+}
+
+type requestConfig struct {
+	enabled     bool
+	queueMax    int
+	limit       int
+	limitWindow time.Duration
+	cooldown    time.Duration
+	every       int
+}
+
+var requests struct {
+	once sync.Once
+	cfg  requestConfig
+
+	mu           sync.Mutex
+	queue        []RequestItem
+	byClient     map[string][]time.Time // request times inside the limit window
+	swept        time.Time              // when byClient was last cleared of old windows
+	lastPlayed   map[string]time.Time   // by track path, for the cooldown
+	sinceRequest int                    // scheduled tracks since the last request played
+}
+
+func requestSettings() requestConfig {
+	requests.once.Do(func() {
+		requests.cfg = requestConfig{
+			enabled:     envBool("REQUESTS_ENABLED"),
+			queueMax:    envInt("REQUEST_QUEUE_MAX", defaultRequestQueueMax),
+			limit:       envInt("REQUEST_LIMIT", defaultRequestLimit),
+			limitWindow: envDuration("REQUEST_LIMIT_WINDOW", defaultRequestLimitWindow),
+			cooldown:    envDuration("REQUEST_TRACK_COOLDOWN", defaultRequestCooldown),
+			every:       max(envInt("REQUEST_EVERY", defaultRequestEvery), 1),
+		}
+		requests.byClient = map[string][]time.Time{}
+		requests.lastPlayed = map[string]time.Time{}
+	})
+	return requests.cfg
+}
+
+func envInt(name string, fallback int) int {
+	raw := strings.TrimSpace(os.Getenv(name))
+	if raw == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < 0 {
+		log.Printf("ignoring invalid %s %q", name, raw)
+		return fallback
+	}
+	return n
+}
+
+// envDuration parses Go durations or bare seconds.
+func envDuration(name string, fallback time.Duration) time.Duration {
+	raw := strings.TrimSpace(os.Getenv(name))
+	if raw == "" {
+		return fallback
+	}
+	if d, err := time.ParseDuration(raw); err == nil && d >= 0 {
+		return d
+	}
+	if secs, err := strconv.ParseFloat(raw, 64); err == nil && secs >= 0 {
+		return time.Duration(secs * float64(time.Second))
+	}
+	log.Printf("ignoring invalid %s %q", name, raw)
+	return fallback
+}
+
+// TrackID is the public id of a track: a short hash of its path, so listeners
+// never see the layout of the media folder.
+func TrackID(path string) string {
+	sum := sha256.Sum256([]byte(path))
+	return hex.EncodeToString(sum[:6])
+}
+
+// RequestsEnabled reports whether listener requests are turned on.
+func RequestsEnabled() bool {
+	return requestSettings().enabled
+}
+
+// RequestableTracks lists every track in the library.
+func RequestableTracks() []RequestableTrack {
+	list := requestableLibrary()
+	out := make([]RequestableTrack, 0, len(list))
+	for _, m := range list {
+		out = append(out, RequestableTrack{
+			ID:         TrackID(m.Path),
+			Title:      m.Title,
+			Artists:    m.Artists,
+			DurationMs: m.Duration.Milliseconds(),
+		})
+	}
+	return out
+}
+
+// requestableLibrary is every track in the library index whose file is
+// still on disk, so tracks out of rotation can be requested too. Jingles are
+// left out.
+func requestableLibrary() []TrackMeta {
+	jingles := strings.TrimSpace(os.Getenv("JINGLE_DIR"))
+	if jingles != "" {
+		jingles = filepath.Clean(jingles) + string(filepath.Separator)
+	}
+
+	indexed := mediaIndex().Tracks()
+	out := make([]TrackMeta, 0, len(indexed))
+	for _, t := range indexed {
+		m := trackMetaFromLibrary(t)
+		if jingles != "" && strings.HasPrefix(filepath.Clean(m.Path), jingles) {
+			continue
+		}
+		// the index can outlive a file.
+		if _, err := os.Stat(m.playPath()); err != nil {
+			continue
+		}
+		out = append(out, m)
+	}
+	return out
+}
+
+func findTrackByID(id string) (TrackMeta, bool) {
+	for _, m := range requestableLibrary() {
+		if TrackID(m.Path) == id {
+			return m, true
+		}
+	}
+	return TrackMeta{}, false
+}
+
+// RequestTrack queues a listener request for the track with the given id.
+// client is the listener's hashed identity (see viewers.ClientID).
+func RequestTrack(client, id string) (RequestItem, error) {
+	cfg := requestSettings()
+	if !cfg.enabled {
+		return RequestItem{}, ErrRequestsDisabled
+	}
+
+	m, ok := findTrackByID(strings.TrimSpace(id))
+	if !ok {
+		return RequestItem{}, ErrUnknownTrack
+	}
+
+	now := time.Now()
+
+	requests.mu.Lock()
+	defer requests.mu.Unlock()
+
+	if cfg.queueMax > 0 && len(requests.queue) >= cfg.queueMax {
+		return RequestItem{}, ErrRequestQueueFull
+	}
+
+	sweepClientsLocked(now, cfg.limitWindow)
+
+	recent := requests.byClient[client][:0]
+	for _, at := range requests.byClient[client] {
+		if now.Sub(at) < cfg.limitWindow {
+			recent = append(recent, at)
+		}
+	}
+	if len(recent) == 0 {
+		delete(requests.byClient, client)
+	}
+	if cfg.limit > 0 && len(recent) >= cfg.limit {
+		return RequestItem{}, ErrRequestLimit
+	}
+
+	for _, r := range requests.queue {
+		if r.meta.Path == m.Path {
+			return RequestItem{}, ErrTrackCooldown
+		}
+	}
+	if at, ok := requests.lastPlayed[m.Path]; ok && now.Sub(at) < cfg.cooldown {
+		return RequestItem{}, ErrTrackCooldown
+	}
+
+	item := RequestItem{
+		TrackID:     TrackID(m.Path),
+		Title:       m.Title,
+		Artists:     m.Artists,
+		RequestedAt: now,
+		meta:        m,
+	}
+	requests.queue = append(requests.queue, item)
+	requests.byClient[client] = append(recent, now)
+
+	log.Printf("requests: queued %q (%d pending)", filepath.Base(m.Path), len(requests.queue))
+	return item, nil
+}
+
+// sweepClientsLocked forgets clients whose requests have all left the limit
+// window, once per window, so one-off listeners don't pile up.
+func sweepClientsLocked(now time.Time, window time.Duration) {
+	if now.Sub(requests.swept) < window {
+		return
+	}
+	requests.swept = now
+	for client, times := range requests.byClient {
+		if len(times) == 0 || now.Sub(times[len(times)-1]) >= window {
+			delete(requests.byClient, client)
+		}
+	}
+}
+
+// PendingRequests returns the request queue in play order.
+func PendingRequests() []RequestItem {
+	requestSettings()
+
+	requests.mu.Lock()
+	defer requests.mu.Unlock()
+
+	return append([]RequestItem{}, requests.queue...)
+}
+
+// noteTrackPlayed starts the request cooldown for a track that went on air.
+// Scheduled tracks also count towards when the next request is due.
+func noteTrackPlayed(path string, scheduled bool) {
+	requestSettings()
+
+	requests.mu.Lock()
+	requests.lastPlayed[path] = time.Now()
+	if scheduled {
+		requests.sinceRequest++
+	}
+
+	// keep the cooldown map from growing forever.
+	if len(requests.lastPlayed) > 4096 {
+		for p, at := range requests.lastPlayed {
+			if time.Since(at) > requests.cfg.cooldown {
+				delete(requests.lastPlayed, p)
+			}
+		}
+	}
+	requests.mu.Unlock()
+}
+
+// popDueRequest returns the next listener request once enough scheduled
+// tracks have played since the last one.
+func popDueRequest() (TrackMeta, bool) {
+	cfg := requestSettings()
+
+	requests.mu.Lock()
+	defer requests.mu.Unlock()
+
+	if len(requests.queue) == 0 || requests.sinceRequest < cfg.every {
+		return TrackMeta{}, false
+	}
+	item := requests.queue[0]
+	requests.queue = requests.queue[1:]
+	requests.sinceRequest = 0
+
+	log.Printf("requests: playing request %q", filepath.Base(item.meta.Path))
+	return item.meta, true
+}
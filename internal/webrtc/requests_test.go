@@ -0,0 +1,113 @@
+package webrtc
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/philipch07/EggsFM/internal/library"
+)
+
+func TestRequestableLibrary(t *testing.T) {
+	libraryIndexOnce.Do(func() {})
+	saved := libraryIndex
+	libraryIndex = library.OpenIndex("")
+	defer func() { libraryIndex = saved }()
+
+	root := t.TempDir()
+	jingles := filepath.Join(root, "jingles")
+	t.Setenv("JINGLE_DIR", jingles)
+
+	onAir := filepath.Join(root, "rotation", "a.opus")
+	other := filepath.Join(root, "archive", "b.opus")
+	jingle := filepath.Join(jingles, "id.opus")
+	for _, p := range []string{onAir, other, jingle} {
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte("not really opus"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := mediaIndex().Lookup(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	autoplayState.mu.Lock()
+	savedList := control.list
+	control.list = []TrackMeta{{Path: onAir, Title: "From The Playlist"}}
+	autoplayState.mu.Unlock()
+	defer func() {
+		autoplayState.mu.Lock()
+		control.list = savedList
+		autoplayState.mu.Unlock()
+	}()
+
+	// an indexed file that's since been deleted isn't listed.
+	gone := filepath.Join(root, "archive", "gone.opus")
+	if err := os.WriteFile(gone, []byte("not really opus"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mediaIndex().Lookup(gone); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(gone); err != nil {
+		t.Fatal(err)
+	}
+
+	list := requestableLibrary()
+	if got := paths(list); !reflect.DeepEqual(got, []string{other, onAir}) {
+		t.Fatalf("expected the indexed files still on disk but got %q", got)
+	}
+
+	// out of rotation, but still requestable.
+	if m, ok := findTrackByID(TrackID(other)); !ok || m.Path != other {
+		t.Fatalf("expected to find %q but got %q", other, m.Path)
+	}
+	if _, ok := findTrackByID(TrackID(jingle)); ok {
+		t.Fatalf("expected jingles not to be requestable")
+	}
+
+	if err := os.Remove(other); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := findTrackByID(TrackID(other)); ok {
+		t.Fatalf("expected a deleted file not to be requestable")
+	}
+}
+
+func TestSweepClients(t *testing.T) {
+	requestSettings()
+	requests.mu.Lock()
+	saved, savedSwept := requests.byClient, requests.swept
+	defer func() {
+		requests.mu.Lock()
+		requests.byClient, requests.swept = saved, savedSwept
+		requests.mu.Unlock()
+	}()
+
+	now := time.Now()
+	requests.byClient = map[string][]time.Time{
+		"one-off": {now.Add(-2 * time.Hour)},
+		"regular": {now.Add(-2 * time.Hour), now.Add(-time.Minute)},
+	}
+	requests.swept = now.Add(-2 * time.Hour)
+
+	sweepClientsLocked(now, time.Hour)
+	if _, ok := requests.byClient["one-off"]; ok {
+		t.Fatalf("expected the one-off client to be forgotten")
+	}
+	if _, ok := requests.byClient["regular"]; !ok {
+		t.Fatalf("expected a client inside the window to be kept")
+	}
+
+	// not again until another window has passed.
+	requests.byClient["late"] = []time.Time{now.Add(-2 * time.Hour)}
+	sweepClientsLocked(now.Add(time.Minute), time.Hour)
+	if _, ok := requests.byClient["late"]; !ok {
+		t.Fatalf("expected no sweep inside the window")
+	}
+	requests.mu.Unlock()
+}
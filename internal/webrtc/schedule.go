@@ -0,0 +1,90 @@
+package webrtc
+
+import (
+	"log"
+	"strings"
+	"time"
+
+	"github.com/philipch07/EggsFM/internal/playlist"
+	"github.com/philipch07/EggsFM/internal/schedule"
+)
+
+// StartAutoplayWithSchedule plays whatever program sched has on air, switching at
+// slot boundaries. Outside of every slot (and when the schedule has no default)
+// it plays fallback, which can be a media dir or a playlist file.
+func StartAutoplayWithSchedule(sched *schedule.Schedule, fallback string) error {
+	if strings.TrimSpace(fallback) == "" {
+		fallback = "media"
+	}
+
+	autoplayState.mu.Lock()
+	autoplayState.schedule = sched
+	autoplayState.mu.Unlock()
+
+	return startAutoplay(sourceFromPath(fallback))
+}
+
+// CurrentProgram returns the name of the scheduled program on air, if any.
+func CurrentProgram() string {
+	autoplayState.mu.Lock()
+	defer autoplayState.mu.Unlock()
+
+	return autoplayState.program
+}
+
+func sourceFromPath(path string) autoplaySource {
+	if playlist.IsPlaylistFile(path) {
+		return autoplaySource{playlistFile: path}
+	}
+	return autoplaySource{mediaDir: path}
+}
+
+// resolveProgram maps the scheduled program at t onto a source. Gaps without a
+// schedule default fall back to base.
+func resolveProgram(sched *schedule.Schedule, base autoplaySource, t time.Time) (autoplaySource, string) {
+	p := sched.At(t)
+	if p.Source == "" {
+		return base, ""
+	}
+	return sourceFromPath(p.Source), p.Name
+}
+
+// watchScheduleBoundaries wakes up at every slot boundary. Track-end switches are
+// picked up by the loop on its own; immediate ones cut the current track.
+func watchScheduleBoundaries(sched *schedule.Schedule, base autoplaySource, stop <-chan struct{}) {
+	for {
+		now := time.Now()
+		next := sched.NextBoundary(now)
+		if next.IsZero() {
+			return
+		}
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		from := sched.At(next.Add(-time.Nanosecond))
+		to := sched.At(next)
+		if from.Source == "" {
+			from.Source = base.String()
+		}
+		if to.Source == "" {
+			to.Source = base.String()
+		}
+
+		changed, immediate := schedule.Boundary(from, to)
+		if !changed {
+			continue
+		}
+		if immediate {
+			log.Printf("schedule: %q -> %q (immediate)", from.Name, to.Name)
+			interruptAutoplayTrack()
+		} else {
+			log.Printf("schedule: %q -> %q (at end of current track)", from.Name, to.Name)
+		}
+	}
+}
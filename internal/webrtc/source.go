@@ -0,0 +1,135 @@
+package webrtc
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/philipch07/EggsFM/internal/audio"
+)
+
+// IngestSource plays a DJ's Icecast source stream live. Ogg Opus goes straight
+// through to every output, anything else (mp3, aac, ogg vorbis, ...) is
+// transcoded to Opus with ffmpeg first. accepted is called once the DJ has the
+// live slot, before anything is read. It blocks until the stream ends or the
+// DJ is disconnected.
+func IngestSource(name string, body io.ReadCloser, accepted func()) error {
+	if str == nil {
+		return errNotConfigured
+	}
+
+	session, err := reserveLive(name, func() { _ = body.Close() })
+	if err != nil {
+		return err
+	}
+	defer endLive(session)
+
+	if accepted != nil {
+		accepted()
+	}
+
+	br := bufio.NewReaderSize(body, 64*1024)
+	head, err := br.Peek(512)
+	if len(head) == 0 {
+		return fmt.Errorf("source sent no audio: %w", err)
+	}
+
+	var src io.Reader = br
+	if !bytes.Contains(head, []byte("OpusHead")) {
+		out, stop, err := transcodeSource(br, body)
+		if err != nil {
+			return err
+		}
+		defer stop()
+		src = out
+	}
+
+	done, ok := goLive(session)
+	if !ok {
+		return ErrLiveBusy
+	}
+
+	reader := audio.NewOggOpusPacketReader(str.teeReader(src), 48000)
+	nextSend := time.Now()
+
+	for {
+		pkt, dur, _, err := reader.Next()
+		if isAutoplayStopped(done) {
+			return nil
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("source stream: %w", err)
+		}
+		if len(pkt) == 0 {
+			continue
+		}
+		if dur <= 0 {
+			dur = 20 * time.Millisecond
+		}
+
+		sendLivePacket(pkt, dur)
+
+		// source clients send in realtime, but may burst after a hiccup.
+		nextSend = nextSend.Add(dur)
+		if sleep := time.Until(nextSend); sleep > 0 {
+			time.Sleep(sleep)
+		} else {
+			nextSend = time.Now()
+		}
+	}
+}
+
+// transcodeSource runs in through ffmpeg and returns Ogg Opus. stop kills
+// ffmpeg; body is closed first so the stdin copy doesn't hang on the DJ.
+func transcodeSource(in io.Reader, body io.Closer) (io.Reader, func(), error) {
+	bin := strings.TrimSpace(os.Getenv("FFMPEG_BIN"))
+	if bin == "" {
+		bin = "ffmpeg"
+	}
+
+	args := []string{
+		"-hide_banner", "-loglevel", "error",
+		"-i", "pipe:0",
+		"-map", "0:a:0",
+		"-c:a", "libopus",
+		"-b:a", "128k",
+		"-ar", "48000",
+		"-ac", "2",
+		"-page_duration", "100000", // 100ms pages keep the delay down
+		"-f", "ogg",
+		"pipe:1",
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.Command(bin, args...)
+	cmd.Stdin = in
+	cmd.Stderr = &stderr
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, nil, fmt.Errorf("start ffmpeg for source: %w", err)
+	}
+	log.Println("live: source isn't ogg opus, transcoding with ffmpeg")
+
+	stop := func() {
+		_ = body.Close()
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			log.Printf("ffmpeg (source): %s", msg)
+		}
+	}
+	return out, stop, nil
+}
@@ -0,0 +1,232 @@
+package webrtc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	stateSaveInterval = 5 * time.Second
+	stateVersion      = 1
+)
+
+// playbackState is what gets written to STATE_FILE so a deploy or crash
+// resumes where the stream left off instead of at the top of the playlist.
+type playbackState struct {
+	Version        int           `json:"version"`
+	Source         string        `json:"source"`
+	Index          int           `json:"index"`
+	Path           string        `json:"path"`
+	Offset         time.Duration `json:"offset"`
+	CursorPosition time.Duration `json:"cursorPosition"`
+	Order          string        `json:"order,omitempty"`
+	Seed           int64         `json:"seed,omitempty"`
+	Cycle          int           `json:"cycle,omitempty"`
+	SavedAt        time.Time     `json:"savedAt"`
+}
+
+// playhead is the loop's live position, advanced per packet by playOnce.
+var playhead struct {
+	mu     sync.Mutex
+	source string
+	index  int
+	path   string
+	offset time.Duration
+	held   bool // offset stays put, see holdPlayhead
+	dirty  bool
+}
+
+var stateSaveMu sync.Mutex
+
+// stateFile returns STATE_FILE, or "" when persistence is off (the default).
+func stateFile() string {
+	path := strings.TrimSpace(os.Getenv("STATE_FILE"))
+	if strings.EqualFold(path, "off") {
+		return ""
+	}
+	return path
+}
+
+func setPlayhead(source autoplaySource, index int, path string, offset time.Duration) {
+	playhead.mu.Lock()
+	playhead.source = source.String()
+	playhead.index = index
+	playhead.path = path
+	playhead.offset = offset
+	playhead.held = false
+	playhead.dirty = true
+	playhead.mu.Unlock()
+}
+
+// holdPlayhead keeps the offset where it is until the next setPlayhead, for
+// audio that isn't the track the playhead points at (jingles).
+func holdPlayhead() {
+	playhead.mu.Lock()
+	playhead.held = true
+	playhead.mu.Unlock()
+}
+
+func advancePlayhead(d time.Duration) {
+	playhead.mu.Lock()
+	if playhead.held {
+		playhead.mu.Unlock()
+		return
+	}
+	playhead.offset += d
+	playhead.dirty = true
+	playhead.mu.Unlock()
+}
+
+// savePlaybackState writes the playhead to STATE_FILE if it moved since the last save.
+func savePlaybackState() {
+	path := stateFile()
+	if path == "" {
+		return
+	}
+
+	playhead.mu.Lock()
+	if !playhead.dirty || playhead.path == "" {
+		playhead.mu.Unlock()
+		return
+	}
+	st := playbackState{
+		Version: stateVersion,
+		Source:  playhead.source,
+		Index:   playhead.index,
+		Path:    playhead.path,
+		Offset:  playhead.offset,
+		SavedAt: time.Now(),
+	}
+	playhead.dirty = false
+	playhead.mu.Unlock()
+
+	if str != nil && str.cursor != nil {
+		st.CursorPosition = str.cursor.Position()
+	}
+	if o := playOrderSnapshot(); o.mode != orderSorted {
+		st.Order, st.Seed, st.Cycle = o.mode, o.seed, o.cycle
+	}
+
+	if err := writePlaybackState(path, st); err != nil {
+		log.Printf("autoplay: unable to save playback state: %v", err)
+	}
+}
+
+func writePlaybackState(path string, st playbackState) error {
+	stateSaveMu.Lock()
+	defer stateSaveMu.Unlock()
+
+	raw, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return fmt.Errorf("create state dir: %w", err)
+		}
+	}
+
+	// write + rename so a crash mid-write never leaves a truncated state behind
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0o644); err != nil {
+		return fmt.Errorf("write state: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("replace state: %w", err)
+	}
+
+	return nil
+}
+
+func loadPlaybackState() (playbackState, bool) {
+	path := stateFile()
+	if path == "" {
+		return playbackState{}, false
+	}
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Printf("autoplay: unable to read playback state %q: %v", path, err)
+		}
+		return playbackState{}, false
+	}
+
+	var st playbackState
+	if err := json.Unmarshal(raw, &st); err != nil {
+		log.Printf("autoplay: ignoring corrupt playback state %q: %v", path, err)
+		return playbackState{}, false
+	}
+	if st.Version != stateVersion {
+		log.Printf("autoplay: ignoring playback state %q with version %d", path, st.Version)
+		return playbackState{}, false
+	}
+
+	return st, true
+}
+
+// persistPlaybackState saves the playhead every few seconds until stop is closed.
+func persistPlaybackState(stop <-chan struct{}) {
+	ticker := time.NewTicker(stateSaveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			savePlaybackState()
+		}
+	}
+}
+
+// SavePlaybackState flushes the current playhead to STATE_FILE, e.g. on shutdown.
+func SavePlaybackState() {
+	savePlaybackState()
+}
+
+// restoredPosition maps a saved state onto list. The track is matched by path
+// first since the index shifts whenever files are added or removed.
+func restoredPosition(source autoplaySource, list []TrackMeta) (int, time.Duration, bool) {
+	st, ok := loadPlaybackState()
+	if !ok || st.Source != source.String() || len(list) == 0 {
+		return 0, 0, false
+	}
+
+	idx := -1
+	for i, m := range list {
+		if m.Path == st.Path {
+			idx = i
+			break
+		}
+	}
+
+	offset := max(st.Offset, 0)
+	if idx < 0 {
+		// the track is gone; carry on with whatever took its place.
+		if st.Index < 0 || st.Index >= len(list) {
+			return 0, 0, false
+		}
+		idx, offset = st.Index, 0
+	}
+
+	// saved right at the end of a track, start the next one instead.
+	if d := list[idx].Duration; d > 0 && offset >= d-time.Second {
+		idx, offset = (idx+1)%len(list), 0
+	}
+
+	if str != nil && str.cursor != nil && st.CursorPosition > 0 {
+		str.cursor.Reset(time.Now().Add(-st.CursorPosition), st.CursorPosition)
+	}
+
+	log.Printf("autoplay: restored playback state from %s; starting track %d at %s", st.SavedAt.Format(time.RFC3339), idx, offset.Round(time.Millisecond))
+	return idx, offset, true
+}
@@ -0,0 +1,108 @@
+package webrtc
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHoldPlayhead(t *testing.T) {
+	source := autoplaySource{mediaDir: "media"}
+
+	// a jingle before track 3: the playhead stays at its start.
+	setPlayhead(source, 3, "media/next.opus", 0)
+	holdPlayhead()
+	advancePlayhead(5 * time.Second)
+
+	playhead.mu.Lock()
+	index, path, offset := playhead.index, playhead.path, playhead.offset
+	playhead.mu.Unlock()
+	if index != 3 || path != "media/next.opus" || offset != 0 {
+		t.Fatalf("expected 3 media/next.opus 0s but got %d %s %s", index, path, offset)
+	}
+
+	// the track itself moves it again.
+	setPlayhead(source, 3, "media/next.opus", 0)
+	advancePlayhead(time.Second)
+
+	playhead.mu.Lock()
+	offset = playhead.offset
+	playhead.mu.Unlock()
+	if offset != time.Second {
+		t.Fatalf("expected 1s but got %s", offset)
+	}
+}
+
+func TestRestoredPosition(t *testing.T) {
+	source := autoplaySource{mediaDir: "media"}
+	saved := []TrackMeta{
+		{Path: "media/a.opus", Duration: time.Minute},
+		{Path: "media/b.opus", Duration: time.Minute},
+		{Path: "media/c.opus", Duration: time.Minute},
+	}
+
+	for _, tc := range []struct {
+		name           string
+		index          int
+		path           string
+		offset         time.Duration
+		source         autoplaySource
+		list           []TrackMeta
+		expectedIndex  int
+		expectedOffset time.Duration
+		expectedOK     bool
+	}{
+		{"same playlist", 1, "media/b.opus", 20 * time.Second, source, saved, 1, 20 * time.Second, true},
+		{
+			"track moved", 1, "media/b.opus", 20 * time.Second, source,
+			[]TrackMeta{{Path: "media/new.opus"}, saved[0], saved[1], saved[2]},
+			2, 20 * time.Second, true,
+		},
+		{
+			"track removed", 1, "media/b.opus", 20 * time.Second, source,
+			[]TrackMeta{saved[0], saved[2]},
+			1, 0, true,
+		},
+		{"track removed past the end", 2, "media/c.opus", 20 * time.Second, source, saved[:2], 0, 0, false},
+		{"saved at the end of a track", 2, "media/c.opus", 59500 * time.Millisecond, source, saved, 0, 0, true},
+		{"other source", 1, "media/b.opus", 20 * time.Second, autoplaySource{mediaDir: "other"}, saved, 0, 0, false},
+		{"empty playlist", 1, "media/b.opus", 20 * time.Second, source, nil, 0, 0, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("STATE_FILE", filepath.Join(t.TempDir(), "state", "autoplay.json"))
+
+			setPlayhead(source, tc.index, tc.path, tc.offset)
+			savePlaybackState()
+
+			index, offset, ok := restoredPosition(tc.source, tc.list)
+			if ok != tc.expectedOK || index != tc.expectedIndex || offset != tc.expectedOffset {
+				t.Fatalf("expected %d %s %v but got %d %s %v", tc.expectedIndex, tc.expectedOffset, tc.expectedOK, index, offset, ok)
+			}
+		})
+	}
+
+	t.Run("missing file", func(t *testing.T) {
+		t.Setenv("STATE_FILE", filepath.Join(t.TempDir(), "autoplay.json"))
+
+		if _, _, ok := restoredPosition(source, saved); ok {
+			t.Fatalf("expected nothing to restore")
+		}
+	})
+
+	t.Run("off by default", func(t *testing.T) {
+		dir := t.TempDir()
+		t.Chdir(dir)
+		t.Setenv("STATE_FILE", "")
+
+		setPlayhead(source, 1, "media/b.opus", 20*time.Second)
+		savePlaybackState()
+
+		if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+			t.Fatalf("expected no state file but got %v", entries)
+		}
+		if _, _, ok := restoredPosition(source, saved); ok {
+			t.Fatalf("expected nothing to restore")
+		}
+	})
+}
@@ -0,0 +1,120 @@
+package webrtc
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// syncTolerance is how far playback may drift from the wall clock before we
+// seek to catch up at the next track boundary.
+const syncTolerance = time.Second
+
+var (
+	epochWarnOnce    sync.Once
+	durationWarnOnce sync.Once
+)
+
+// syncEpoch returns SYNC_EPOCH (RFC3339 or unix seconds). When set, the track
+// and offset are derived from the wall clock so restarts and replicas with the
+// same library all land on the same audio.
+func syncEpoch() (time.Time, bool) {
+	raw := strings.TrimSpace(os.Getenv("SYNC_EPOCH"))
+	if raw == "" {
+		return time.Time{}, false
+	}
+
+	if t, err := time.Parse(time.RFC3339, raw); err == nil {
+		return t, true
+	}
+	if secs, err := strconv.ParseInt(raw, 10, 64); err == nil {
+		return time.Unix(secs, 0), true
+	}
+
+	epochWarnOnce.Do(func() {
+		log.Printf("autoplay: ignoring invalid SYNC_EPOCH %q (want RFC3339 or unix seconds)", raw)
+	})
+	return time.Time{}, false
+}
+
+// clockPosition maps now onto the list looping forever since epoch.
+// It needs every track's duration; ok is false if any is unknown.
+func clockPosition(list []TrackMeta, epoch, now time.Time) (index int, offset time.Duration, ok bool) {
+	var total time.Duration
+	for _, m := range list {
+		if m.Duration <= 0 {
+			durationWarnOnce.Do(func() {
+				log.Printf("autoplay: %q has no known duration; wall-clock sync disabled for this list", m.Path)
+			})
+			return 0, 0, false
+		}
+		total += m.Duration
+	}
+	if total <= 0 {
+		return 0, 0, false
+	}
+
+	elapsed := now.Sub(epoch) % total
+	if elapsed < 0 {
+		elapsed += total
+	}
+
+	for i, m := range list {
+		if elapsed < m.Duration {
+			return i, elapsed, true
+		}
+		elapsed -= m.Duration
+	}
+
+	return 0, 0, true
+}
+
+// syncedNext decides what to play once the track before next has finished.
+// Small drift is tolerated, anything bigger seeks to where the clock says we are.
+func syncedNext(list []TrackMeta, next int, epoch, now time.Time) (int, time.Duration) {
+	idx, off, ok := clockPosition(list, epoch, now)
+	if !ok {
+		return next, 0
+	}
+
+	if idx == next {
+		if off < syncTolerance {
+			off = 0
+		}
+		return idx, off
+	}
+
+	// we're a touch ahead of the clock; the previous track is just about done.
+	prev := (next - 1 + len(list)) % len(list)
+	if idx == prev && list[prev].Duration-off < syncTolerance {
+		return next, 0
+	}
+
+	log.Printf("autoplay: drifted from wall clock; jumping to track %d at %s", idx, off.Round(time.Millisecond))
+	return idx, off
+}
+
+// initialPosition picks where a freshly (re)started loop begins: the wall-clock
+// position when SYNC_EPOCH is set, then the saved STATE_FILE, otherwise the top
+// of the list at RESUME_TIMESTAMP/RANDOM_TIMESTAMP.
+func initialPosition(source autoplaySource, list []TrackMeta) (int, time.Duration) {
+	if epoch, ok := syncEpoch(); ok {
+		now := time.Now()
+		if idx, off, ok := clockPosition(list, epoch, now); ok {
+			if str != nil && str.cursor != nil {
+				str.cursor.Reset(epoch, now.Sub(epoch))
+			}
+			log.Printf("autoplay: wall-clock sync from %s; starting track %d at %s", epoch.Format(time.RFC3339), idx, off.Round(time.Millisecond))
+			return idx, off
+		}
+	}
+
+	if idx, off, ok := restoredPosition(source, list); ok {
+		return idx, off
+	}
+
+	return 0, getResumeTimestamp()
+}
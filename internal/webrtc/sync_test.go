@@ -0,0 +1,104 @@
+package webrtc
+
+import (
+	"testing"
+	"time"
+)
+
+var syncList = []TrackMeta{
+	{Path: "a.opus", Duration: time.Minute},
+	{Path: "b.opus", Duration: 2 * time.Minute},
+	{Path: "c.opus", Duration: 3 * time.Minute},
+}
+
+func TestClockPosition(t *testing.T) {
+	epoch := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for _, tc := range []struct {
+		name           string
+		list           []TrackMeta
+		elapsed        time.Duration
+		expectedIndex  int
+		expectedOffset time.Duration
+		expectedOK     bool
+	}{
+		{"at the epoch", syncList, 0, 0, 0, true},
+		{"into the first track", syncList, 30 * time.Second, 0, 30 * time.Second, true},
+		{"on a track boundary", syncList, time.Minute, 1, 0, true},
+		{"on the last boundary", syncList, 3 * time.Minute, 2, 0, true},
+		{"just before a boundary", syncList, 3*time.Minute - time.Millisecond, 1, 2*time.Minute - time.Millisecond, true},
+		{"wraps at the playlist length", syncList, 6 * time.Minute, 0, 0, true},
+		{"wraps past the playlist length", syncList, 7*time.Minute + 30*time.Second, 1, 30 * time.Second, true},
+		{"many loops in", syncList, 1000*6*time.Minute + 4*time.Minute, 2, time.Minute, true},
+		{"before the epoch", syncList, -30 * time.Second, 2, 2*time.Minute + 30*time.Second, true},
+		{"a whole loop before the epoch", syncList, -6 * time.Minute, 0, 0, true},
+		{"zero duration track", []TrackMeta{{Path: "a.opus", Duration: time.Minute}, {Path: "b.opus"}}, time.Minute, 0, 0, false},
+		{"empty playlist", nil, time.Minute, 0, 0, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			index, offset, ok := clockPosition(tc.list, epoch, epoch.Add(tc.elapsed))
+			if ok != tc.expectedOK || index != tc.expectedIndex || offset != tc.expectedOffset {
+				t.Fatalf("expected %d %s %v but got %d %s %v", tc.expectedIndex, tc.expectedOffset, tc.expectedOK, index, offset, ok)
+			}
+		})
+	}
+}
+
+func TestSyncedNext(t *testing.T) {
+	epoch := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for _, tc := range []struct {
+		name           string
+		list           []TrackMeta
+		next           int
+		elapsed        time.Duration
+		expectedIndex  int
+		expectedOffset time.Duration
+	}{
+		{"on time", syncList, 1, time.Minute, 1, 0},
+		{"a little behind", syncList, 1, time.Minute + 500*time.Millisecond, 1, 0},
+		{"behind", syncList, 1, time.Minute + 5*time.Second, 1, 5 * time.Second},
+		{"a little ahead", syncList, 1, time.Minute - 500*time.Millisecond, 1, 0},
+		{"a little ahead at the wrap", syncList, 0, 6*time.Minute - 500*time.Millisecond, 0, 0},
+		{"drifted a track", syncList, 1, 4 * time.Minute, 2, time.Minute},
+		{"before the epoch", syncList, 0, -3 * time.Minute, 2, 0},
+		{"zero duration track", []TrackMeta{{Path: "a.opus"}, {Path: "b.opus"}}, 1, time.Minute, 1, 0},
+		{"empty playlist", nil, 0, time.Minute, 0, 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			index, offset := syncedNext(tc.list, tc.next, epoch, epoch.Add(tc.elapsed))
+			if index != tc.expectedIndex || offset != tc.expectedOffset {
+				t.Fatalf("expected %d %s but got %d %s", tc.expectedIndex, tc.expectedOffset, index, offset)
+			}
+		})
+	}
+}
+
+func TestInitialPosition(t *testing.T) {
+	source := autoplaySource{mediaDir: "media"}
+
+	for _, tc := range []struct {
+		name          string
+		list          []TrackMeta
+		since         time.Duration // how long ago SYNC_EPOCH was
+		expectedIndex int
+		minOffset     time.Duration
+	}{
+		{"follows the clock", syncList, 4 * time.Minute, 2, time.Minute},
+		{"epoch in the future", syncList, -30 * time.Second, 2, 2*time.Minute + 30*time.Second},
+		{"zero duration track falls back", []TrackMeta{{Path: "a.opus"}, {Path: "b.opus"}}, 4 * time.Minute, 0, 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("SYNC_EPOCH", time.Now().Add(-tc.since).Format(time.RFC3339))
+			t.Setenv("STATE_FILE", "")
+			t.Setenv("RESUME_TIMESTAMP", "")
+			t.Setenv("RANDOM_TIMESTAMP", "")
+
+			// SYNC_EPOCH only has second precision, leave room for the rounding.
+			index, offset := initialPosition(source, tc.list)
+			if index != tc.expectedIndex || offset < tc.minOffset || offset > tc.minOffset+2*time.Second {
+				t.Fatalf("expected %d at about %s but got %d %s", tc.expectedIndex, tc.minOffset, index, offset)
+			}
+		})
+	}
+}
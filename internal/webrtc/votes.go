@@ -0,0 +1,201 @@
+package webrtc
+
+import (
+	"errors"
+	"log"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// listener voting. While a track plays, listeners vote on which of the next few
+// tracks in the running order comes after it; the winner plays when the current
+// track ends. Each hashed client gets one vote per round.
+
+const (
+	defaultVoteCandidates = 3
+	maxVoteCandidates     = 5
+)
+
+var (
+	ErrVotingDisabled   = errors.New("voting is disabled")
+	ErrNoVoteRound      = errors.New("no vote is open right now")
+	ErrAlreadyVoted     = errors.New("already voted this round")
+	ErrNotACandidate    = errors.New("track is not a candidate in this round")
+	errTooFewCandidates = errors.New("not enough tracks for a vote")
+)
+
+// VoteCandidate is one option of a round along with its tally.
+type VoteCandidate struct {
+	ID      string   `json:"id"`
+	Title   string   `json:"title"`
+	Artists []string `json:"artists"`
+	Votes   int      `json:"votes"`
+
+	meta TrackMeta
+}
+
+// VoteRound is the open round, as shown on /api/status and /api/vote.
+type VoteRound struct {
+	Round      uint64          `json:"round"`
+	Candidates []VoteCandidate `json:"candidates"`
+	ClosesAt   *time.Time      `json:"closesAt,omitempty"`
+}
+
+var votes struct {
+	once       sync.Once
+	enabled    bool
+	candidates int
+
+	mu     sync.Mutex
+	round  uint64
+	open   *VoteRound
+	voters map[string]struct{}
+}
+
+func voteSettings() (bool, int) {
+	votes.once.Do(func() {
+		votes.enabled = envBool("VOTING_ENABLED")
+		votes.candidates = min(max(envInt("VOTE_CANDIDATES", defaultVoteCandidates), 2), maxVoteCandidates)
+	})
+	return votes.enabled, votes.candidates
+}
+
+// VotingEnabled reports whether listener voting is turned on.
+func VotingEnabled() bool {
+	enabled, _ := voteSettings()
+	return enabled
+}
+
+// CurrentVote returns a copy of the open round, or nil if there isn't one.
+func CurrentVote() *VoteRound {
+	if !VotingEnabled() {
+		return nil
+	}
+
+	votes.mu.Lock()
+	defer votes.mu.Unlock()
+
+	if votes.open == nil {
+		return nil
+	}
+	round := *votes.open
+	round.Candidates = append([]VoteCandidate{}, votes.open.Candidates...)
+	return &round
+}
+
+// CastVote records client's vote for the candidate with the given track id.
+func CastVote(client, id string) (*VoteRound, error) {
+	if !VotingEnabled() {
+		return nil, ErrVotingDisabled
+	}
+	id = strings.TrimSpace(id)
+
+	votes.mu.Lock()
+	if votes.open == nil {
+		votes.mu.Unlock()
+		return nil, ErrNoVoteRound
+	}
+	if _, ok := votes.voters[client]; ok {
+		votes.mu.Unlock()
+		return nil, ErrAlreadyVoted
+	}
+
+	found := false
+	for i := range votes.open.Candidates {
+		if votes.open.Candidates[i].ID == id {
+			votes.open.Candidates[i].Votes++
+			found = true
+			break
+		}
+	}
+	if !found {
+		votes.mu.Unlock()
+		return nil, ErrNotACandidate
+	}
+	votes.voters[client] = struct{}{}
+	votes.mu.Unlock()
+
+	return CurrentVote(), nil
+}
+
+// openVoteRound starts a round for what comes after current, drawing candidates
+// from the running order starting at next. It does nothing while a round is open.
+func openVoteRound(list []TrackMeta, next int, current TrackMeta, remaining time.Duration) {
+	enabled, n := voteSettings()
+	if !enabled || len(list) == 0 {
+		return
+	}
+
+	votes.mu.Lock()
+	defer votes.mu.Unlock()
+
+	if votes.open != nil {
+		return
+	}
+
+	candidates, err := pickVoteCandidates(list, next, current.Path, n)
+	if err != nil {
+		return
+	}
+
+	votes.round++
+	round := &VoteRound{Round: votes.round, Candidates: candidates}
+	if remaining > 0 {
+		closesAt := time.Now().Add(remaining)
+		round.ClosesAt = &closesAt
+	}
+	votes.open = round
+	votes.voters = map[string]struct{}{}
+}
+
+func pickVoteCandidates(list []TrackMeta, next int, exclude string, n int) ([]VoteCandidate, error) {
+	seen := map[string]struct{}{exclude: {}}
+	out := make([]VoteCandidate, 0, n)
+	for k := 0; k < len(list) && len(out) < n; k++ {
+		m := list[(next+k)%len(list)]
+		if _, dup := seen[m.Path]; dup {
+			continue
+		}
+		seen[m.Path] = struct{}{}
+		out = append(out, VoteCandidate{
+			ID:      TrackID(m.Path),
+			Title:   m.Title,
+			Artists: m.Artists,
+			meta:    m,
+		})
+	}
+	if len(out) < 2 {
+		return nil, errTooFewCandidates
+	}
+	return out, nil
+}
+
+// closeVoteRound ends the open round and returns the winner, if anyone voted.
+// Ties go to the candidate that comes first in the running order.
+func closeVoteRound() (TrackMeta, bool) {
+	votes.mu.Lock()
+	defer votes.mu.Unlock()
+
+	round := votes.open
+	votes.open = nil
+	votes.voters = nil
+	if round == nil {
+		return TrackMeta{}, false
+	}
+
+	best := -1
+	for i, c := range round.Candidates {
+		if c.Votes > 0 && (best < 0 || c.Votes > round.Candidates[best].Votes) {
+			best = i
+		}
+	}
+	if best < 0 {
+		return TrackMeta{}, false
+	}
+
+	winner := round.Candidates[best]
+	log.Printf("vote: round %d won by %q with %d vote(s)", round.Round, filepath.Base(winner.meta.Path), winner.Votes)
+	return winner.meta, true
+}
@@ -0,0 +1,54 @@
+package webrtc
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/philipch07/EggsFM/internal/viewers"
+)
+
+func TestOneVotePerListener(t *testing.T) {
+	votes.once.Do(func() {})
+	votes.mu.Lock()
+	votes.enabled, votes.candidates = true, 3
+	votes.mu.Unlock()
+	defer func() {
+		closeVoteRound()
+		votes.mu.Lock()
+		votes.enabled = false
+		votes.mu.Unlock()
+	}()
+
+	list := []TrackMeta{{Path: "a.opus"}, {Path: "b.opus"}, {Path: "c.opus"}}
+	openVoteRound(list, 1, list[0], 0)
+
+	vote := func(remote, forwardedFor string) error {
+		r, _ := http.NewRequest(http.MethodPost, "/api/vote", nil)
+		r.RemoteAddr = remote
+		if forwardedFor != "" {
+			r.Header.Set("X-Forwarded-For", forwardedFor)
+		}
+		_, err := CastVote(viewers.ClientID(r), TrackID("b.opus"))
+		return err
+	}
+
+	if err := vote("203.0.113.7:5000", "1.1.1.1"); err != nil {
+		t.Fatalf("expected the first vote to count but got %v", err)
+	}
+	// a made up X-Forwarded-For doesn't make a new listener.
+	if err := vote("203.0.113.7:5001", "2.2.2.2"); !errors.Is(err, ErrAlreadyVoted) {
+		t.Fatalf("expected %v but got %v", ErrAlreadyVoted, err)
+	}
+	if err := vote("198.51.100.9:5000", ""); err != nil {
+		t.Fatalf("expected another listener's vote to count but got %v", err)
+	}
+
+	round := CurrentVote()
+	if round == nil || round.Candidates[0].Votes != 2 {
+		t.Fatalf("expected 2 votes for b.opus but got %+v", round)
+	}
+	if m, ok := closeVoteRound(); !ok || m.Path != "b.opus" {
+		t.Fatalf("expected b.opus to win but got %q", m.Path)
+	}
+}
@@ -46,6 +46,7 @@ type (
 var (
 	str     *stream
 	apiWhep *webrtc.API
+	apiWhip *webrtc.API
 )
 
 var errNotConfigured = errors.New("webrtc not configured")
@@ -93,18 +94,28 @@ func AddHLSTeeWriter(w io.Writer) {
 }
 
 func (s *stream) teeReader(r io.Reader) io.Reader {
+	w := s.teeWriter()
+	if w == nil {
+		return r
+	}
+
+	return io.TeeReader(r, w)
+}
+
+// teeWriter returns the current tee destinations as one writer, nil if there are none.
+func (s *stream) teeWriter() io.Writer {
 	s.hlsWriterLock.RLock()
 	dst := append([]io.Writer(nil), s.hlsWriters...)
 	s.hlsWriterLock.RUnlock()
 
 	if len(dst) == 0 {
-		return r
+		return nil
 	}
 
-	return io.TeeReader(r, &multiBestEffortWriter{
+	return &multiBestEffortWriter{
 		dst:    dst,
 		logged: make([]bool, len(dst)),
-	})
+	}
 }
 
 type multiBestEffortWriter struct {
@@ -168,7 +179,7 @@ func getPublicIP() string {
 	return ip.Query
 }
 
-func createSettingEngine(udpMuxCache map[int]*ice.MultiUDPMuxDefault, tcpMuxCache map[string]ice.TCPMux) (settingEngine webrtc.SettingEngine) {
+func createSettingEngine(isWHIP bool, udpMuxCache map[int]*ice.MultiUDPMuxDefault, tcpMuxCache map[string]ice.TCPMux) (settingEngine webrtc.SettingEngine) {
 	var (
 		NAT1To1IPs   []string
 		networkTypes []webrtc.NetworkType
@@ -228,7 +239,11 @@ func createSettingEngine(udpMuxCache map[int]*ice.MultiUDPMuxDefault, tcpMuxCach
 		udpMuxOpts = append(udpMuxOpts, ice.UDPMuxFromPortWithInterfaceFilter(interfaceFilter))
 	}
 
-	if os.Getenv("UDP_MUX_PORT_WHEP") != "" {
+	if isWHIP && os.Getenv("UDP_MUX_PORT_WHIP") != "" {
+		if udpMuxPort, err = strconv.Atoi(os.Getenv("UDP_MUX_PORT_WHIP")); err != nil {
+			log.Fatal(err)
+		}
+	} else if !isWHIP && os.Getenv("UDP_MUX_PORT_WHEP") != "" {
 		if udpMuxPort, err = strconv.Atoi(os.Getenv("UDP_MUX_PORT_WHEP")); err != nil {
 			log.Fatal(err)
 		}
@@ -400,7 +415,13 @@ func Configure() {
 	apiWhep = webrtc.NewAPI(
 		webrtc.WithMediaEngine(mediaEngine),
 		webrtc.WithInterceptorRegistry(interceptorRegistry),
-		webrtc.WithSettingEngine(createSettingEngine(udpMuxCache, tcpMuxCache)),
+		webrtc.WithSettingEngine(createSettingEngine(false, udpMuxCache, tcpMuxCache)),
+	)
+
+	apiWhip = webrtc.NewAPI(
+		webrtc.WithMediaEngine(mediaEngine),
+		webrtc.WithInterceptorRegistry(interceptorRegistry),
+		webrtc.WithSettingEngine(createSettingEngine(true, udpMuxCache, tcpMuxCache)),
 	)
 }
 
@@ -409,6 +430,7 @@ type ListenerBreakdown struct {
 	WebRTC  int `json:"webrtc"`
 	HLS     int `json:"hls"`
 	Icecast int `json:"icecast"`
+	Opus    int `json:"opus"`
 }
 
 type StreamStatus struct {
@@ -419,6 +441,11 @@ type StreamStatus struct {
 	NowPlaying        string            `json:"nowPlaying"`
 	Artists           []string          `json:"artists"`
 	CursorMs          int64             `json:"cursorMs"`
+	Program           string            `json:"program,omitempty"`
+	Live              bool              `json:"live,omitempty"`
+	Requests          []RequestItem     `json:"requests,omitempty"`
+	Vote              *VoteRound        `json:"vote,omitempty"`
+	HLSLowLatency     bool              `json:"hlsLowLatency,omitempty"`
 }
 
 func GetStreamStatus() []StreamStatus {
@@ -447,5 +474,9 @@ func GetStreamStatus() []StreamStatus {
 		NowPlaying:        title,
 		Artists:           artists,
 		CursorMs:          cursorMs,
+		Program:           CurrentProgram(),
+		Live:              LiveOnAir(),
+		Requests:          PendingRequests(),
+		Vote:              CurrentVote(),
 	}}
 }
@@ -0,0 +1,141 @@
+package webrtc
+
+import (
+	"errors"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/philipch07/EggsFM/internal/audio"
+
+	"github.com/pion/webrtc/v4"
+	"github.com/pion/webrtc/v4/pkg/media/oggwriter"
+)
+
+// WHIP accepts a DJ's offer and returns the answer, plus the session id that
+// ends this set with StopLive. name is shown as the artist while they're on air.
+func WHIP(offer, name string) (string, string, error) {
+	maybePrintOfferAnswer(offer, true)
+
+	if str == nil || apiWhip == nil {
+		return "", "", webrtc.ErrConnectionClosed
+	}
+
+	pc, err := newPeerConnection(apiWhip)
+	if err != nil {
+		return "", "", err
+	}
+
+	session, err := reserveLive(name, func() {
+		if err := pc.Close(); err != nil {
+			log.Println(err)
+		}
+	})
+	if err != nil {
+		_ = pc.Close()
+		return "", "", err
+	}
+	cleanup := func() { endLive(session) }
+
+	pc.OnICEConnectionStateChange(func(state webrtc.ICEConnectionState) {
+		if state == webrtc.ICEConnectionStateFailed || state == webrtc.ICEConnectionStateClosed {
+			cleanup()
+		}
+	})
+
+	pc.OnTrack(func(remote *webrtc.TrackRemote, _ *webrtc.RTPReceiver) {
+		if remote.Kind() != webrtc.RTPCodecTypeAudio {
+			return
+		}
+		ingestWHIP(session, remote)
+		cleanup()
+	})
+
+	if err := pc.SetRemoteDescription(webrtc.SessionDescription{
+		SDP:  offer,
+		Type: webrtc.SDPTypeOffer,
+	}); err != nil {
+		cleanup()
+
+		return "", "", err
+	}
+
+	gatherComplete := webrtc.GatheringCompletePromise(pc)
+	answer, err := pc.CreateAnswer(nil)
+
+	if err != nil {
+		cleanup()
+
+		return "", "", err
+	} else if err = pc.SetLocalDescription(answer); err != nil {
+		cleanup()
+
+		return "", "", err
+	}
+
+	<-gatherComplete
+
+	return maybePrintOfferAnswer(appendAnswer(pc.LocalDescription().SDP), false), session.id, nil
+}
+
+// ingestWHIP forwards the DJ's RTP to every output until they disconnect or go quiet.
+func ingestWHIP(session *liveSession, remote *webrtc.TrackRemote) {
+	if !strings.EqualFold(remote.Codec().MimeType, webrtc.MimeTypeOpus) {
+		log.Printf("live: rejecting %s track, only Opus is supported", remote.Codec().MimeType)
+		return
+	}
+
+	done, ok := goLive(session)
+	if !ok {
+		return
+	}
+
+	// the tee carries Ogg, so the set becomes one more logical stream in the chain.
+	tee := str.teeWriter()
+	if tee == nil {
+		tee = io.Discard
+	}
+	ogg, err := oggwriter.NewWith(tee, 48000, 2)
+	if err != nil {
+		log.Printf("live: unable to start ogg stream for hls/icecast: %v", err)
+		ogg = nil
+	}
+	defer func() {
+		if ogg != nil {
+			_ = ogg.Close()
+		}
+	}()
+
+	var loggedOggErr bool
+	for {
+		if isAutoplayStopped(done) {
+			return
+		}
+		_ = remote.SetReadDeadline(time.Now().Add(liveReadTimeout))
+		pkt, _, err := remote.ReadRTP()
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				log.Printf("live: DJ stream ended: %v", err)
+			}
+			return
+		}
+		if len(pkt.Payload) == 0 {
+			continue
+		}
+
+		dur := audio.OpusPacketDuration(pkt.Payload)
+		if dur <= 0 {
+			dur = 20 * time.Millisecond
+		}
+
+		sendLivePacket(pkt.Payload, dur)
+
+		if ogg != nil {
+			if err := ogg.WriteRTP(pkt); err != nil && !loggedOggErr {
+				log.Printf("live: ogg write error: %v", err)
+				loggedOggErr = true
+			}
+		}
+	}
+}
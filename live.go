@@ -0,0 +1,201 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/philipch07/EggsFM/internal/webhook"
+	"github.com/philipch07/EggsFM/internal/webrtc"
+)
+
+// live DJs can come in over WHIP (/api/whip) or as an Icecast source client
+// (SOURCE/PUT on ICECAST_SOURCE_MOUNT). Either way the key is checked by
+// WEBHOOK_URL when set, otherwise it must equal LIVE_STREAM_KEY (or
+// ICECAST_SOURCE_PASSWORD for source clients). Without a key both are disabled.
+
+// a source client that sends nothing for this long is dropped.
+const sourceReadTimeout = 10 * time.Second
+
+var errInvalidStreamKey = errors.New("invalid stream key")
+
+func authorizeLive(req *http.Request, action, key, expected string) error {
+	if webhookURL := strings.TrimSpace(os.Getenv("WEBHOOK_URL")); webhookURL != "" {
+		_, err := webhook.CallWebhook(webhookURL, action, key, req)
+		return err
+	}
+	if expected == "" || subtle.ConstantTimeCompare([]byte(key), []byte(expected)) != 1 {
+		return errInvalidStreamKey
+	}
+	return nil
+}
+
+func liveStreamKey() string {
+	return strings.TrimSpace(os.Getenv("LIVE_STREAM_KEY"))
+}
+
+// WHIP handler: a DJ publishes here to take over from autoplay, DELETE ends the set.
+func whipHandler(res http.ResponseWriter, req *http.Request) {
+	if os.Getenv("WEBHOOK_URL") == "" && liveStreamKey() == "" {
+		http.NotFound(res, req)
+		return
+	}
+
+	// POST /api/whip starts a set, DELETE on the Location it returns
+	// (/api/whip/<session id>) ends that one.
+	id := strings.Trim(strings.TrimPrefix(req.URL.Path, "/api/whip"), "/")
+	if (id == "" && req.Method != http.MethodPost) || (id != "" && req.Method != http.MethodDelete) {
+		http.Error(res, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	token, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if !ok || strings.TrimSpace(token) == "" {
+		logHTTPError(res, "Authorization was not set", http.StatusUnauthorized)
+		return
+	}
+
+	action := "whip-connect"
+	if req.Method == http.MethodDelete {
+		action = "whip-disconnect"
+	}
+	if err := authorizeLive(req, action, strings.TrimSpace(token), liveStreamKey()); err != nil {
+		logHTTPError(res, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	if req.Method == http.MethodDelete {
+		if err := webrtc.StopLive(id); err != nil {
+			logHTTPError(res, err.Error(), http.StatusNotFound)
+			return
+		}
+		res.WriteHeader(http.StatusOK)
+		return
+	}
+
+	offer, err := io.ReadAll(req.Body)
+	if err != nil {
+		logHTTPError(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	answer, sessionID, err := webrtc.WHIP(string(offer), req.URL.Query().Get("dj"))
+	if errors.Is(err, webrtc.ErrLiveBusy) {
+		logHTTPError(res, err.Error(), http.StatusConflict)
+		return
+	} else if err != nil {
+		logHTTPError(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	res.Header().Add("Location", "/api/whip/"+sessionID)
+	res.Header().Add("Content-Type", "application/sdp")
+	res.WriteHeader(http.StatusCreated)
+	if _, err = fmt.Fprint(res, answer); err != nil {
+		log.Println(err)
+	}
+}
+
+// sourceMount is where source clients connect, "" when disabled.
+func sourceMount() string {
+	mount := strings.TrimSpace(os.Getenv("ICECAST_SOURCE_MOUNT"))
+	if mount == "" {
+		return ""
+	}
+	if os.Getenv("WEBHOOK_URL") == "" && sourcePassword() == "" {
+		log.Println("ICECAST_SOURCE_MOUNT set without a password; source clients disabled")
+		return ""
+	}
+	if !strings.HasPrefix(mount, "/") {
+		mount = "/" + mount
+	}
+	return mount
+}
+
+func sourcePassword() string {
+	if pass := strings.TrimSpace(os.Getenv("ICECAST_SOURCE_PASSWORD")); pass != "" {
+		return pass
+	}
+	return liveStreamKey()
+}
+
+// Icecast source handler for butt, Mixxx, liquidsoap etc. The old SOURCE method
+// has no Content-Length, so the connection is taken over and read raw.
+func icecastSourceHandler(res http.ResponseWriter, req *http.Request) {
+	if req.Method != "SOURCE" && req.Method != http.MethodPut {
+		http.Error(res, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	_, pass, ok := req.BasicAuth()
+	if !ok {
+		res.Header().Set("WWW-Authenticate", `Basic realm="Icecast2 Server"`)
+		http.Error(res, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if err := authorizeLive(req, "icecast-source", pass, sourcePassword()); err != nil {
+		logHTTPError(res, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if webrtc.LiveOnAir() {
+		http.Error(res, "Mountpoint in use", http.StatusForbidden)
+		return
+	}
+
+	conn, rw, err := http.NewResponseController(res).Hijack()
+	if err != nil {
+		logHTTPError(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var body io.Reader = rw.Reader
+	if slices.Contains(req.TransferEncoding, "chunked") {
+		body = httputil.NewChunkedReader(rw.Reader)
+	}
+
+	name := strings.TrimSpace(req.Header.Get("Ice-Name"))
+	log.Printf("icecast source %q connected from %s", name, conn.RemoteAddr())
+
+	err = webrtc.IngestSource(name, &sourceBody{r: body, conn: conn}, func() {
+		status := "HTTP/1.0 200 OK\r\n\r\n"
+		if strings.EqualFold(req.Header.Get("Expect"), "100-continue") {
+			status = "HTTP/1.1 100 Continue\r\n\r\n"
+		}
+		_, _ = rw.WriteString(status)
+		_ = rw.Flush()
+	})
+	if errors.Is(err, webrtc.ErrLiveBusy) {
+		_, _ = rw.WriteString("HTTP/1.0 403 Mountpoint in use\r\n\r\n")
+		_ = rw.Flush()
+	} else if err != nil {
+		log.Printf("icecast source %q: %v", name, err)
+	}
+
+	_ = conn.Close()
+	log.Printf("icecast source %q disconnected", name)
+}
+
+// sourceBody reads the source stream off the hijacked connection, dropping
+// clients that stall.
+type sourceBody struct {
+	r    io.Reader
+	conn net.Conn
+}
+
+func (b *sourceBody) Read(p []byte) (int, error) {
+	_ = b.conn.SetReadDeadline(time.Now().Add(sourceReadTimeout))
+	return b.r.Read(p)
+}
+
+func (b *sourceBody) Close() error {
+	return b.conn.Close()
+}
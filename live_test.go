@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSourceMount(t *testing.T) {
+	for _, tc := range []struct {
+		name, mount, password, key, expected string
+	}{
+		{"disabled", "", "hackme", "", ""},
+		{"no password", "/live", "", "", ""},
+		{"source password", "/live", "hackme", "", "/live"},
+		{"falls back to stream key", "/live", "", "secret", "/live"},
+		{"adds leading slash", "live.ogg", "hackme", "", "/live.ogg"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("WEBHOOK_URL", "")
+			t.Setenv("ICECAST_SOURCE_MOUNT", tc.mount)
+			t.Setenv("ICECAST_SOURCE_PASSWORD", tc.password)
+			t.Setenv("LIVE_STREAM_KEY", tc.key)
+
+			if got := sourceMount(); got != tc.expected {
+				t.Fatalf("expected mount %q but got %q", tc.expected, got)
+			}
+		})
+	}
+}
+
+// sourceRequest sends a raw source client request, the way butt or liquidsoap
+// would, and returns the response or nil if the server just hung up.
+func sourceRequest(t *testing.T, addr, method, auth string) *http.Response {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("expected dial to succeed but got %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	req := method + " /live HTTP/1.0\r\nIce-Name: test\r\nContent-Type: audio/ogg\r\n"
+	if auth != "" {
+		req += "Authorization: Basic " + base64.StdEncoding.EncodeToString([]byte(auth)) + "\r\n"
+	}
+	if _, err := io.WriteString(conn, req+"\r\n"); err != nil {
+		t.Fatalf("expected write to succeed but got %v", err)
+	}
+
+	res, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil
+	} else if err != nil {
+		t.Fatalf("expected a response or hang up but got %v", err)
+	}
+	_ = res.Body.Close()
+	return res
+}
+
+func TestIcecastSourceHandler(t *testing.T) {
+	t.Setenv("WEBHOOK_URL", "")
+	t.Setenv("ICECAST_SOURCE_PASSWORD", "hackme")
+	t.Setenv("LIVE_STREAM_KEY", "")
+
+	srv := httptest.NewServer(http.HandlerFunc(icecastSourceHandler))
+	defer srv.Close()
+	addr := strings.TrimPrefix(srv.URL, "http://")
+
+	for _, tc := range []struct {
+		name, method, auth string
+		status             int // 0 when the connection is taken over
+	}{
+		{"wrong method", http.MethodGet, "source:hackme", http.StatusMethodNotAllowed},
+		{"no login", "SOURCE", "", http.StatusUnauthorized},
+		{"wrong password", "SOURCE", "source:nope", http.StatusUnauthorized},
+		{"put wrong password", http.MethodPut, "source:nope", http.StatusUnauthorized},
+		{"source login", "SOURCE", "source:hackme", 0},
+		{"put login", http.MethodPut, "source:hackme", 0},
+		{"any user name", "SOURCE", "dj:hackme", 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			res := sourceRequest(t, addr, tc.method, tc.auth)
+
+			// with no stream set up an accepted login is hijacked and dropped
+			// before any status line is written
+			if tc.status == 0 {
+				if res != nil {
+					t.Fatalf("expected the connection to be taken over but got %d", res.StatusCode)
+				}
+				return
+			}
+			if res == nil {
+				t.Fatalf("expected status %d but the connection was closed", tc.status)
+			}
+			if res.StatusCode != tc.status {
+				t.Fatalf("expected status %d but got %d", tc.status, res.StatusCode)
+			}
+			if tc.auth == "" && res.Header.Get("WWW-Authenticate") == "" {
+				t.Fatalf("expected a WWW-Authenticate challenge but got none")
+			}
+		})
+	}
+}
+
+func TestWhipHandler(t *testing.T) {
+	t.Setenv("WEBHOOK_URL", "")
+	t.Setenv("LIVE_STREAM_KEY", "secret")
+
+	for _, tc := range []struct {
+		name, method, path, token string
+		expected                  int
+	}{
+		{"get", http.MethodGet, "/api/whip", "secret", http.StatusMethodNotAllowed},
+		{"delete without a session", http.MethodDelete, "/api/whip", "secret", http.StatusMethodNotAllowed},
+		{"post to a session", http.MethodPost, "/api/whip/abc", "secret", http.StatusMethodNotAllowed},
+		{"delete without a key", http.MethodDelete, "/api/whip/abc", "", http.StatusUnauthorized},
+		{"delete with the wrong key", http.MethodDelete, "/api/whip/abc", "guess", http.StatusUnauthorized},
+		{"delete a set that isn't live", http.MethodDelete, "/api/whip/abc", "secret", http.StatusNotFound},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.path, nil)
+			if tc.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tc.token)
+			}
+			res := httptest.NewRecorder()
+			whipHandler(res, req)
+
+			if res.Code != tc.expected {
+				t.Fatalf("expected status %d but got %d", tc.expected, res.Code)
+			}
+		})
+	}
+}
@@ -8,14 +8,19 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/joho/godotenv"
 	"github.com/philipch07/EggsFM/internal/hls"
 	"github.com/philipch07/EggsFM/internal/icecast"
+	"github.com/philipch07/EggsFM/internal/opusstream"
+	"github.com/philipch07/EggsFM/internal/recorder"
+	"github.com/philipch07/EggsFM/internal/schedule"
 	"github.com/philipch07/EggsFM/internal/viewers"
 	"github.com/philipch07/EggsFM/internal/webrtc"
 )
@@ -70,7 +75,7 @@ func statusHandler(res http.ResponseWriter, req *http.Request) {
 		webrtcCount = status[0].ListenerCount
 	}
 	protocolCounts := viewers.Counts()
-	totalCount := webrtcCount + protocolCounts.HLS + protocolCounts.Icecast
+	totalCount := webrtcCount + protocolCounts.HLS + protocolCounts.Icecast + protocolCounts.Opus
 
 	for i := range status {
 		status[i].ListenerCount = totalCount
@@ -78,7 +83,9 @@ func statusHandler(res http.ResponseWriter, req *http.Request) {
 			WebRTC:  webrtcCount,
 			HLS:     protocolCounts.HLS,
 			Icecast: protocolCounts.Icecast,
+			Opus:    protocolCounts.Opus,
 		}
+		status[i].HLSLowLatency = hlsLowLatency()
 	}
 
 	if err := json.NewEncoder(res).Encode(status); err != nil {
@@ -86,6 +93,13 @@ func statusHandler(res http.ResponseWriter, req *http.Request) {
 	}
 }
 
+// hlsLowLatency is HLS_LOW_LATENCY, the player only turns on hls.js's
+// low-latency mode when the playlists have parts.
+func hlsLowLatency() bool {
+	ll, _ := strconv.ParseBool(strings.TrimSpace(os.Getenv("HLS_LOW_LATENCY")))
+	return ll
+}
+
 func corsHandler(next func(w http.ResponseWriter, r *http.Request)) http.HandlerFunc {
 	return func(res http.ResponseWriter, req *http.Request) {
 		res.Header().Set("Access-Control-Allow-Origin", "*")
@@ -124,6 +138,28 @@ func parseDurationEnv(name string, fallback time.Duration) time.Duration {
 	return fallback
 }
 
+// parseSizeEnv reads a byte count, with an optional K/M/G/T suffix (powers of
+// 1024), e.g. "50G".
+func parseSizeEnv(name string) int64 {
+	raw := strings.ToUpper(strings.TrimSpace(os.Getenv(name)))
+	raw = strings.TrimSuffix(strings.TrimSuffix(raw, "B"), "I")
+	if raw == "" {
+		return 0
+	}
+
+	mult := int64(1)
+	if i := strings.IndexAny(raw, "KMGT"); i >= 0 && i == len(raw)-1 {
+		mult = int64(1) << (10 * (strings.IndexByte("KMGT", raw[i]) + 1))
+		raw = strings.TrimSpace(raw[:i])
+	}
+	n, err := strconv.ParseFloat(raw, 64)
+	if err != nil || n <= 0 {
+		log.Printf("ignoring %s=%q", name, os.Getenv(name))
+		return 0
+	}
+	return int64(n * float64(mult))
+}
+
 func startCursorWatchdog(cursor cursorSource, stall time.Duration, hlsStreamer *hls.Streamer, icecastStreamer *icecast.Streamer) {
 	if cursor == nil || stall <= 0 {
 		return
@@ -144,7 +180,7 @@ func startCursorWatchdog(cursor cursorSource, stall time.Duration, hlsStreamer *
 
 		for range ticker.C {
 			pos := cursor.Position()
-			if pos != lastPos {
+			if pos != lastPos || webrtc.AutoplayPaused() {
 				lastPos = pos
 				lastChange = time.Now()
 				continue
@@ -224,12 +260,21 @@ func main() {
 	webrtc.Configure()
 
 	ffmpegBin := os.Getenv("FFMPEG_BIN")
+	ladder, err := hls.ParseLadder(os.Getenv("HLS_LADDER"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	// how far back listeners can rewind, on every output.
+	dvrWindow := parseDurationEnv("DVR_WINDOW", 0)
 	primaryCfg := hls.Config{
-		OutputDir:           os.Getenv("HLS_OUTPUT_DIR"),
 		FfmpegPath:          ffmpegBin,
 		SegmentCacheControl: os.Getenv("HLS_SEGMENT_CACHE_CONTROL"),
 		Cursor:              webrtc.AudioCursor(),
+		Ladder:              ladder,
+		PartDuration:        parseDurationEnv("HLS_PART_DURATION", 0),
+		DVRWindow:           dvrWindow,
 	}
+	primaryCfg.LowLatency = hlsLowLatency()
 
 	hlsStreamer, err := hls.Start(primaryCfg)
 	if err != nil {
@@ -242,19 +287,91 @@ func main() {
 		Cursor:      webrtc.AudioCursor(),
 		StationName: stationName,
 		StreamPath:  "/api/icecast.mp3",
+		DVRWindow:   dvrWindow,
 	}
 	icecastStreamer, err := icecast.Start(icecastCfg)
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	webrtc.OnNowPlaying(func(title string, artists []string) {
+		icecastStreamer.SetStreamTitle(icecast.StreamTitle(title, artists))
+		hlsStreamer.SetNowPlaying(title, artists)
+	})
+
+	opusStreamer := opusstream.New(stationName, dvrWindow)
+
 	webrtc.SetHLSTeeWriter(hlsStreamer.AudioWriter())
 	webrtc.AddHLSTeeWriter(icecastStreamer.AudioWriter())
+	webrtc.AddHLSTeeWriter(opusStreamer.AudioWriter())
+
+	var rec *recorder.Recorder
+	if dir := strings.TrimSpace(os.Getenv("RECORD_DIR")); dir != "" {
+		rec, err = recorder.Start(recorder.Config{
+			Dir:      dir,
+			Rotate:   parseDurationEnv("RECORD_ROTATE", time.Hour),
+			MaxAge:   parseDurationEnv("RECORD_MAX_AGE", 0),
+			MaxBytes: parseSizeEnv("RECORD_MAX_SIZE"),
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		webrtc.AddHLSTeeWriter(rec)
+		webrtc.OnNowPlaying(rec.NowPlaying)
+	}
 
-	mediaDir := os.Getenv("MEDIA_DIR")
-	if err := webrtc.StartAutoplayFromMediaDir(mediaDir); err != nil {
+	relayTargets, err := icecast.ParseRelayTargets(os.Getenv("ICECAST_RELAYS"))
+	if err != nil {
 		log.Fatal(err)
 	}
+	for _, target := range relayTargets {
+		var feed icecast.Feed = icecastStreamer
+		if target.Opus {
+			feed = opusStreamer
+		}
+		relay := icecast.StartRelay(target, feed, stationName)
+		webrtc.OnNowPlaying(func(title string, artists []string) {
+			relay.SetStreamTitle(icecast.StreamTitle(title, artists))
+		})
+		log.Println("Relaying to " + target.String())
+	}
+
+	playlistFile := strings.TrimSpace(os.Getenv("PLAYLIST_FILE"))
+	mediaDir := os.Getenv("MEDIA_DIR")
+	if scheduleFile := strings.TrimSpace(os.Getenv("SCHEDULE_FILE")); scheduleFile != "" {
+		sched, err := schedule.Load(scheduleFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		fallback := playlistFile
+		if fallback == "" {
+			fallback = mediaDir
+		}
+		if err := webrtc.StartAutoplayWithSchedule(sched, fallback); err != nil {
+			log.Fatal(err)
+		}
+	} else if playlistFile != "" {
+		if err := webrtc.StartAutoplayFromPlaylist(playlistFile); err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		if err := webrtc.StartAutoplayFromMediaDir(mediaDir); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// flush the playback position on shutdown so a deploy resumes mid-track.
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		<-sig
+		webrtc.SavePlaybackState()
+		if rec != nil {
+			rec.Close()
+		}
+		os.Exit(0)
+	}()
 
 	stallTimeout := parseDurationEnv("CURSOR_STALL_TIMEOUT", 10*time.Second)
 	startCursorWatchdog(webrtc.AudioCursor(), stallTimeout, hlsStreamer, icecastStreamer)
@@ -282,7 +399,16 @@ func main() {
 	mux := http.NewServeMux()
 
 	mux.HandleFunc("/api/whep", corsHandler(whepHandler))
+	mux.HandleFunc("/api/whip", corsHandler(whipHandler))
+	mux.HandleFunc("/api/whip/", corsHandler(whipHandler))
+	if mount := sourceMount(); mount != "" {
+		log.Println("Accepting icecast source clients on " + mount)
+		mux.HandleFunc(mount, icecastSourceHandler)
+	}
 	mux.HandleFunc("/api/status", corsHandler(statusHandler))
+	mux.HandleFunc("/api/requests", corsHandler(requestsHandler))
+	mux.HandleFunc("/api/vote", corsHandler(voteHandler))
+	mux.HandleFunc("/api/admin/", adminHandler())
 
 	hlsHandler := http.StripPrefix("/api/hls/", hlsStreamer.Handler())
 	mux.HandleFunc("/api/hls/", corsHandler(func(w http.ResponseWriter, r *http.Request) {
@@ -301,6 +427,41 @@ func main() {
 		http.Redirect(w, r, target, http.StatusMovedPermanently)
 	}))
 
+	// the ogg opus the stream is made of, untouched.
+	opusHandler := opusStreamer.Handler()
+	mux.HandleFunc("/api/stream.opus", corsHandler(func(w http.ResponseWriter, r *http.Request) {
+		opusHandler.ServeHTTP(w, r)
+	}))
+
+	// icecast style status for directory sites + monitoring tools.
+	icecastStatus := func() icecast.Status {
+		title, artists := webrtc.CurrentNowPlaying()
+		opus := icecast.Source{
+			Mount:       "/api/stream.opus",
+			ServerType:  "application/ogg",
+			Title:       title,
+			Artist:      strings.Join(artists, ", "),
+			Channels:    2,
+			SampleRate:  48000,
+			StreamStart: webrtc.AudioCursor().StartedAt(),
+		}
+		opus.Listeners = viewers.Counts().Opus
+		opus.ListenerPeak = viewers.Peaks().Opus
+
+		return icecast.Status{
+			StationName: stationName,
+			ServerStart: icecastStreamer.ServerStart(),
+			Sources:     []icecast.Source{icecastStreamer.Source(), opus},
+		}
+	}
+	statusJSONHandler := icecast.StatusJSONHandler(icecastStatus)
+	mux.HandleFunc("/status-json.xsl", corsHandler(func(w http.ResponseWriter, r *http.Request) {
+		statusJSONHandler.ServeHTTP(w, r)
+	}))
+	statsHandler := icecastStatsHandler(icecast.StatsXMLHandler(icecastStatus))
+	mux.HandleFunc("/admin/stats", statsHandler)
+	mux.HandleFunc("/admin/stats.xml", statsHandler)
+
 	icecastPlaylistHandler := icecastStreamer.PlaylistHandler()
 	mux.HandleFunc("/api/icecast.m3u8", corsHandler(func(w http.ResponseWriter, r *http.Request) {
 		icecastPlaylistHandler.ServeHTTP(w, r)
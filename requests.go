@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/philipch07/EggsFM/internal/viewers"
+	"github.com/philipch07/EggsFM/internal/webrtc"
+)
+
+type songRequest struct {
+	ID string `json:"id"`
+}
+
+type requestsResponse struct {
+	Queue  []webrtc.RequestItem      `json:"queue"`
+	Tracks []webrtc.RequestableTrack `json:"tracks"`
+}
+
+// listener requests: GET lists the pending queue + what can be requested,
+// POST {"id": "<track id>"} requests a track.
+func requestsHandler(res http.ResponseWriter, req *http.Request) {
+	if !webrtc.RequestsEnabled() {
+		http.NotFound(res, req)
+		return
+	}
+
+	switch req.Method {
+	case http.MethodGet:
+		writeJSON(res, http.StatusOK, requestsResponse{
+			Queue:  webrtc.PendingRequests(),
+			Tracks: webrtc.RequestableTracks(),
+		})
+
+	case http.MethodPost:
+		client := viewers.ClientID(req)
+		if client == "" {
+			http.Error(res, "unable to identify client", http.StatusBadRequest)
+			return
+		}
+
+		var body songRequest
+		if err := json.NewDecoder(http.MaxBytesReader(res, req.Body, 1<<12)).Decode(&body); err != nil {
+			http.Error(res, "invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		item, err := webrtc.RequestTrack(client, body.ID)
+		if err != nil {
+			http.Error(res, err.Error(), requestErrorStatus(err))
+			return
+		}
+		writeJSON(res, http.StatusCreated, item)
+
+	default:
+		http.Error(res, "Method Not Allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func requestErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, webrtc.ErrUnknownTrack):
+		return http.StatusNotFound
+	case errors.Is(err, webrtc.ErrRequestLimit):
+		return http.StatusTooManyRequests
+	case errors.Is(err, webrtc.ErrTrackCooldown):
+		return http.StatusConflict
+	case errors.Is(err, webrtc.ErrRequestQueueFull):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusBadRequest
+	}
+}
+
+// listener voting: GET returns the open round (null between rounds),
+// POST {"id": "<candidate track id>"} casts this client's vote for it.
+func voteHandler(res http.ResponseWriter, req *http.Request) {
+	if !webrtc.VotingEnabled() {
+		http.NotFound(res, req)
+		return
+	}
+
+	switch req.Method {
+	case http.MethodGet:
+		writeJSON(res, http.StatusOK, webrtc.CurrentVote())
+
+	case http.MethodPost:
+		client := viewers.ClientID(req)
+		if client == "" {
+			http.Error(res, "unable to identify client", http.StatusBadRequest)
+			return
+		}
+
+		var body songRequest
+		if err := json.NewDecoder(http.MaxBytesReader(res, req.Body, 1<<12)).Decode(&body); err != nil {
+			http.Error(res, "invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		round, err := webrtc.CastVote(client, body.ID)
+		if err != nil {
+			http.Error(res, err.Error(), voteErrorStatus(err))
+			return
+		}
+		writeJSON(res, http.StatusOK, round)
+
+	default:
+		http.Error(res, "Method Not Allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func voteErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, webrtc.ErrNoVoteRound), errors.Is(err, webrtc.ErrNotACandidate):
+		return http.StatusNotFound
+	case errors.Is(err, webrtc.ErrAlreadyVoted):
+		return http.StatusConflict
+	default:
+		return http.StatusBadRequest
+	}
+}